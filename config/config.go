@@ -16,8 +16,23 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+)
+
+const (
+	// DefaultProvisioningTimeout is the default amount of time a device may spend
+	// queued or provisioning before it is reported as errored.
+	DefaultProvisioningTimeout = 1 * time.Hour
+	// DefaultOrphanFailedGracePeriod is the default amount of time a device may
+	// stay failed or inactive before it is considered an orphan.
+	DefaultOrphanFailedGracePeriod = 2 * time.Hour
 )
 
 func NewConfig(cfgFile string) (*Config, error) {
@@ -43,6 +58,308 @@ type Config struct {
 	HardwareReservationID *string `toml:"hardware_reservation_id,omitempty"`
 	// ProjectID is the UUID representing the project to use.
 	ProjectID string `toml:"project_id"`
+	// ProvisioningTimeout is the amount of time a device may spend queued or
+	// provisioning before it is reported to GARM as errored. Defaults to 1 hour.
+	ProvisioningTimeout time.Duration `toml:"provisioning_timeout,omitempty"`
+	// ConsoleLogDir is the directory in which console output is saved for devices
+	// that fail or time out while provisioning. Capturing console output is
+	// disabled if empty.
+	ConsoleLogDir string `toml:"console_log_dir,omitempty"`
+	// RecycleDevices enables reinstalling and parking devices for reuse by their
+	// pool when runners are deleted, instead of deleting them. It may be overridden
+	// per pool with the recycle_devices extra spec.
+	RecycleDevices bool `toml:"recycle_devices,omitempty"`
+	// MaxIdleDevices is the maximum number of devices parked for reuse by each
+	// pool. Devices whose runner is deleted while their pool has as many parked
+	// devices are deleted instead. Parked devices are not limited if zero.
+	MaxIdleDevices int `toml:"max_idle_devices,omitempty"`
+	// MaxIdleTime is the maximum amount of time a device may stay parked before it
+	// is deleted. Parked devices are kept until claimed if zero.
+	MaxIdleTime time.Duration `toml:"max_idle_time,omitempty"`
+	// Tags are extra tags set on all devices. Tags are templates rendered with the
+	// variables of the runner, and may be extended per pool with the tags extra
+	// spec. Pool tags replace the tags with the same name.
+	Tags []string `toml:"tags,omitempty"`
+	// Description is the template of the description of all devices. It may be
+	// overridden per pool with the description extra spec.
+	Description string `toml:"description,omitempty"`
+	// TagPrefix is the prefix of the names of the tags set by the provider, such as
+	// "garm.example.com/". The legacy tag names, such as Name and garm-pool-id, are
+	// used if empty.
+	TagPrefix string `toml:"tag_prefix,omitempty"`
+	// SpotMetros are the metros in which the spot devices of the pools may be
+	// created. The metro with the cheapest spot price for the plan of a device is
+	// chosen. It may be overridden per pool with the spot_metros extra spec.
+	SpotMetros []string `toml:"spot_metros,omitempty"`
+	// Orphans holds the rules used to detect devices that GARM no longer manages.
+	Orphans OrphansConfig `toml:"orphans"`
+	// WarmPools holds the pools for which pre-provisioned devices are kept on
+	// standby, to be handed out to new runners.
+	WarmPools []WarmPoolConfig `toml:"warm_pools,omitempty"`
+	// Metrics holds the settings of the Prometheus metrics exported by the provider.
+	Metrics MetricsConfig `toml:"metrics"`
+	// Tracing holds the settings of the OpenTelemetry traces exported by the
+	// provider.
+	Tracing TracingConfig `toml:"tracing"`
+	// TrackCosts records the estimated hourly price of every device created in a
+	// tag, which costs an extra API request per device.
+	TrackCosts bool `toml:"track_costs,omitempty"`
+	// Quotas limits the devices the controller may create.
+	Quotas QuotasConfig `toml:"quotas"`
+	// Reprovision holds the policy used to replace devices which fail to provision.
+	Reprovision ReprovisionConfig `toml:"reprovision"`
+	// RateLimit limits the rate of the requests made to the Equinix Metal API by
+	// all the provider processes.
+	RateLimit RateLimitConfig `toml:"rate_limit"`
+	// Daemon holds the settings of the provider daemon.
+	Daemon DaemonConfig `toml:"daemon"`
+	// DeviceCacheTTL is how long the listing of the devices of the project is
+	// cached in the state directory, and shared by the provider processes. The
+	// cache is invalidated when the provider creates or deletes a device. Devices
+	// are listed from the API on every call if zero.
+	DeviceCacheTTL time.Duration `toml:"device_cache_ttl,omitempty"`
+	// StateDir is the directory holding the state shared by the provider processes,
+	// such as lock files and the operation journal. It is created on first use.
+	// It must be owned by the user running the provider, and not be writable by
+	// other users. Defaults to a
+	// garm-provider-equinix directory in the user cache directory, or in the
+	// system temporary directory if the user has none.
+	StateDir string `toml:"state_dir,omitempty"`
+}
+
+// QuotasConfig limits the devices created by a GARM controller, across all of its
+// pools. Limits set to zero are not enforced.
+type QuotasConfig struct {
+	// MaxDevices is the maximum number of devices of the controller.
+	MaxDevices int `toml:"max_devices,omitempty"`
+	// MaxDevicesPerPlan is the maximum number of devices of the controller, by plan.
+	MaxDevicesPerPlan map[string]int `toml:"max_devices_per_plan,omitempty"`
+	// MaxHourlySpend is the maximum estimated hourly cost of the devices of the
+	// controller, in USD, based on the on-demand price of their plans.
+	MaxHourlySpend float64 `toml:"max_hourly_spend,omitempty"`
+}
+
+// ReprovisionConfig holds the policy used to replace the devices which fail to
+// provision, usually because of a hardware failure. Failed devices are deleted,
+// and created again within the provisioning timeout.
+type ReprovisionConfig struct {
+	// MaxAttempts is the number of times the device of a runner is replaced after
+	// failing to provision. Failed devices are not replaced if zero.
+	MaxAttempts int `toml:"max_attempts,omitempty"`
+	// ExcludeFailedPlacement creates the replacement devices away from the
+	// hardware the device failed on: without its hardware reservation, and in the
+	// first of the fallback metros in which no device of the runner failed.
+	ExcludeFailedPlacement bool `toml:"exclude_failed_placement,omitempty"`
+	// FallbackMetros are the metros in which replacement devices may be created
+	// when ExcludeFailedPlacement is set, in order. Spot devices are replaced in
+	// their metro.
+	FallbackMetros []string `toml:"fallback_metros,omitempty"`
+}
+
+func (r ReprovisionConfig) Validate() error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	for _, metro := range r.FallbackMetros {
+		if metro == "" {
+			return fmt.Errorf("fallback_metros must not contain empty metros")
+		}
+	}
+	return nil
+}
+
+// IsSet returns true if any limit is set.
+func (q QuotasConfig) IsSet() bool {
+	return q.MaxDevices > 0 || len(q.MaxDevicesPerPlan) > 0 || q.MaxHourlySpend > 0
+}
+
+func (q QuotasConfig) Validate() error {
+	if q.MaxDevices < 0 {
+		return fmt.Errorf("max_devices must not be negative")
+	}
+	for plan, max := range q.MaxDevicesPerPlan {
+		if max < 0 {
+			return fmt.Errorf("max_devices_per_plan of %s must not be negative", plan)
+		}
+	}
+	if q.MaxHourlySpend < 0 {
+		return fmt.Errorf("max_hourly_spend must not be negative")
+	}
+	return nil
+}
+
+// DaemonConfig holds the settings of the provider daemon, started with the serve
+// command. The provider executables run by GARM forward their commands to the
+// daemon when it is running.
+type DaemonConfig struct {
+	// Socket is the path of the Unix socket the daemon listens on. The daemon is
+	// disabled if empty.
+	Socket string `toml:"socket,omitempty"`
+}
+
+func (d DaemonConfig) Validate() error {
+	if d.Socket != "" && !filepath.IsAbs(d.Socket) {
+		return fmt.Errorf("socket must be an absolute path")
+	}
+	return nil
+}
+
+// RateLimitConfig limits the rate of the requests made to the Equinix Metal API,
+// across all the provider processes sharing the same state directory.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the average number of requests per second. Rate limiting
+	// is disabled if zero.
+	RequestsPerSecond float64 `toml:"requests_per_second,omitempty"`
+	// Burst is the number of requests that may be made at once, above the average
+	// rate. Defaults to 1.
+	Burst int `toml:"burst,omitempty"`
+}
+
+// IsSet returns true if rate limiting is enabled.
+func (r RateLimitConfig) IsSet() bool {
+	return r.RequestsPerSecond > 0
+}
+
+func (r RateLimitConfig) Validate() error {
+	if r.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests_per_second must not be negative")
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	return nil
+}
+
+// MetricsConfig holds the settings of the Prometheus metrics exported by the
+// provider.
+type MetricsConfig struct {
+	// Textfile is the path of the file the metrics are written to, to be exported
+	// by the node_exporter textfile collector. The file name must end in .prom.
+	// Metrics are disabled if empty.
+	Textfile string `toml:"textfile,omitempty"`
+}
+
+// TracingConfig holds the settings of the OpenTelemetry traces exported by the
+// provider. At most one of OTLPEndpoint and File may be set. Tracing is disabled
+// if neither is set.
+type TracingConfig struct {
+	// OTLPEndpoint is the base URL of an OTLP/HTTP receiver, such as
+	// http://localhost:4318. Spans are sent to its /v1/traces path.
+	OTLPEndpoint string `toml:"otlp_endpoint,omitempty"`
+	// OTLPHeaders are added to the requests sent to the OTLP endpoint.
+	OTLPHeaders map[string]string `toml:"otlp_headers,omitempty"`
+	// File is the path of a file spans are appended to, one JSON object per line
+	// as written by the OpenTelemetry stdout exporter.
+	File string `toml:"file,omitempty"`
+}
+
+func (t TracingConfig) Validate() error {
+	if t.OTLPEndpoint != "" && t.File != "" {
+		return fmt.Errorf("only one of otlp_endpoint and file may be set")
+	}
+	if t.OTLPEndpoint != "" {
+		u, err := url.Parse(t.OTLPEndpoint)
+		if err != nil {
+			return fmt.Errorf("invalid otlp_endpoint: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("otlp_endpoint must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+func (m MetricsConfig) Validate() error {
+	if m.Textfile != "" && filepath.Ext(m.Textfile) != ".prom" {
+		return fmt.Errorf("textfile must have a .prom extension")
+	}
+	return nil
+}
+
+// WarmPoolConfig describes the devices kept on standby for a GARM pool. The plan,
+// metro and hardware reservation must match those of the pool, for the devices to
+// be handed out to its runners.
+type WarmPoolConfig struct {
+	// PoolID is the ID of the GARM pool.
+	PoolID string `toml:"pool_id"`
+	// Size is the number of devices to keep on standby.
+	Size int `toml:"size"`
+	// Plan is the plan (flavor) of the devices.
+	Plan string `toml:"plan"`
+	// OperatingSystem is the operating system the devices are provisioned with. The
+	// devices are reinstalled with the image of the pool when handed out.
+	OperatingSystem string `toml:"operating_system"`
+	// MetroCode is the metro of the devices. Defaults to the metro_code of the
+	// provider config.
+	MetroCode string `toml:"metro_code,omitempty"`
+	// HardwareReservationID is the hardware reservation to create devices on.
+	HardwareReservationID *string `toml:"hardware_reservation_id,omitempty"`
+}
+
+func (w WarmPoolConfig) Validate() error {
+	if w.PoolID == "" {
+		return fmt.Errorf("pool_id is required")
+	}
+	if w.Size < 0 {
+		return fmt.Errorf("size must not be negative")
+	}
+	if w.Plan == "" {
+		return fmt.Errorf("plan is required")
+	}
+	if w.OperatingSystem == "" {
+		return fmt.Errorf("operating_system is required")
+	}
+	return nil
+}
+
+// OrphansConfig holds the rules used to classify devices created by a GARM
+// controller as orphans, which may be garbage collected.
+type OrphansConfig struct {
+	// MaxAge is the maximum time a device may be assigned to its runner. Older
+	// devices are considered orphans. Devices on standby are never too old. A zero
+	// value disables this rule.
+	MaxAge time.Duration `toml:"max_age,omitempty"`
+	// FailedGracePeriod is the amount of time a device may stay failed or inactive
+	// before it is considered an orphan. Defaults to 2 hours.
+	FailedGracePeriod time.Duration `toml:"failed_grace_period,omitempty"`
+	// AllowedPoolIDs is the list of pools that exist in GARM. Devices belonging to
+	// other pools are considered orphans. An empty list disables this rule.
+	AllowedPoolIDs []string `toml:"allowed_pool_ids,omitempty"`
+}
+
+// Merge returns a copy of the rules, with the values set in overrides replacing
+// the configured ones.
+func (o OrphansConfig) Merge(overrides OrphansConfig) OrphansConfig {
+	ret := o
+	if overrides.MaxAge != 0 {
+		ret.MaxAge = overrides.MaxAge
+	}
+	if overrides.FailedGracePeriod != 0 {
+		ret.FailedGracePeriod = overrides.FailedGracePeriod
+	}
+	if len(overrides.AllowedPoolIDs) > 0 {
+		ret.AllowedPoolIDs = overrides.AllowedPoolIDs
+	}
+	return ret
+}
+
+// GetFailedGracePeriod returns the configured failed grace period, or the
+// default if none was set.
+func (o OrphansConfig) GetFailedGracePeriod() time.Duration {
+	if o.FailedGracePeriod == 0 {
+		return DefaultOrphanFailedGracePeriod
+	}
+	return o.FailedGracePeriod
+}
+
+func (o OrphansConfig) Validate() error {
+	if o.MaxAge < 0 {
+		return fmt.Errorf("max_age must not be negative")
+	}
+	if o.FailedGracePeriod < 0 {
+		return fmt.Errorf("failed_grace_period must not be negative")
+	}
+	return nil
 }
 
 func (c *Config) Validate() error {
@@ -57,5 +374,112 @@ func (c *Config) Validate() error {
 	if c.ProjectID == "" {
 		return fmt.Errorf("project_id is required")
 	}
+
+	if c.ProvisioningTimeout < 0 {
+		return fmt.Errorf("provisioning_timeout must not be negative")
+	}
+
+	if c.MaxIdleDevices < 0 {
+		return fmt.Errorf("max_idle_devices must not be negative")
+	}
+
+	if c.MaxIdleTime < 0 {
+		return fmt.Errorf("max_idle_time must not be negative")
+	}
+
+	if c.DeviceCacheTTL < 0 {
+		return fmt.Errorf("device_cache_ttl must not be negative")
+	}
+
+	if err := spec.ValidateTagPrefix(c.TagPrefix); err != nil {
+		return fmt.Errorf("invalid tag_prefix: %w", err)
+	}
+
+	if err := spec.ValidateTemplates(c.Tags, c.Description, c.GetTagNames()); err != nil {
+		return fmt.Errorf("invalid tags or description: %w", err)
+	}
+
+	for _, metro := range c.SpotMetros {
+		if metro == "" {
+			return fmt.Errorf("spot_metros must not contain empty metros")
+		}
+	}
+
+	if err := c.Metrics.Validate(); err != nil {
+		return fmt.Errorf("invalid metrics config: %w", err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("invalid tracing config: %w", err)
+	}
+	if err := c.Quotas.Validate(); err != nil {
+		return fmt.Errorf("invalid quotas config: %w", err)
+	}
+	if err := c.Reprovision.Validate(); err != nil {
+		return fmt.Errorf("invalid reprovision config: %w", err)
+	}
+	if err := c.Daemon.Validate(); err != nil {
+		return fmt.Errorf("invalid daemon config: %w", err)
+	}
+	if err := c.RateLimit.Validate(); err != nil {
+		return fmt.Errorf("invalid rate_limit config: %w", err)
+	}
+	if err := c.Orphans.Validate(); err != nil {
+		return fmt.Errorf("invalid orphans config: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for idx, warmPool := range c.WarmPools {
+		if err := warmPool.Validate(); err != nil {
+			return fmt.Errorf("invalid warm pool %d: %w", idx, err)
+		}
+		if seen[warmPool.PoolID] {
+			return fmt.Errorf("duplicate warm pool %s", warmPool.PoolID)
+		}
+		seen[warmPool.PoolID] = true
+	}
 	return nil
 }
+
+// GetWarmPool returns the warm pool config of a GARM pool. The second return
+// value is false if no devices are kept on standby for the pool.
+func (c *Config) GetWarmPool(poolID string) (WarmPoolConfig, bool) {
+	for _, warmPool := range c.WarmPools {
+		if warmPool.PoolID == poolID {
+			if warmPool.MetroCode == "" {
+				warmPool.MetroCode = c.MetroCode
+			}
+			return warmPool, true
+		}
+	}
+	return WarmPoolConfig{}, false
+}
+
+// GetStateDir returns the configured state directory, or the default if none was
+// set.
+func (c *Config) GetStateDir() string {
+	if c.StateDir != "" {
+		return c.StateDir
+	}
+	// GARM only passes the environment variables it is configured to pass to
+	// external providers, so HOME and with it the user cache directory may be
+	// missing.
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "garm-provider-equinix")
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-equinix")
+}
+
+// GetTagNames returns the names of the tags set by the provider, under the
+// configured tag prefix.
+func (c *Config) GetTagNames() spec.TagNames {
+	return spec.NewTagNames(c.TagPrefix)
+}
+
+// GetProvisioningTimeout returns the configured provisioning timeout, or the
+// default if none was set.
+func (c *Config) GetProvisioningTimeout() time.Duration {
+	if c.ProvisioningTimeout == 0 {
+		return DefaultProvisioningTimeout
+	}
+	return c.ProvisioningTimeout
+}
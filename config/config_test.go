@@ -15,7 +15,11 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -64,6 +68,216 @@ func TestValidate(t *testing.T) {
 			},
 			errString: "project_id is required",
 		},
+		{
+			name: "negative provisioning timeout",
+			cfg: Config{
+				AuthToken:           "token",
+				MetroCode:           "code",
+				ProjectID:           "project",
+				ProvisioningTimeout: -time.Minute,
+			},
+			errString: "provisioning_timeout must not be negative",
+		},
+		{
+			name: "negative max idle devices",
+			cfg: Config{
+				AuthToken:      "token",
+				MetroCode:      "code",
+				ProjectID:      "project",
+				MaxIdleDevices: -1,
+			},
+			errString: "max_idle_devices must not be negative",
+		},
+		{
+			name: "negative max idle time",
+			cfg: Config{
+				AuthToken:   "token",
+				MetroCode:   "code",
+				ProjectID:   "project",
+				MaxIdleTime: -time.Hour,
+			},
+			errString: "max_idle_time must not be negative",
+		},
+		{
+			name: "negative device cache ttl",
+			cfg: Config{
+				AuthToken:      "token",
+				MetroCode:      "code",
+				ProjectID:      "project",
+				DeviceCacheTTL: -time.Second,
+			},
+			errString: "device_cache_ttl must not be negative",
+		},
+		{
+			name: "tag overriding a reserved tag",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Tags:      []string{"Name={{ .RunnerName }}"},
+			},
+			errString: "invalid tags or description: tag \"Name=garm-runner\" uses a name reserved for the tags of the provider",
+		},
+		{
+			name: "tag using the tag prefix",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				TagPrefix: "garm.example.com/",
+				Tags:      []string{"garm.example.com/team=ci"},
+			},
+			errString: "uses a name reserved for the tags of the provider",
+		},
+		{
+			name: "tag prefix with spaces",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				TagPrefix: "garm example/",
+			},
+			errString: "invalid tag_prefix: tag prefix \"garm example/\" must not contain spaces",
+		},
+		{
+			name: "description with unknown variable",
+			cfg: Config{
+				AuthToken:   "token",
+				MetroCode:   "code",
+				ProjectID:   "project",
+				Description: "{{ .Repository }}",
+			},
+			errString: "invalid tags or description: failed to render template",
+		},
+		{
+			name: "empty spot metro",
+			cfg: Config{
+				AuthToken:  "token",
+				MetroCode:  "code",
+				ProjectID:  "project",
+				SpotMetros: []string{"am", ""},
+			},
+			errString: "spot_metros must not contain empty metros",
+		},
+		{
+			name: "metrics textfile without prom extension",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Metrics:   MetricsConfig{Textfile: "/var/lib/node_exporter/equinix.txt"},
+			},
+			errString: "invalid metrics config: textfile must have a .prom extension",
+		},
+		{
+			name: "tracing to both an endpoint and a file",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Tracing:   TracingConfig{OTLPEndpoint: "http://localhost:4318", File: "/tmp/traces.json"},
+			},
+			errString: "invalid tracing config: only one of otlp_endpoint and file may be set",
+		},
+		{
+			name: "tracing endpoint without scheme",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Tracing:   TracingConfig{OTLPEndpoint: "localhost:4318"},
+			},
+			errString: "invalid tracing config: otlp_endpoint must be an http or https URL",
+		},
+		{
+			name: "negative max devices per plan",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Quotas:    QuotasConfig{MaxDevicesPerPlan: map[string]int{"m3.large.x86": -1}},
+			},
+			errString: "invalid quotas config: max_devices_per_plan of m3.large.x86 must not be negative",
+		},
+		{
+			name: "negative reprovision attempts",
+			cfg: Config{
+				AuthToken:   "token",
+				MetroCode:   "code",
+				ProjectID:   "project",
+				Reprovision: ReprovisionConfig{MaxAttempts: -1},
+			},
+			errString: "invalid reprovision config: max_attempts must not be negative",
+		},
+		{
+			name: "relative daemon socket",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Daemon:    DaemonConfig{Socket: "garm-provider-equinix.sock"},
+			},
+			errString: "invalid daemon config: socket must be an absolute path",
+		},
+		{
+			name: "negative rate limit burst",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				RateLimit: RateLimitConfig{RequestsPerSecond: 2, Burst: -1},
+			},
+			errString: "invalid rate_limit config: burst must not be negative",
+		},
+		{
+			name: "negative orphan max age",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Orphans: OrphansConfig{
+					MaxAge: -time.Minute,
+				},
+			},
+			errString: "invalid orphans config: max_age must not be negative",
+		},
+		{
+			name: "negative orphan failed grace period",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				Orphans: OrphansConfig{
+					FailedGracePeriod: -time.Minute,
+				},
+			},
+			errString: "invalid orphans config: failed_grace_period must not be negative",
+		},
+		{
+			name: "warm pool without plan",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				WarmPools: []WarmPoolConfig{
+					{PoolID: "pool-1", Size: 1, OperatingSystem: "ubuntu_22_04"},
+				},
+			},
+			errString: "invalid warm pool 0: plan is required",
+		},
+		{
+			name: "duplicate warm pool",
+			cfg: Config{
+				AuthToken: "token",
+				MetroCode: "code",
+				ProjectID: "project",
+				WarmPools: []WarmPoolConfig{
+					{PoolID: "pool-1", Size: 1, Plan: "c3.small.x86", OperatingSystem: "ubuntu_22_04"},
+					{PoolID: "pool-1", Size: 2, Plan: "c3.small.x86", OperatingSystem: "ubuntu_22_04"},
+				},
+			},
+			errString: "duplicate warm pool pool-1",
+		},
 	}
 
 	for _, tt := range tests {
@@ -78,3 +292,71 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestGetProvisioningTimeout(t *testing.T) {
+	cfg := Config{}
+	assert.Equal(t, DefaultProvisioningTimeout, cfg.GetProvisioningTimeout())
+
+	cfg.ProvisioningTimeout = 30 * time.Minute
+	assert.Equal(t, 30*time.Minute, cfg.GetProvisioningTimeout())
+}
+
+func TestGetStateDir(t *testing.T) {
+	cfg := Config{}
+	t.Setenv("XDG_CACHE_HOME", "/home/garm/.cache")
+	t.Setenv("HOME", "/home/garm")
+	cacheDir, err := os.UserCacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheDir, "garm-provider-equinix"), cfg.GetStateDir())
+
+	if runtime.GOOS == "linux" {
+		// Without HOME, there is no user cache directory.
+		t.Setenv("XDG_CACHE_HOME", "")
+		t.Setenv("HOME", "")
+		assert.Equal(t, filepath.Join(os.TempDir(), "garm-provider-equinix"), cfg.GetStateDir())
+	}
+
+	cfg.StateDir = "/var/lib/garm-provider-equinix"
+	assert.Equal(t, "/var/lib/garm-provider-equinix", cfg.GetStateDir())
+}
+
+func TestOrphansConfigMerge(t *testing.T) {
+	cfg := OrphansConfig{
+		MaxAge:         24 * time.Hour,
+		AllowedPoolIDs: []string{"pool-1"},
+	}
+	assert.Equal(t, DefaultOrphanFailedGracePeriod, cfg.GetFailedGracePeriod())
+
+	merged := cfg.Merge(OrphansConfig{
+		FailedGracePeriod: time.Hour,
+		AllowedPoolIDs:    []string{"pool-2"},
+	})
+	assert.Equal(t, OrphansConfig{
+		MaxAge:            24 * time.Hour,
+		FailedGracePeriod: time.Hour,
+		AllowedPoolIDs:    []string{"pool-2"},
+	}, merged)
+	assert.Equal(t, time.Hour, merged.GetFailedGracePeriod())
+	assert.Equal(t, cfg, cfg.Merge(OrphansConfig{}))
+}
+
+func TestGetWarmPool(t *testing.T) {
+	cfg := Config{
+		MetroCode: "AM",
+		WarmPools: []WarmPoolConfig{
+			{PoolID: "pool-1", Size: 1, Plan: "c3.small.x86", OperatingSystem: "ubuntu_22_04"},
+			{PoolID: "pool-2", Size: 2, Plan: "c3.small.x86", OperatingSystem: "ubuntu_22_04", MetroCode: "DA"},
+		},
+	}
+
+	warmPool, ok := cfg.GetWarmPool("pool-1")
+	require.True(t, ok)
+	assert.Equal(t, "AM", warmPool.MetroCode)
+
+	warmPool, ok = cfg.GetWarmPool("pool-2")
+	require.True(t, ok)
+	assert.Equal(t, "DA", warmPool.MetroCode)
+
+	_, ok = cfg.GetWarmPool("pool-3")
+	assert.False(t, ok)
+}
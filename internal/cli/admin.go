@@ -0,0 +1,573 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/provider"
+)
+
+// AdminCommand is the first argument that switches the binary to the operator CLI.
+const AdminCommand = "admin"
+
+const adminUsage = `Usage: garm-provider-equinix admin <command> [flags] [args]
+
+Commands:
+  list                           List the devices created by the controller.
+  inspect <device-id|name>       Show details about one device.
+  delete <device-id> [...]       Delete one or more devices.
+  orphans                        List devices considered orphans by the orphan rules.
+  reap                           Delete devices considered orphans by the orphan rules.
+  refill                         Create and delete devices to keep the warm pools full.
+  prune-idle                     Delete devices parked for longer than max_idle_time.
+  costs                          Show the cost of the devices of every pool.
+  migrate-tags                   Rename the legacy tags of devices after the tag_prefix.
+
+Orphan rules default to the [orphans] section of the config file, and may be
+overridden with the --known-pool, --max-age and --failed-grace-period flags.
+
+Common flags:
+  --config         Path to the provider config file (default $GARM_PROVIDER_CONFIG_FILE).
+  --controller-id  The GARM controller ID (default $GARM_CONTROLLER_ID). If empty,
+                   devices created by any GARM controller are managed, and reap
+                   requires --all-controllers.
+`
+
+// AdminFactory creates the provider.Admin used by the admin commands.
+type AdminFactory func(configPath, controllerID string) (provider.Admin, error)
+
+var DefaultAdminFactory AdminFactory = provider.NewAdmin
+
+// stringList is a flag that may be set multiple times, or to a comma separated list.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	for _, val := range strings.Split(value, ",") {
+		if val = strings.TrimSpace(val); val != "" {
+			*s = append(*s, val)
+		}
+	}
+	return nil
+}
+
+type adminCommand struct {
+	stdin  io.Reader
+	stdout io.Writer
+
+	configPath   string
+	controllerID string
+	output       string
+}
+
+func (c *adminCommand) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stdout)
+	fs.StringVar(&c.configPath, "config", os.Getenv("GARM_PROVIDER_CONFIG_FILE"), "path to the provider config file")
+	fs.StringVar(&c.controllerID, "controller-id", os.Getenv("GARM_CONTROLLER_ID"), "the GARM controller ID")
+	fs.StringVar(&c.output, "output", "table", "output format (table or json)")
+	return fs
+}
+
+func (c *adminCommand) admin() (provider.Admin, error) {
+	if c.configPath == "" {
+		return nil, fmt.Errorf("missing --config")
+	}
+	if c.output != "table" && c.output != "json" {
+		return nil, fmt.Errorf("invalid output format %q", c.output)
+	}
+	return DefaultAdminFactory(c.configPath, c.controllerID)
+}
+
+// RunAdmin runs the operator CLI with the given arguments, which exclude the
+// admin command itself.
+func RunAdmin(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(stdout, adminUsage)
+		return nil
+	}
+
+	cmd := &adminCommand{
+		stdin:  stdin,
+		stdout: stdout,
+	}
+	switch args[0] {
+	case "list":
+		return cmd.list(ctx, args[1:])
+	case "inspect":
+		return cmd.inspect(ctx, args[1:])
+	case "delete":
+		return cmd.delete(ctx, args[1:])
+	case "orphans":
+		return cmd.orphans(ctx, args[1:])
+	case "reap":
+		return cmd.reap(ctx, args[1:])
+	case "refill":
+		return cmd.refill(ctx, args[1:])
+	case "prune-idle":
+		return cmd.pruneIdle(ctx, args[1:])
+	case "costs":
+		return cmd.costs(ctx, args[1:])
+	case "migrate-tags":
+		return cmd.migrateTags(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown admin command %q", args[0])
+	}
+}
+
+func (c *adminCommand) list(ctx context.Context, args []string) error {
+	fs := c.flagSet("list")
+	poolID := fs.String("pool", "", "only list devices belonging to this pool")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	devices, err := admin.ListDevices(ctx, *poolID)
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %w", err)
+	}
+	return c.printDevices(devices)
+}
+
+func (c *adminCommand) inspect(ctx context.Context, args []string) error {
+	fs := c.flagSet("inspect")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("inspect requires exactly one device ID or runner name")
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	device, err := admin.InspectDevice(ctx, fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to inspect device: %w", err)
+	}
+	if c.output == "json" {
+		return c.printJSON(device)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", device.ID)
+	fmt.Fprintf(w, "Name:\t%s\n", deviceName(device))
+	fmt.Fprintf(w, "Hostname:\t%s\n", device.Hostname)
+	fmt.Fprintf(w, "State:\t%s\n", device.State)
+	fmt.Fprintf(w, "Pool ID:\t%s\n", device.PoolID)
+	fmt.Fprintf(w, "Controller ID:\t%s\n", device.ControllerID)
+	fmt.Fprintf(w, "Plan:\t%s\n", device.Plan)
+	fmt.Fprintf(w, "Metro:\t%s\n", device.Metro)
+	fmt.Fprintf(w, "Addresses:\t%s\n", strings.Join(device.Addresses, ", "))
+	fmt.Fprintf(w, "Created at:\t%s\n", formatTime(device.CreatedAt))
+	fmt.Fprintf(w, "Age:\t%s\n", formatAge(device.Age(time.Now())))
+	fmt.Fprintf(w, "Hourly price:\t%s\n", formatCost(device.HourlyPrice))
+	fmt.Fprintf(w, "Accumulated cost:\t%s\n", formatCost(device.AccumulatedCost))
+	fmt.Fprintf(w, "Tags:\t%s\n", strings.Join(device.Tags, ", "))
+	if device.ProviderFault != "" {
+		fmt.Fprintf(w, "Provider fault:\t%s\n", device.ProviderFault)
+	}
+	return w.Flush()
+}
+
+func (c *adminCommand) delete(ctx context.Context, args []string) error {
+	fs := c.flagSet("delete")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("delete requires at least one device ID")
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	deviceIDs := fs.Args()
+	if !*yes {
+		confirmed, err := c.confirm(fmt.Sprintf("Delete %d device(s): %s?", len(deviceIDs), strings.Join(deviceIDs, ", ")))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintln(c.stdout, "Aborted.")
+			return nil
+		}
+	}
+
+	for _, deviceID := range deviceIDs {
+		if err := admin.DeleteDevice(ctx, deviceID); err != nil {
+			return fmt.Errorf("failed to delete device %s: %w", deviceID, err)
+		}
+		fmt.Fprintf(c.stdout, "Deleted device %s\n", deviceID)
+	}
+	return nil
+}
+
+// orphanFlags registers the flags that override the configured orphan rules.
+func orphanFlags(fs *flag.FlagSet) *config.OrphansConfig {
+	overrides := &config.OrphansConfig{}
+	fs.Var((*stringList)(&overrides.AllowedPoolIDs), "known-pool", "ID of a pool that exists in GARM (may be repeated or comma separated)")
+	fs.DurationVar(&overrides.MaxAge, "max-age", 0, "maximum time a device may be assigned to its runner")
+	fs.DurationVar(&overrides.FailedGracePeriod, "failed-grace-period", 0, "amount of time a device may stay failed or inactive")
+	return overrides
+}
+
+func (c *adminCommand) orphans(ctx context.Context, args []string) error {
+	fs := c.flagSet("orphans")
+	overrides := orphanFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	orphans, err := admin.FindOrphans(ctx, *overrides)
+	if err != nil {
+		return fmt.Errorf("failed to find orphans: %w", err)
+	}
+	return c.printOrphans(orphans)
+}
+
+func (c *adminCommand) reap(ctx context.Context, args []string) error {
+	fs := c.flagSet("reap")
+	overrides := orphanFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "only list the devices that would be deleted")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	allControllers := fs.Bool("all-controllers", false, "reap the orphans of all GARM controllers when no controller ID is set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*dryRun && c.controllerID == "" && !*allControllers {
+		return fmt.Errorf("reap requires --controller-id, or --all-controllers to reap the devices of any GARM controller")
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	orphans, err := admin.FindOrphans(ctx, *overrides)
+	if err != nil {
+		return fmt.Errorf("failed to find orphans: %w", err)
+	}
+	if *dryRun || len(orphans) == 0 {
+		return c.printOrphans(orphans)
+	}
+	if !*yes {
+		if err := c.printOrphans(orphans); err != nil {
+			return err
+		}
+		confirmed, err := c.confirm(fmt.Sprintf("Delete %d orphaned device(s)?", len(orphans)))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintln(c.stdout, "Aborted.")
+			return nil
+		}
+	}
+
+	reaped, reapErr := admin.ReapOrphans(ctx, orphans)
+	if err := c.printOrphans(reaped); err != nil {
+		return err
+	}
+	if reapErr != nil {
+		return fmt.Errorf("failed to reap orphans: %w", reapErr)
+	}
+	return nil
+}
+
+func (c *adminCommand) refill(ctx context.Context, args []string) error {
+	fs := c.flagSet("refill")
+	dryRun := fs.Bool("dry-run", false, "only report the devices that would be created or deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	statuses, refillErr := admin.RefillWarmPools(ctx, *dryRun)
+	if err := c.printWarmPools(statuses); err != nil {
+		return err
+	}
+	if refillErr != nil {
+		return fmt.Errorf("failed to refill warm pools: %w", refillErr)
+	}
+	return nil
+}
+
+func (c *adminCommand) pruneIdle(ctx context.Context, args []string) error {
+	fs := c.flagSet("prune-idle")
+	dryRun := fs.Bool("dry-run", false, "only list the devices that would be deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	devices, pruneErr := admin.PruneIdleDevices(ctx, *dryRun)
+	if err := c.printDevices(devices); err != nil {
+		return err
+	}
+	if pruneErr != nil {
+		return fmt.Errorf("failed to prune idle devices: %w", pruneErr)
+	}
+	return nil
+}
+
+// parseTime parses a time given as a date, or in RFC 3339 format.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (c *adminCommand) costs(ctx context.Context, args []string) error {
+	fs := c.flagSet("costs")
+	since := fs.String("since", "", "start of the period, as a date or RFC 3339 time (default 30 days ago)")
+	until := fs.String("until", "", "end of the period, as a date or RFC 3339 time (default now)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	end := time.Now()
+	if *until != "" {
+		t, err := parseTime(*until)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -30)
+	if *since != "" {
+		t, err := parseTime(*since)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("--since must be before --until")
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	costs, err := admin.PoolCosts(ctx, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to get pool costs: %w", err)
+	}
+	return c.printPoolCosts(costs)
+}
+
+func (c *adminCommand) migrateTags(ctx context.Context, args []string) error {
+	fs := c.flagSet("migrate-tags")
+	dryRun := fs.Bool("dry-run", false, "only list the devices whose tags would be renamed")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	admin, err := c.admin()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := admin.FindTagMigrations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to find devices to migrate: %w", err)
+	}
+	if *dryRun || len(migrations) == 0 {
+		return c.printTagMigrations(migrations)
+	}
+	if !*yes {
+		if err := c.printTagMigrations(migrations); err != nil {
+			return err
+		}
+		confirmed, err := c.confirm(fmt.Sprintf("Rename the tags of %d device(s)?", len(migrations)))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintln(c.stdout, "Aborted.")
+			return nil
+		}
+	}
+
+	migrated, migrateErr := admin.MigrateTags(ctx, migrations)
+	if err := c.printTagMigrations(migrated); err != nil {
+		return err
+	}
+	if migrateErr != nil {
+		return fmt.Errorf("failed to migrate tags: %w", migrateErr)
+	}
+	return nil
+}
+
+func (c *adminCommand) confirm(question string) (bool, error) {
+	fmt.Fprintf(c.stdout, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func (c *adminCommand) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "    ")
+	return enc.Encode(v)
+}
+
+func (c *adminCommand) printDevices(devices []provider.DeviceInfo) error {
+	if c.output == "json" {
+		return c.printJSON(devices)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATE\tPOOL\tPLAN\tMETRO\tAGE\tPRICE/H\tCOST\tADDRESSES")
+	for _, device := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			device.ID, deviceName(device), device.State, device.PoolID, device.Plan,
+			device.Metro, formatAge(device.Age(now)), formatCost(device.HourlyPrice),
+			formatCost(device.AccumulatedCost), strings.Join(device.Addresses, ","))
+	}
+	return w.Flush()
+}
+
+func (c *adminCommand) printOrphans(orphans []provider.Orphan) error {
+	if c.output == "json" {
+		return c.printJSON(orphans)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATE\tPOOL\tAGE\tREASON\tDELETED")
+	for _, orphan := range orphans {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+			orphan.ID, deviceName(orphan.DeviceInfo), orphan.State, orphan.PoolID,
+			formatAge(orphan.Age(now)), orphan.Reason, orphan.Deleted)
+	}
+	return w.Flush()
+}
+
+func (c *adminCommand) printWarmPools(statuses []provider.WarmPoolStatus) error {
+	if c.output == "json" {
+		return c.printJSON(statuses)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tSIZE\tREADY\tPROVISIONING\tCREATED\tDELETED")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n",
+			status.PoolID, status.Size, status.Ready, status.Provisioning,
+			status.Created, status.Deleted)
+	}
+	return w.Flush()
+}
+
+func (c *adminCommand) printPoolCosts(costs []provider.PoolCost) error {
+	if c.output == "json" {
+		return c.printJSON(costs)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tDEVICES\tTOTAL")
+	for _, cost := range costs {
+		poolID := cost.PoolID
+		if poolID == "" {
+			poolID = "(unattributed)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", poolID, cost.Devices, formatCost(cost.Total))
+	}
+	return w.Flush()
+}
+
+func (c *adminCommand) printTagMigrations(migrations []provider.TagMigration) error {
+	if c.output == "json" {
+		return c.printJSON(migrations)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tMIGRATED\tTAGS")
+	for _, migration := range migrations {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n",
+			migration.ID, migration.Name, migration.Migrated, strings.Join(migration.NewTags, ","))
+	}
+	return w.Flush()
+}
+
+// deviceName returns the runner name of a device, or a placeholder for devices
+// on standby, which are not assigned to any runner.
+func deviceName(device provider.DeviceInfo) string {
+	switch {
+	case device.Idle:
+		return "(idle)"
+	case device.Warm:
+		return "(warm)"
+	}
+	return device.Name
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatCost formats an amount in USD. Unknown amounts are left empty.
+func formatCost(cost float64) string {
+	if cost == 0 {
+		return ""
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+func formatAge(age time.Duration) string {
+	if age == 0 {
+		return ""
+	}
+	return age.Truncate(time.Second).String()
+}
@@ -0,0 +1,478 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAdmin struct {
+	devices   []provider.DeviceInfo
+	deleted   []string
+	overrides config.OrphansConfig
+	reaped    bool
+	refilled  bool
+	pruned    bool
+	migrated  bool
+	since     time.Time
+	until     time.Time
+}
+
+func (f *fakeAdmin) ListDevices(ctx context.Context, poolID string) ([]provider.DeviceInfo, error) {
+	ret := []provider.DeviceInfo{}
+	for _, device := range f.devices {
+		if poolID == "" || device.PoolID == poolID {
+			ret = append(ret, device)
+		}
+	}
+	return ret, nil
+}
+
+func (f *fakeAdmin) InspectDevice(ctx context.Context, instance string) (provider.DeviceInfo, error) {
+	for _, device := range f.devices {
+		if device.ID == instance || device.Name == instance {
+			return device, nil
+		}
+	}
+	return provider.DeviceInfo{}, assert.AnError
+}
+
+func (f *fakeAdmin) DeleteDevice(ctx context.Context, deviceID string) error {
+	f.deleted = append(f.deleted, deviceID)
+	return nil
+}
+
+func (f *fakeAdmin) FindOrphans(ctx context.Context, overrides config.OrphansConfig) ([]provider.Orphan, error) {
+	f.overrides = overrides
+	return []provider.Orphan{
+		{
+			DeviceInfo: f.devices[1],
+			Reason:     provider.OrphanReasonUnknownPool,
+		},
+	}, nil
+}
+
+func (f *fakeAdmin) ReapOrphans(ctx context.Context, orphans []provider.Orphan) ([]provider.Orphan, error) {
+	f.reaped = true
+	ret := []provider.Orphan{}
+	for _, orphan := range orphans {
+		orphan.Deleted = true
+		ret = append(ret, orphan)
+	}
+	return ret, nil
+}
+
+func (f *fakeAdmin) RefillWarmPools(ctx context.Context, dryRun bool) ([]provider.WarmPoolStatus, error) {
+	f.refilled = !dryRun
+	return []provider.WarmPoolStatus{
+		{
+			PoolID:       "pool-1",
+			Size:         3,
+			Ready:        1,
+			Provisioning: 2,
+			Created:      2,
+		},
+	}, nil
+}
+
+func (f *fakeAdmin) PruneIdleDevices(ctx context.Context, dryRun bool) ([]provider.DeviceInfo, error) {
+	f.pruned = !dryRun
+	return []provider.DeviceInfo{f.devices[0]}, nil
+}
+
+func (f *fakeAdmin) PoolCosts(ctx context.Context, since, until time.Time) ([]provider.PoolCost, error) {
+	f.since, f.until = since, until
+	return []provider.PoolCost{
+		{PoolID: "pool-1", Devices: 3, Total: 42.5},
+		{Devices: 1, Total: 7},
+	}, nil
+}
+
+func (f *fakeAdmin) FindTagMigrations(ctx context.Context) ([]provider.TagMigration, error) {
+	return []provider.TagMigration{
+		{
+			ID:      "device-1",
+			Name:    "runner-1",
+			OldTags: []string{"Name=runner-1", "garm-pool-id=pool-1"},
+			NewTags: []string{"garm.example.com/name=runner-1", "garm.example.com/pool-id=pool-1"},
+		},
+	}, nil
+}
+
+func (f *fakeAdmin) MigrateTags(ctx context.Context, migrations []provider.TagMigration) ([]provider.TagMigration, error) {
+	f.migrated = true
+	ret := []provider.TagMigration{}
+	for _, migration := range migrations {
+		migration.Migrated = true
+		ret = append(ret, migration)
+	}
+	return ret, nil
+}
+
+func newFakeAdmin(t *testing.T) *fakeAdmin {
+	admin := &fakeAdmin{
+		devices: []provider.DeviceInfo{
+			{
+				ID:        "device-1",
+				Name:      "runner-1",
+				State:     "active",
+				PoolID:    "pool-1",
+				Plan:      "c3.small.x86",
+				Metro:     "am",
+				Addresses: []string{"10.10.0.4"},
+				CreatedAt: time.Now().Add(-time.Hour),
+
+				HourlyPrice:     0.75,
+				AccumulatedCost: 0.75,
+			},
+			{
+				ID:     "device-2",
+				Name:   "runner-2",
+				State:  "failed",
+				PoolID: "pool-2",
+			},
+		},
+	}
+	DefaultAdminFactory = func(configPath, controllerID string) (provider.Admin, error) {
+		assert.Equal(t, "/etc/garm/equinix.toml", configPath)
+		assert.Equal(t, "controller", controllerID)
+		return admin, nil
+	}
+	return admin
+}
+
+func TestRunAdminList(t *testing.T) {
+	newFakeAdmin(t)
+	var out bytes.Buffer
+
+	err := RunAdmin(context.Background(), []string{"list", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller", "--pool", "pool-1"}, nil, &out)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "ID")
+	assert.Contains(t, lines[1], "device-1")
+	assert.Contains(t, lines[1], "1h0m0s")
+	assert.Contains(t, lines[1], "$0.75")
+}
+
+func TestRunAdminListJSON(t *testing.T) {
+	newFakeAdmin(t)
+	var out bytes.Buffer
+
+	err := RunAdmin(context.Background(), []string{"list", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller", "--output", "json"}, nil, &out)
+	require.NoError(t, err)
+	var devices []provider.DeviceInfo
+	require.NoError(t, json.Unmarshal(out.Bytes(), &devices))
+	assert.Len(t, devices, 2)
+}
+
+func TestRunAdminInspect(t *testing.T) {
+	newFakeAdmin(t)
+	var out bytes.Buffer
+
+	err := RunAdmin(context.Background(), []string{"inspect", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller", "runner-2"}, nil, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "device-2")
+	assert.Contains(t, out.String(), "failed")
+}
+
+func TestRunAdminDelete(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		stdin           string
+		expectedDeleted []string
+	}{
+		{
+			name:            "confirmed",
+			args:            []string{"device-1", "device-2"},
+			stdin:           "y\n",
+			expectedDeleted: []string{"device-1", "device-2"},
+		},
+		{
+			name:            "aborted",
+			args:            []string{"device-1"},
+			stdin:           "\n",
+			expectedDeleted: nil,
+		},
+		{
+			name:            "no confirmation needed",
+			args:            []string{"--yes", "device-1"},
+			expectedDeleted: []string{"device-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newFakeAdmin(t)
+			var out bytes.Buffer
+			args := append([]string{"delete", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller"}, tt.args...)
+
+			err := RunAdmin(context.Background(), args, strings.NewReader(tt.stdin), &out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedDeleted, admin.deleted)
+		})
+	}
+}
+
+func TestRunAdminOrphans(t *testing.T) {
+	admin := newFakeAdmin(t)
+	var out bytes.Buffer
+
+	err := RunAdmin(context.Background(), []string{"orphans", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller", "--known-pool", "pool-1,pool-3", "--known-pool", "pool-4", "--max-age", "24h"}, nil, &out)
+	require.NoError(t, err)
+	assert.Equal(t, config.OrphansConfig{
+		AllowedPoolIDs: []string{"pool-1", "pool-3", "pool-4"},
+		MaxAge:         24 * time.Hour,
+	}, admin.overrides)
+	assert.Contains(t, out.String(), "device-2")
+	assert.Contains(t, out.String(), "unknown_pool")
+	assert.NotContains(t, out.String(), "device-1")
+	assert.False(t, admin.reaped)
+}
+
+func TestRunAdminReap(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		stdin          string
+		expectedReaped bool
+	}{
+		{
+			name:           "dry run",
+			args:           []string{"--dry-run"},
+			expectedReaped: false,
+		},
+		{
+			name:           "confirmed",
+			stdin:          "yes\n",
+			expectedReaped: true,
+		},
+		{
+			name:           "aborted",
+			stdin:          "no\n",
+			expectedReaped: false,
+		},
+		{
+			name:           "no confirmation needed",
+			args:           []string{"--yes"},
+			expectedReaped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newFakeAdmin(t)
+			var out bytes.Buffer
+			args := append([]string{"reap", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller"}, tt.args...)
+
+			err := RunAdmin(context.Background(), args, strings.NewReader(tt.stdin), &out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedReaped, admin.reaped)
+			assert.Contains(t, out.String(), "device-2")
+		})
+	}
+}
+
+func TestRunAdminReapRequiresController(t *testing.T) {
+	t.Setenv("GARM_CONTROLLER_ID", "")
+	admin := newFakeAdmin(t)
+	var out bytes.Buffer
+
+	err := RunAdmin(context.Background(), []string{"reap", "--config", "/etc/garm/equinix.toml", "--yes"}, nil, &out)
+	assert.ErrorContains(t, err, "reap requires --controller-id")
+	assert.False(t, admin.reaped)
+
+	// Listing the orphans of all controllers is harmless.
+	DefaultAdminFactory = func(configPath, controllerID string) (provider.Admin, error) {
+		assert.Empty(t, controllerID)
+		return admin, nil
+	}
+	err = RunAdmin(context.Background(), []string{"reap", "--config", "/etc/garm/equinix.toml", "--dry-run"}, nil, &out)
+	require.NoError(t, err)
+	assert.False(t, admin.reaped)
+
+	err = RunAdmin(context.Background(), []string{"reap", "--config", "/etc/garm/equinix.toml", "--yes", "--all-controllers"}, nil, &out)
+	require.NoError(t, err)
+	assert.True(t, admin.reaped)
+}
+
+func TestRunAdminPruneIdle(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		expectedPruned bool
+	}{
+		{
+			name:           "dry run",
+			args:           []string{"--dry-run"},
+			expectedPruned: false,
+		},
+		{
+			name:           "prune",
+			expectedPruned: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newFakeAdmin(t)
+			var out bytes.Buffer
+			args := append([]string{"prune-idle", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller"}, tt.args...)
+
+			err := RunAdmin(context.Background(), args, nil, &out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPruned, admin.pruned)
+			assert.Contains(t, out.String(), "device-1")
+		})
+	}
+}
+
+func TestRunAdminMigrateTags(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		stdin            string
+		expectedMigrated bool
+	}{
+		{
+			name:             "dry run",
+			args:             []string{"--dry-run"},
+			expectedMigrated: false,
+		},
+		{
+			name:             "confirmed",
+			stdin:            "yes\n",
+			expectedMigrated: true,
+		},
+		{
+			name:             "aborted",
+			stdin:            "no\n",
+			expectedMigrated: false,
+		},
+		{
+			name:             "no confirmation needed",
+			args:             []string{"--yes"},
+			expectedMigrated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newFakeAdmin(t)
+			var out bytes.Buffer
+			args := append([]string{"migrate-tags", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller"}, tt.args...)
+
+			err := RunAdmin(context.Background(), args, strings.NewReader(tt.stdin), &out)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedMigrated, admin.migrated)
+			assert.Contains(t, out.String(), "garm.example.com/name=runner-1,garm.example.com/pool-id=pool-1")
+		})
+	}
+}
+
+func TestRunAdminRefill(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		t.Run(fmt.Sprintf("dry run %t", dryRun), func(t *testing.T) {
+			admin := newFakeAdmin(t)
+			var out bytes.Buffer
+			args := []string{"refill", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller"}
+			if dryRun {
+				args = append(args, "--dry-run")
+			}
+
+			err := RunAdmin(context.Background(), args, nil, &out)
+			require.NoError(t, err)
+			assert.Equal(t, !dryRun, admin.refilled)
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			require.Len(t, lines, 2)
+			assert.Equal(t, []string{"pool-1", "3", "1", "2", "2", "0"}, strings.Fields(lines[1]))
+		})
+	}
+}
+
+func TestRunAdminCosts(t *testing.T) {
+	admin := newFakeAdmin(t)
+	var out bytes.Buffer
+
+	args := []string{"costs", "--config", "/etc/garm/equinix.toml", "--controller-id", "controller", "--since", "2024-05-01", "--until", "2024-06-01T00:00:00Z"}
+	err := RunAdmin(context.Background(), args, nil, &out)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), admin.since)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), admin.until)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"pool-1", "3", "$42.50"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"(unattributed)", "1", "$7.00"}, strings.Fields(lines[2]))
+}
+
+func TestRunAdminErrors(t *testing.T) {
+	newFakeAdmin(t)
+	tests := []struct {
+		name      string
+		args      []string
+		errString string
+	}{
+		{
+			name:      "unknown command",
+			args:      []string{"frobnicate"},
+			errString: "unknown admin command",
+		},
+		{
+			name:      "missing config",
+			args:      []string{"list", "--config", ""},
+			errString: "missing --config",
+		},
+		{
+			name:      "invalid output",
+			args:      []string{"list", "--config", "/etc/garm/equinix.toml", "--output", "yaml"},
+			errString: "invalid output format",
+		},
+		{
+			name:      "inspect without argument",
+			args:      []string{"inspect", "--config", "/etc/garm/equinix.toml"},
+			errString: "inspect requires exactly one device ID or runner name",
+		},
+		{
+			name:      "delete without argument",
+			args:      []string{"delete", "--config", "/etc/garm/equinix.toml"},
+			errString: "delete requires at least one device ID",
+		},
+		{
+			name:      "costs with an invalid period",
+			args:      []string{"costs", "--config", "/etc/garm/equinix.toml", "--since", "2024-06-01", "--until", "2024-05-01"},
+			errString: "--since must be before --until",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := RunAdmin(context.Background(), tt.args, nil, &out)
+			assert.ErrorContains(t, err, tt.errString)
+		})
+	}
+}
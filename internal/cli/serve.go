@@ -0,0 +1,65 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/daemon"
+	"github.com/cloudbase/garm-provider-equinix/provider"
+)
+
+// ServeCommand is the first argument that starts the provider daemon.
+const ServeCommand = "serve"
+
+// DefaultProviderFactory creates the providers of the daemon.
+var DefaultProviderFactory daemon.ProviderFactory = func(configPath, controllerID string) (interface{}, error) {
+	return provider.NewEquinixProvider(configPath, controllerID)
+}
+
+// RunServe runs the provider daemon, serving the GARM commands forwarded by the
+// provider executables on the socket set in the config file, until ctx is done.
+// The arguments exclude the serve command itself.
+func RunServe(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet(ServeCommand, flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	configPath := fs.String("config", os.Getenv("GARM_PROVIDER_CONFIG_FILE"), "path to the provider config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("missing --config")
+	}
+
+	cfg, err := config.NewConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.Daemon.Socket == "" {
+		return fmt.Errorf("the config file does not set a daemon socket")
+	}
+
+	ln, err := daemon.Listen(cfg.Daemon.Socket)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "serving GARM commands on %s\n", cfg.Daemon.Socket)
+	return daemon.NewServer(*configPath, DefaultProviderFactory).Serve(ctx, ln)
+}
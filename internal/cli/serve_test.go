@@ -0,0 +1,60 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunServeErrors(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+auth_token = "token"
+metro_code = "AM"
+project_id = "project"
+`), 0o600))
+
+	tests := []struct {
+		name      string
+		args      []string
+		errString string
+	}{
+		{
+			name:      "missing config",
+			args:      []string{"--config", ""},
+			errString: "missing --config",
+		},
+		{
+			name:      "daemon not enabled",
+			args:      []string{"--config", configFile},
+			errString: "the config file does not set a daemon socket",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			err := RunServe(context.Background(), tt.args, &stdout)
+			assert.ErrorContains(t, err, tt.errString)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package command runs the GARM commands, whether the provider was executed by
+// GARM or the command was forwarded to the provider daemon.
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/common"
+	executionv010 "github.com/cloudbase/garm-provider-common/execution/v0.1.0"
+	executionv011 "github.com/cloudbase/garm-provider-common/execution/v0.1.1"
+	"github.com/cloudbase/garm-provider-common/params"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/metrics"
+	"github.com/cloudbase/garm-provider-equinix/internal/tracing"
+)
+
+// EnvironmentVariables are the environment variables GARM passes to the provider.
+var EnvironmentVariables = []string{
+	"GARM_COMMAND",
+	"GARM_CONTROLLER_ID",
+	"GARM_POOL_ID",
+	"GARM_PROVIDER_CONFIG_FILE",
+	"GARM_INSTANCE_ID",
+	"GARM_INTERFACE_VERSION",
+	"GARM_POOL_EXTRASPECS",
+	"TRACEPARENT",
+}
+
+// NeedsStdin returns true if GARM passes data on the standard input of a command.
+func NeedsStdin(command string) bool {
+	return common.ExecutionCommand(command) == common.CreateInstanceCommand
+}
+
+// bootstrapParams decodes the bootstrap params GARM passes to CreateInstance.
+func bootstrapParams(command common.ExecutionCommand, stdin []byte) (params.BootstrapInstance, error) {
+	var ret params.BootstrapInstance
+	if command != common.CreateInstanceCommand {
+		return ret, nil
+	}
+	if len(stdin) == 0 {
+		return ret, fmt.Errorf("%s requires data passed into stdin", command)
+	}
+	if err := json.Unmarshal(stdin, &ret); err != nil {
+		return ret, fmt.Errorf("failed to decode instance params: %w", err)
+	}
+	if ret.ExtraSpecs == nil {
+		ret.ExtraSpecs = json.RawMessage([]byte("{}"))
+	}
+	return ret, nil
+}
+
+// Environment returns the execution environment of a command, read from getenv
+// and stdin instead of the environment and standard input of the process, like
+// execution.GetEnvironment does.
+func Environment(getenv func(string) string, stdin []byte) (execution.Environment, error) {
+	interfaceVersion := getenv("GARM_INTERFACE_VERSION")
+	command := common.ExecutionCommand(getenv("GARM_COMMAND"))
+	bootstrap, err := bootstrapParams(command, stdin)
+	if err != nil {
+		return execution.Environment{}, fmt.Errorf("failed to get bootstrap params: %w", err)
+	}
+
+	ret := execution.Environment{
+		InterfaceVersion:   interfaceVersion,
+		ProviderConfigFile: getenv("GARM_PROVIDER_CONFIG_FILE"),
+		ControllerID:       getenv("GARM_CONTROLLER_ID"),
+	}
+	switch interfaceVersion {
+	case common.Version010, "":
+		ret.EnvironmentV010 = executionv010.EnvironmentV010{
+			Command:            command,
+			ControllerID:       ret.ControllerID,
+			PoolID:             getenv("GARM_POOL_ID"),
+			ProviderConfigFile: ret.ProviderConfigFile,
+			InstanceID:         getenv("GARM_INSTANCE_ID"),
+			BootstrapParams:    bootstrap,
+		}
+		err = ret.EnvironmentV010.Validate()
+	case common.Version011:
+		ret.EnvironmentV011 = executionv011.EnvironmentV011{
+			Command:            command,
+			ControllerID:       ret.ControllerID,
+			PoolID:             getenv("GARM_POOL_ID"),
+			ProviderConfigFile: ret.ProviderConfigFile,
+			InstanceID:         getenv("GARM_INSTANCE_ID"),
+			ExtraSpecs:         getenv("GARM_POOL_EXTRASPECS"),
+			BootstrapParams:    bootstrap,
+		}
+		err = ret.EnvironmentV011.Validate()
+	default:
+		return execution.Environment{}, fmt.Errorf("unsupported interface version: %s", interfaceVersion)
+	}
+	if err != nil {
+		return execution.Environment{}, fmt.Errorf("failed to validate execution environment: %w", err)
+	}
+	return ret, nil
+}
+
+// RunnerName returns the name of the runner a command was run for, if any.
+func RunnerName(env execution.Environment) string {
+	if name := env.EnvironmentV010.BootstrapParams.Name; name != "" {
+		return name
+	}
+	return env.EnvironmentV011.BootstrapParams.Name
+}
+
+// attributes returns the span attributes identifying the runner a command was run
+// for.
+func attributes(env execution.Environment, getenv func(string) string) []tracing.Attribute {
+	return []tracing.Attribute{
+		tracing.String("garm.command", getenv("GARM_COMMAND")),
+		tracing.String("garm.controller_id", env.ControllerID),
+		tracing.String("garm.pool_id", getenv("GARM_POOL_ID")),
+		tracing.String("garm.runner_name", RunnerName(env)),
+		// GetInstance and DeleteInstance identify the runner by instance ID.
+		tracing.String("garm.instance_id", getenv("GARM_INSTANCE_ID")),
+	}
+}
+
+// Telemetry is implemented by the providers which record traces and metrics.
+type Telemetry interface {
+	// Tracer returns the tracer of the provider, which is nil if tracing is
+	// disabled.
+	Tracer() *tracing.Tracer
+	// Metrics returns the registry holding the metrics of the provider.
+	Metrics() *metrics.Registry
+	// FlushTelemetry writes the metrics and exports the spans recorded so far.
+	FlushTelemetry(ctx context.Context) error
+}
+
+// Run runs a command against the provider, and records its span and metrics if
+// the provider implements Telemetry. The metrics and spans are not flushed.
+func Run(ctx context.Context, env execution.Environment, getenv func(string) string, provider interface{}) (string, error) {
+	var tracer *tracing.Tracer
+	var registry *metrics.Registry
+	if telemetry, ok := provider.(Telemetry); ok {
+		tracer, registry = telemetry.Tracer(), telemetry.Metrics()
+	}
+
+	command := getenv("GARM_COMMAND")
+	// Join the trace of the caller, if it passed one down.
+	ctx = tracing.ContextWithTraceparent(ctx, getenv("TRACEPARENT"))
+	ctx, span := tracer.Start(ctx, command, tracing.SpanKindInternal, attributes(env, getenv)...)
+
+	start := time.Now()
+	result, err := env.Run(ctx, provider)
+	registry.ObserveCommand(command, time.Since(start), err)
+	span.End(err)
+	return result, err
+}
+
+// flushTimeout bounds the time spent flushing telemetry, so an unreachable
+// collector does not hold up the command.
+const flushTimeout = 5 * time.Second
+
+// Flush writes the metrics and exports the spans recorded by the provider, if it
+// implements Telemetry. It gives up after flushTimeout.
+func Flush(ctx context.Context, provider interface{}) error {
+	telemetry, ok := provider.(Telemetry)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+	return telemetry.FlushTelemetry(ctx)
+}
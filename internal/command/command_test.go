@@ -0,0 +1,135 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package command
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/execution/common"
+	"github.com/cloudbase/garm-provider-equinix/internal/metrics"
+	"github.com/cloudbase/garm-provider-equinix/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, nil, 0o600))
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		stdin     []byte
+		errString string
+	}{
+		{
+			name: "create instance",
+			env: map[string]string{
+				"GARM_COMMAND":              "CreateInstance",
+				"GARM_CONTROLLER_ID":        "controller",
+				"GARM_POOL_ID":              "pool",
+				"GARM_PROVIDER_CONFIG_FILE": configFile,
+			},
+			stdin: []byte(`{"name": "runner-1"}`),
+		},
+		{
+			name: "create instance without bootstrap params",
+			env: map[string]string{
+				"GARM_COMMAND":              "CreateInstance",
+				"GARM_CONTROLLER_ID":        "controller",
+				"GARM_POOL_ID":              "pool",
+				"GARM_PROVIDER_CONFIG_FILE": configFile,
+			},
+			errString: "CreateInstance requires data passed into stdin",
+		},
+		{
+			name: "get instance without instance ID",
+			env: map[string]string{
+				"GARM_COMMAND":              "GetInstance",
+				"GARM_CONTROLLER_ID":        "controller",
+				"GARM_PROVIDER_CONFIG_FILE": configFile,
+			},
+			errString: "missing instance ID",
+		},
+		{
+			name: "list instances with interface v0.1.1",
+			env: map[string]string{
+				"GARM_COMMAND":              "ListInstances",
+				"GARM_CONTROLLER_ID":        "controller",
+				"GARM_POOL_ID":              "pool",
+				"GARM_PROVIDER_CONFIG_FILE": configFile,
+				"GARM_INTERFACE_VERSION":    common.Version011,
+			},
+		},
+		{
+			name: "unsupported interface version",
+			env: map[string]string{
+				"GARM_COMMAND":           "ListInstances",
+				"GARM_INTERFACE_VERSION": "v9.9.9",
+			},
+			errString: "unsupported interface version: v9.9.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(name string) string { return tt.env[name] }
+			env, err := Environment(getenv, tt.stdin)
+			if tt.errString != "" {
+				assert.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "controller", env.ControllerID)
+			assert.Equal(t, configFile, env.ProviderConfigFile)
+			if tt.stdin != nil {
+				assert.Equal(t, "runner-1", RunnerName(env))
+				assert.JSONEq(t, "{}", string(env.EnvironmentV010.BootstrapParams.ExtraSpecs))
+			}
+		})
+	}
+}
+
+type fakeTelemetry struct {
+	deadline time.Time
+	ok       bool
+}
+
+func (f *fakeTelemetry) Tracer() *tracing.Tracer {
+	return nil
+}
+
+func (f *fakeTelemetry) Metrics() *metrics.Registry {
+	return nil
+}
+
+func (f *fakeTelemetry) FlushTelemetry(ctx context.Context) error {
+	f.deadline, f.ok = ctx.Deadline()
+	return nil
+}
+
+func TestFlushDeadline(t *testing.T) {
+	telemetry := &fakeTelemetry{}
+	require.NoError(t, Flush(context.Background(), telemetry))
+	require.True(t, telemetry.ok)
+	assert.WithinDuration(t, time.Now().Add(flushTimeout), telemetry.deadline, time.Second)
+
+	// Providers without telemetry have nothing to flush.
+	assert.NoError(t, Flush(context.Background(), struct{}{}))
+}
@@ -0,0 +1,324 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package daemon runs the provider as a long running process, serving the GARM
+// commands forwarded by the provider executables GARM runs. The daemon keeps the
+// provider, its API client and its caches between commands, and runs commands to
+// completion even if the executable that forwarded them is killed.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/execution"
+	"github.com/cloudbase/garm-provider-common/execution/common"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/command"
+)
+
+// ErrUnavailable is returned by Forward when no daemon serves the socket.
+var ErrUnavailable = errors.New("provider daemon unavailable")
+
+// requestTimeout is how long the daemon waits for a request once a connection is
+// accepted.
+const requestTimeout = 30 * time.Second
+
+// Request is a GARM command forwarded to the daemon.
+type Request struct {
+	// Env holds the environment variables GARM passed to the provider.
+	Env map[string]string `json:"env"`
+	// Stdin holds the data GARM passed on the standard input of the provider.
+	Stdin []byte `json:"stdin,omitempty"`
+}
+
+// NewRequest returns the request forwarding a command, with the environment
+// variables read from getenv.
+func NewRequest(getenv func(string) string, stdin []byte) Request {
+	env := map[string]string{}
+	for _, name := range command.EnvironmentVariables {
+		if value := getenv(name); value != "" {
+			env[name] = value
+		}
+	}
+	return Request{Env: env, Stdin: stdin}
+}
+
+// Getenv returns the value of an environment variable of the request.
+func (r Request) Getenv(name string) string {
+	return r.Env[name]
+}
+
+// Response is the result of a GARM command run by the daemon.
+type Response struct {
+	// Stdout is the output of the command.
+	Stdout string `json:"stdout,omitempty"`
+	// Error is the error returned by the command, if any.
+	Error string `json:"error,omitempty"`
+	// ExitCode is the exit code the provider executable exits with.
+	ExitCode int `json:"exit_code"`
+}
+
+// ProviderFactory creates the provider serving the commands of a GARM controller.
+type ProviderFactory func(configPath, controllerID string) (interface{}, error)
+
+// call is a CreateInstance command being run by the daemon.
+type call struct {
+	done chan struct{}
+	resp Response
+	// waiters is the number of later requests waiting for the command.
+	waiters int
+}
+
+// Server serves the GARM commands for one provider config file.
+type Server struct {
+	configFile  string
+	newProvider ProviderFactory
+
+	mu sync.Mutex
+	// providers holds the providers created so far, by controller ID.
+	providers map[string]interface{}
+	// inflight holds the CreateInstance commands being run, by controller ID and
+	// runner name.
+	inflight map[string]*call
+}
+
+func NewServer(configFile string, newProvider ProviderFactory) *Server {
+	return &Server{
+		configFile:  configFile,
+		newProvider: newProvider,
+		providers:   map[string]interface{}{},
+		inflight:    map[string]*call{},
+	}
+}
+
+// Listen listens on a Unix socket, only accessible to the current user. A socket
+// left behind by a daemon that is no longer running is replaced.
+func Listen(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// Serve serves the commands sent to the listener, until ctx is done. Commands
+// are run with ctx, not bound to the connection they were received on, so that
+// they complete even if the client goes away.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var req Request
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Printf("failed to read request: %s", err)
+		return
+	}
+	resp := s.Run(ctx, req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("failed to send response of %s: %s", req.Getenv("GARM_COMMAND"), err)
+	}
+	// Telemetry is flushed once the response is sent, so GARM does not wait for it.
+	s.flush(req.Getenv("GARM_CONTROLLER_ID"))
+}
+
+// flush writes the metrics and exports the spans recorded by the provider of a
+// GARM controller, if it was created. Failures are only logged.
+func (s *Server) flush(controllerID string) {
+	s.mu.Lock()
+	prov, ok := s.providers[controllerID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := command.Flush(context.Background(), prov); err != nil {
+		log.Printf("%s", err)
+	}
+}
+
+// provider returns the provider of a GARM controller, creating it if needed.
+func (s *Server) provider(controllerID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prov, ok := s.providers[controllerID]; ok {
+		return prov, nil
+	}
+	prov, err := s.newProvider(s.configFile, controllerID)
+	if err != nil {
+		return nil, err
+	}
+	s.providers[controllerID] = prov
+	return prov, nil
+}
+
+// sameFile returns true if both paths point to the same file.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func errorResponse(err error) Response {
+	return Response{
+		Error:    err.Error(),
+		ExitCode: common.ResolveErrorToExitCode(err),
+	}
+}
+
+// Run runs a forwarded command. A CreateInstance command for a runner that is
+// already being created, for instance when GARM retries it after a restart, waits
+// for the command in progress instead of starting another one.
+func (s *Server) Run(ctx context.Context, req Request) Response {
+	env, err := command.Environment(req.Getenv, req.Stdin)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !sameFile(env.ProviderConfigFile, s.configFile) {
+		return errorResponse(fmt.Errorf("the daemon serves the config file %s, not %s", s.configFile, env.ProviderConfigFile))
+	}
+
+	if req.Getenv("GARM_COMMAND") != string(common.CreateInstanceCommand) {
+		return s.run(ctx, env, req)
+	}
+
+	key := env.ControllerID + "/" + command.RunnerName(env)
+	s.mu.Lock()
+	if c, ok := s.inflight[key]; ok {
+		c.waiters++
+		s.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.resp
+		case <-ctx.Done():
+			return errorResponse(ctx.Err())
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	s.inflight[key] = c
+	s.mu.Unlock()
+
+	c.resp = s.run(ctx, env, req)
+	s.mu.Lock()
+	delete(s.inflight, key)
+	s.mu.Unlock()
+	close(c.done)
+	return c.resp
+}
+
+func (s *Server) run(ctx context.Context, env execution.Environment, req Request) Response {
+	prov, err := s.provider(env.ControllerID)
+	if err != nil {
+		return errorResponse(fmt.Errorf("failed to create provider: %w", err))
+	}
+
+	result, err := command.Run(ctx, env, req.Getenv, prov)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return Response{Stdout: result}
+}
+
+// Socket returns the socket of the daemon serving a provider config file. An
+// ErrUnavailable error is returned if the config does not enable the daemon.
+func Socket(configPath string) (string, error) {
+	if configPath == "" {
+		return "", fmt.Errorf("%w: missing GARM_PROVIDER_CONFIG_FILE", ErrUnavailable)
+	}
+	cfg, err := config.NewConfig(configPath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if cfg.Daemon.Socket == "" {
+		return "", fmt.Errorf("%w: daemon not enabled", ErrUnavailable)
+	}
+	return cfg.Daemon.Socket, nil
+}
+
+// Forward sends a command to the daemon listening on socket, and waits for its
+// response. An ErrUnavailable error is returned if no daemon is listening, in
+// which case the command was not run.
+func Forward(ctx context.Context, socket string, req Request) (Response, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, fmt.Errorf("failed to send command to the daemon: %w", err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("failed to read the daemon response: %w", err)
+	}
+	return resp, nil
+}
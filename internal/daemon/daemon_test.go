@@ -0,0 +1,201 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/execution/common"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	created atomic.Int32
+	release chan struct{}
+}
+
+func (f *fakeProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	f.created.Add(1)
+	<-f.release
+	return params.ProviderInstance{ProviderID: "device-1", Name: bootstrapParams.Name}, nil
+}
+
+func (f *fakeProvider) DeleteInstance(ctx context.Context, instance string) error {
+	return nil
+}
+
+func (f *fakeProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
+	return params.ProviderInstance{}, fmt.Errorf("instance %s: %w", instance, gErrors.ErrNotFound)
+}
+
+func (f *fakeProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	return []params.ProviderInstance{}, nil
+}
+
+func (f *fakeProvider) RemoveAllInstances(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeProvider) Stop(ctx context.Context, instance string, force bool) error {
+	return nil
+}
+
+func (f *fakeProvider) Start(ctx context.Context, instance string) error {
+	return nil
+}
+
+func (f *fakeProvider) GetVersion(ctx context.Context) string {
+	return "v1.0.0"
+}
+
+// startServer starts a daemon serving a fake provider, and returns it with its
+// socket and config file.
+func startServer(t *testing.T, prov *fakeProvider) (*Server, string, string) {
+	dir, err := os.MkdirTemp("", "daemon")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	configFile := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(configFile, nil, 0o600))
+	socket := filepath.Join(dir, "daemon.sock")
+
+	ln, err := Listen(socket)
+	require.NoError(t, err)
+	server := NewServer(configFile, func(configPath, controllerID string) (interface{}, error) {
+		return prov, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+	return server, socket, configFile
+}
+
+func TestForward(t *testing.T) {
+	_, socket, configFile := startServer(t, &fakeProvider{})
+	ctx := context.Background()
+
+	resp, err := Forward(ctx, socket, Request{Env: map[string]string{
+		"GARM_COMMAND":              "GetVersion",
+		"GARM_CONTROLLER_ID":        "controller",
+		"GARM_PROVIDER_CONFIG_FILE": configFile,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, Response{Stdout: "v1.0.0"}, resp)
+
+	resp, err = Forward(ctx, socket, Request{Env: map[string]string{
+		"GARM_COMMAND":              "GetInstance",
+		"GARM_CONTROLLER_ID":        "controller",
+		"GARM_INSTANCE_ID":          "device-1",
+		"GARM_PROVIDER_CONFIG_FILE": configFile,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, common.ExitCodeNotFound, resp.ExitCode)
+	assert.Contains(t, resp.Error, "instance device-1")
+
+	otherConfig := filepath.Join(filepath.Dir(configFile), "other.toml")
+	require.NoError(t, os.WriteFile(otherConfig, nil, 0o600))
+	resp, err = Forward(ctx, socket, Request{Env: map[string]string{
+		"GARM_COMMAND":              "GetVersion",
+		"GARM_CONTROLLER_ID":        "controller",
+		"GARM_PROVIDER_CONFIG_FILE": otherConfig,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.ExitCode)
+	assert.Contains(t, resp.Error, "the daemon serves the config file")
+}
+
+func TestForwardJoinsCreateInstanceInProgress(t *testing.T) {
+	prov := &fakeProvider{release: make(chan struct{})}
+	server, socket, configFile := startServer(t, prov)
+	req := Request{
+		Env: map[string]string{
+			"GARM_COMMAND":              "CreateInstance",
+			"GARM_CONTROLLER_ID":        "controller",
+			"GARM_POOL_ID":              "pool",
+			"GARM_PROVIDER_CONFIG_FILE": configFile,
+		},
+		Stdin: []byte(`{"name": "runner-1"}`),
+	}
+
+	// The first client goes away while the instance is created, as it would when
+	// GARM restarts.
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		_, err := Forward(ctx, socket, req)
+		assert.Error(t, err)
+	}()
+	require.Eventually(t, func() bool { return prov.created.Load() == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+	<-firstDone
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := Forward(context.Background(), socket, req)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"provider_id": "device-1", "name": "runner-1"}`, resp.Stdout)
+	}()
+	require.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		c, ok := server.inflight["controller/runner-1"]
+		return ok && c.waiters == 1
+	}, 5*time.Second, time.Millisecond)
+	close(prov.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), prov.created.Load(), "the instance must be created once")
+}
+
+func TestForwardUnavailable(t *testing.T) {
+	_, err := Forward(context.Background(), filepath.Join(t.TempDir(), "missing.sock"), Request{})
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "daemon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "daemon.sock")
+
+	// A socket file left behind by a daemon that was killed.
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = Listen(socket)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, err = Listen(socket)
+	assert.ErrorContains(t, err, "a daemon is already listening")
+}
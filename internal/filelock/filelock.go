@@ -0,0 +1,130 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package filelock serializes access to files shared by the provider processes
+// that GARM runs concurrently.
+package filelock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// retryInterval is the interval at which LockContext tries to take a lock held by
+// another process.
+const retryInterval = 50 * time.Millisecond
+
+// Lock takes an exclusive lock on the given lock file, creating it and its parent
+// directory if needed. It blocks until the lock is acquired. The returned function
+// releases the lock.
+func Lock(path string) (func() error, error) {
+	file, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return unlocker(file), nil
+}
+
+// LockContext takes an exclusive lock on the given lock file, like Lock, but gives
+// up when ctx is done, so that a process holding the lock for too long does not
+// block the others past their own deadlines.
+func LockContext(ctx context.Context, path string) (func() error, error) {
+	file, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			return unlocker(file), nil
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func openLockFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create lock dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	return file, nil
+}
+
+func unlocker(file *os.File) func() error {
+	return func() error {
+		defer file.Close()
+		return unlockFile(file)
+	}
+}
+
+// PrivateDir creates the given directory if needed, and checks that it is private
+// to the current user: owned by the user, and not writable by other users. The
+// files shared by the provider processes are only trusted in such a directory.
+func PrivateDir(path string) error {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return checkPrivate(path, info)
+}
+
+// WriteFileAtomic writes data to a temporary file next to path, and renames it
+// over path, so readers never see a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+//go:build !unix
+
+package filelock
+
+import "os"
+
+// File locks are only supported on unix systems. Elsewhere, concurrent provider
+// processes are not serialized, and the owner of directories is not checked.
+
+func checkPrivate(path string, info os.FileInfo) error {
+	return nil
+}
+
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
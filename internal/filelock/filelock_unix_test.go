@@ -0,0 +1,52 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+//go:build unix
+
+package filelock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivateDirWritableByOthers(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	require.NoError(t, os.Chmod(dir, 0o777))
+	assert.ErrorContains(t, PrivateDir(dir), "is writable by other users")
+}
+
+func TestLockContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "quotas.lock")
+	unlock, err := Lock(path)
+	require.NoError(t, err)
+
+	// A lock held by another holder is given up on once ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = LockContext(ctx, path)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, unlock())
+	unlock, err = LockContext(context.Background(), path)
+	require.NoError(t, err)
+	require.NoError(t, unlock())
+}
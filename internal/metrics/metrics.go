@@ -0,0 +1,339 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package metrics records metrics about the provider operations, and merges them
+// into a Prometheus textfile, to be exported by the node_exporter textfile
+// collector. Every provider invocation is a short lived process, so all metrics
+// are counters or histograms, which are summed across processes.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+)
+
+const (
+	CommandDurationMetric     = "garm_equinix_command_duration_seconds"
+	APIRequestsMetric         = "garm_equinix_api_requests_total"
+	TimeToActiveMetric        = "garm_equinix_provisioning_time_to_active_seconds"
+	ProvisioningFailureMetric = "garm_equinix_provisioning_failures_total"
+)
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	histogramType metricType = "histogram"
+)
+
+type family struct {
+	help       string
+	metricType metricType
+	buckets    []float64
+}
+
+var families = map[string]family{
+	CommandDurationMetric: {
+		help:       "Duration of the provider commands run by GARM, in seconds.",
+		metricType: histogramType,
+		buckets:    []float64{1, 5, 15, 30, 60, 120, 300, 600, 900, 1200, 1800, 3600},
+	},
+	APIRequestsMetric: {
+		help:       "Number of requests made to the Equinix Metal API, by operation and status code.",
+		metricType: counterType,
+	},
+	TimeToActiveMetric: {
+		help:       "Time from the start of CreateInstance until the device is active, in seconds.",
+		metricType: histogramType,
+		buckets:    []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600},
+	},
+	ProvisioningFailureMetric: {
+		help:       "Number of devices that failed to provision, by metro, plan and reason.",
+		metricType: counterType,
+	},
+}
+
+// Label is a metric label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(label.Value)
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, label.Name, value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case v == float64(int64(v)):
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// Registry holds the metrics recorded by this process, until they are merged into
+// the textfile. All methods may be called on a nil registry, which records
+// nothing.
+type Registry struct {
+	mu      sync.Mutex
+	samples map[string]float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		samples: map[string]float64{},
+	}
+}
+
+// Add adds a value to a counter.
+func (r *Registry) Add(name string, value float64, labels ...Label) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[name+formatLabels(labels)] += value
+}
+
+// Observe records one observation of a histogram.
+func (r *Registry) Observe(name string, value float64, labels ...Label) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucketLabels := func(le string) []Label {
+		return append(append([]Label{}, labels...), Label{"le", le})
+	}
+	// Every bucket is written, so that the histogram is complete in the textfile.
+	for _, bucket := range families[name].buckets {
+		series := name + "_bucket" + formatLabels(bucketLabels(formatFloat(bucket)))
+		if value <= bucket {
+			r.samples[series]++
+		} else {
+			r.samples[series] += 0
+		}
+	}
+	r.samples[name+"_bucket"+formatLabels(bucketLabels("+Inf"))]++
+	r.samples[name+"_sum"+formatLabels(labels)] += value
+	r.samples[name+"_count"+formatLabels(labels)]++
+}
+
+// Samples returns a copy of the samples recorded so far, indexed by series.
+func (r *Registry) Samples() map[string]float64 {
+	if r == nil {
+		return map[string]float64{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make(map[string]float64, len(r.samples))
+	for series, value := range r.samples {
+		ret[series] = value
+	}
+	return ret
+}
+
+// familyOf returns the metric family of a series.
+func familyOf(series string) (string, bool) {
+	name := series
+	if idx := strings.Index(series, "{"); idx >= 0 {
+		name = series[:idx]
+	}
+	if _, ok := families[name]; ok {
+		return name, true
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		if f, ok := families[base]; ok && base != name && f.metricType == histogramType {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// parseTextfile returns the samples of the known metric families in a textfile.
+// Other samples are dropped.
+func parseTextfile(data []byte) map[string]float64 {
+	ret := map[string]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			continue
+		}
+		series := line[:idx]
+		value, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := familyOf(series); ok {
+			ret[series] = value
+		}
+	}
+	return ret
+}
+
+// seriesSortKey orders the series of a histogram by label set, with the buckets
+// in ascending order, followed by the sum and count.
+func seriesSortKey(series string) (string, int, float64) {
+	name, labels := series, ""
+	if idx := strings.Index(series, "{"); idx >= 0 {
+		name, labels = series[:idx], series[idx:]
+	}
+
+	rank := 0
+	switch {
+	case strings.HasSuffix(name, "_sum"):
+		rank = 1
+	case strings.HasSuffix(name, "_count"):
+		rank = 2
+	}
+
+	le := 0.0
+	if idx := strings.LastIndex(labels, `le="`); idx >= 0 {
+		value := strings.TrimSuffix(labels[idx+len(`le="`):], `"}`)
+		le, _ = strconv.ParseFloat(value, 64)
+		labels = strings.TrimSuffix(labels[:idx], ",") + "}"
+		if labels == "{}" {
+			labels = ""
+		}
+	}
+	return labels, rank, le
+}
+
+func formatTextfile(samples map[string]float64) []byte {
+	byFamily := map[string][]string{}
+	for series := range samples {
+		name, ok := familyOf(series)
+		if !ok {
+			continue
+		}
+		byFamily[name] = append(byFamily[name], series)
+	}
+
+	names := make([]string, 0, len(byFamily))
+	for name := range byFamily {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		series := byFamily[name]
+		sort.Slice(series, func(i, j int) bool {
+			li, ri, vi := seriesSortKey(series[i])
+			lj, rj, vj := seriesSortKey(series[j])
+			if li != lj {
+				return li < lj
+			}
+			if ri != rj {
+				return ri < rj
+			}
+			return vi < vj
+		})
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, families[name].help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, families[name].metricType)
+		for _, s := range series {
+			fmt.Fprintf(&buf, "%s %s\n", s, formatFloat(samples[s]))
+		}
+	}
+	return buf.Bytes()
+}
+
+// MergeTextfile adds the samples recorded by the registry to the ones already in
+// the textfile at path. The textfile is locked while being updated, and replaced
+// atomically, so that concurrent provider processes and the node_exporter never
+// see a partial file. Once merged, the samples of the registry are reset.
+func (r *Registry) MergeTextfile(path string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.samples) == 0 {
+		return nil
+	}
+
+	// The lock file must not end in .prom, or the node_exporter will try to read it.
+	unlock, err := filelock.Lock(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read textfile: %w", err)
+	}
+	samples := parseTextfile(data)
+	for series, value := range r.samples {
+		samples[series] += value
+	}
+	if err := filelock.WriteFileAtomic(path, formatTextfile(samples), 0o644); err != nil {
+		return fmt.Errorf("failed to write textfile: %w", err)
+	}
+	r.samples = map[string]float64{}
+	return nil
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// ObserveCommand records the duration of a provider command.
+func (r *Registry) ObserveCommand(command string, duration time.Duration, err error) {
+	r.Observe(CommandDurationMetric, duration.Seconds(), Label{"command", command}, Label{"result", result(err)})
+}
+
+// IncAPIRequest counts one request made to the Equinix Metal API.
+func (r *Registry) IncAPIRequest(operation, code string) {
+	r.Add(APIRequestsMetric, 1, Label{"operation", operation}, Label{"code", code})
+}
+
+// ObserveTimeToActive records the time it took for a device to become active.
+func (r *Registry) ObserveTimeToActive(metro, plan string, duration time.Duration) {
+	r.Observe(TimeToActiveMetric, duration.Seconds(), Label{"metro", metro}, Label{"plan", plan})
+}
+
+// IncProvisioningFailure counts one device that failed to provision.
+func (r *Registry) IncProvisioningFailure(metro, plan, reason string) {
+	r.Add(ProvisioningFailureMetric, 1, Label{"metro", metro}, Label{"plan", plan}, Label{"reason", reason})
+}
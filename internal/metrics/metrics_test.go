@@ -0,0 +1,123 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserve(t *testing.T) {
+	r := NewRegistry()
+	r.Observe(TimeToActiveMetric, 700, Label{"metro", "da"}, Label{"plan", "m3.small.x86"})
+
+	samples := r.Samples()
+	labels := `metro="da",plan="m3.small.x86"`
+	assert.Equal(t, 0.0, samples[TimeToActiveMetric+`_bucket{`+labels+`,le="600"}`])
+	assert.Equal(t, 1.0, samples[TimeToActiveMetric+`_bucket{`+labels+`,le="900"}`])
+	assert.Equal(t, 1.0, samples[TimeToActiveMetric+`_bucket{`+labels+`,le="+Inf"}`])
+	assert.Equal(t, 700.0, samples[TimeToActiveMetric+"_sum{"+labels+"}"])
+	assert.Equal(t, 1.0, samples[TimeToActiveMetric+"_count{"+labels+"}"])
+}
+
+func TestFormatLabels(t *testing.T) {
+	assert.Equal(t, "", formatLabels(nil))
+	assert.Equal(t, `{reason="say \"hi\"\n"}`, formatLabels([]Label{{"reason", "say \"hi\"\n"}}))
+}
+
+func TestMergeTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "equinix.prom")
+	require.NoError(t, os.WriteFile(path, []byte("# HELP unknown_metric Dropped.\nunknown_metric 3\n"), 0o644))
+
+	first := NewRegistry()
+	first.Add(APIRequestsMetric, 1, Label{"operation", "GET /devices/{id}"}, Label{"code", "200"})
+	first.Observe(CommandDurationMetric, 2, Label{"command", "CreateInstance"}, Label{"result", "success"})
+	require.NoError(t, first.MergeTextfile(path))
+	assert.Empty(t, first.Samples())
+
+	second := NewRegistry()
+	second.Add(APIRequestsMetric, 2, Label{"operation", "GET /devices/{id}"}, Label{"code", "200"})
+	second.Add(ProvisioningFailureMetric, 1, Label{"metro", "da"}, Label{"plan", "m3.small.x86"}, Label{"reason", "timeout"})
+	require.NoError(t, second.MergeTextfile(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	expected := `# HELP garm_equinix_api_requests_total Number of requests made to the Equinix Metal API, by operation and status code.
+# TYPE garm_equinix_api_requests_total counter
+garm_equinix_api_requests_total{operation="GET /devices/{id}",code="200"} 3
+# HELP garm_equinix_command_duration_seconds Duration of the provider commands run by GARM, in seconds.
+# TYPE garm_equinix_command_duration_seconds histogram
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="1"} 0
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="5"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="15"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="30"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="60"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="120"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="300"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="600"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="900"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="1200"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="1800"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="3600"} 1
+garm_equinix_command_duration_seconds_bucket{command="CreateInstance",result="success",le="+Inf"} 1
+garm_equinix_command_duration_seconds_sum{command="CreateInstance",result="success"} 2
+garm_equinix_command_duration_seconds_count{command="CreateInstance",result="success"} 1
+# HELP garm_equinix_provisioning_failures_total Number of devices that failed to provision, by metro, plan and reason.
+# TYPE garm_equinix_provisioning_failures_total counter
+garm_equinix_provisioning_failures_total{metro="da",plan="m3.small.x86",reason="timeout"} 1
+`
+	assert.Equal(t, expected, string(data))
+}
+
+func TestMergeTextfileWithoutSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "equinix.prom")
+	require.NoError(t, NewRegistry().MergeTextfile(path))
+	assert.NoFileExists(t, path)
+}
+
+func TestOperation(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://api.equinix.com/metal/v1/devices/76e33e9e-6155-472e-ae76-37b5401f888f/events?per_page=5", nil)
+	assert.Equal(t, "GET /devices/{id}/events", Operation(req))
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	registry := NewRegistry()
+	client := &http.Client{Transport: NewTransport(registry, nil), Timeout: time.Second}
+	resp, err := client.Get(server.URL + "/metal/v1/projects/76e33e9e-6155-472e-ae76-37b5401f888f/devices")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, map[string]float64{
+		APIRequestsMetric + `{operation="GET /projects/{id}/devices",code="404"}`: 1,
+	}, registry.Samples())
+
+	// A nil registry records nothing.
+	client = &http.Client{Transport: NewTransport(nil, nil), Timeout: time.Second}
+	resp, err = client.Get(server.URL + "/metal/v1/projects")
+	require.NoError(t, err)
+	resp.Body.Close()
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var uuidRegexp = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// Operation returns the operation of an API request, as its method and path, with
+// the IDs replaced by a placeholder to keep the number of series bounded.
+func Operation(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.Path, "/metal/v1")
+	return req.Method + " " + uuidRegexp.ReplaceAllString(path, "{id}")
+}
+
+type transport struct {
+	registry *Registry
+	base     http.RoundTripper
+}
+
+// NewTransport returns an http.RoundTripper counting the requests made through
+// base in registry. The http.DefaultTransport is used if base is nil.
+func NewTransport(registry *Registry, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{registry: registry, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.registry.IncAPIRequest(Operation(req), code)
+	return resp, err
+}
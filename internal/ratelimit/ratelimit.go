@@ -0,0 +1,184 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package ratelimit limits the rate of the requests made to the Equinix Metal API
+// by all the provider processes that GARM runs concurrently. The limiter is a
+// token bucket, persisted in a file shared by the processes.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+)
+
+// state is the token bucket, as persisted in the state file.
+type state struct {
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// NotBefore is set when the API asked clients to back off. No tokens are
+	// handed out until then.
+	NotBefore time.Time `json:"not_before,omitempty"`
+}
+
+// Limiter is a token bucket shared by processes through a state file.
+type Limiter struct {
+	path  string
+	rate  float64
+	burst float64
+	now   func() time.Time
+}
+
+// New returns a limiter allowing rate requests per second on average, and bursts
+// of up to burst requests, persisted in the file at path.
+func New(path string, rate float64, burst int) *Limiter {
+	return &Limiter{
+		path:  path,
+		rate:  rate,
+		burst: float64(max(burst, 1)),
+		now:   time.Now,
+	}
+}
+
+// update loads the bucket, refilled up to now, passes it to fn and saves it, while
+// holding the lock of the state file.
+func (l *Limiter) update(fn func(s *state, now time.Time)) error {
+	unlock, err := filelock.Lock(l.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := l.now()
+	s := state{Tokens: l.burst, UpdatedAt: now}
+	data, err := os.ReadFile(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read rate limiter state: %w", err)
+	}
+	// A corrupted state is replaced by a full bucket.
+	if len(data) > 0 && json.Unmarshal(data, &s) == nil && now.After(s.UpdatedAt) {
+		s.Tokens = min(l.burst, s.Tokens+now.Sub(s.UpdatedAt).Seconds()*l.rate)
+		s.UpdatedAt = now
+	}
+
+	fn(&s, now)
+
+	data, err = json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limiter state: %w", err)
+	}
+	if err := filelock.WriteFileAtomic(l.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write rate limiter state: %w", err)
+	}
+	return nil
+}
+
+// reserve takes a token if one is available. Otherwise, it returns how long to
+// wait before trying again.
+func (l *Limiter) reserve() (time.Duration, error) {
+	var wait time.Duration
+	err := l.update(func(s *state, now time.Time) {
+		switch {
+		case now.Before(s.NotBefore):
+			wait = s.NotBefore.Sub(now)
+		case s.Tokens >= 1:
+			s.Tokens--
+		default:
+			wait = time.Duration((1 - s.Tokens) / l.rate * float64(time.Second))
+		}
+	})
+	return wait, err
+}
+
+// Wait blocks until a request may be made, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		wait, err := l.reserve()
+		if err != nil {
+			return err
+		}
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff stops handing out tokens to all processes for the given duration, and
+// empties the bucket.
+func (l *Limiter) Backoff(d time.Duration) error {
+	return l.update(func(s *state, now time.Time) {
+		if notBefore := now.Add(d); notBefore.After(s.NotBefore) {
+			s.NotBefore = notBefore
+		}
+		s.Tokens = 0
+	})
+}
+
+// defaultBackoff is how long processes back off after a 429 response without a
+// Retry-After header.
+const defaultBackoff = 5 * time.Second
+
+// retryAfter returns the back off duration requested by a 429 response.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return defaultBackoff
+}
+
+type transport struct {
+	limiter *Limiter
+	base    http.RoundTripper
+}
+
+// NewTransport returns an http.RoundTripper waiting for the limiter before every
+// request made through base. When the API responds with 429 Too Many Requests,
+// all processes back off for the requested duration. The http.DefaultTransport is
+// used if base is nil.
+func NewTransport(limiter *Limiter, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{limiter: limiter, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, fmt.Errorf("rate limiter: %w", err)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		// The response is returned as is, the back off only delays later requests.
+		_ = t.limiter.Backoff(retryAfter(resp))
+	}
+	return resp, err
+}
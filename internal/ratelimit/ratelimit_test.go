@@ -0,0 +1,110 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserve(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "ratelimit.json")
+	// Two limiters sharing a state file, as two provider processes would.
+	first := New(path, 2, 2)
+	first.now = func() time.Time { return now }
+	second := New(path, 2, 2)
+	second.now = func() time.Time { return now }
+
+	wait, err := first.reserve()
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	wait, err = second.reserve()
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = first.reserve()
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait, "the bucket is empty")
+
+	now = now.Add(250 * time.Millisecond)
+	wait, err = second.reserve()
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, wait, "half a token was refilled")
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		wait, err = first.reserve()
+		require.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+	wait, err = first.reserve()
+	require.NoError(t, err)
+	assert.NotZero(t, wait, "the bucket is refilled up to the burst only")
+}
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(filepath.Join(t.TempDir(), "ratelimit.json"), 10, 10)
+	limiter.now = func() time.Time { return now }
+
+	require.NoError(t, limiter.Backoff(3*time.Second))
+	wait, err := limiter.reserve()
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, wait)
+
+	now = now.Add(3 * time.Second)
+	wait, err = limiter.reserve()
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestWait(t *testing.T) {
+	limiter := New(filepath.Join(t.TempDir(), "ratelimit.json"), 0.001, 1)
+	require.NoError(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestTransportBacksOffOnTooManyRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(filepath.Join(t.TempDir(), "ratelimit.json"), 10, 10)
+	limiter.now = func() time.Time { return now }
+
+	client := &http.Client{Transport: NewTransport(limiter, nil)}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	wait, err := limiter.reserve()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, wait)
+}
@@ -28,6 +28,23 @@ import (
 const (
 	ControllerIDTagName = "garm-controller-id"
 	PoolIDTagName       = "garm-pool-id"
+	// RecycleTagName marks devices which are reinstalled and parked for reuse by
+	// their pool when the runner is deleted, instead of being deleted.
+	RecycleTagName = "garm-recycle"
+	// IdleTagName marks devices parked for reuse, which are not assigned to a runner.
+	IdleTagName = "garm-idle"
+	// WarmTagName marks devices pre-provisioned for a pool, which are not yet
+	// assigned to a runner.
+	WarmTagName = "garm-warm"
+	// HourlyPriceTagName holds the estimated hourly price of a device in USD, as
+	// computed when the device was created for its runner.
+	HourlyPriceTagName = "garm-hourly-price"
+	// PlanTagName holds the plan a device was created with, which may be one of
+	// the fallback plans of the pool.
+	PlanTagName = "garm-plan"
+	// MetroTagName holds the metro chosen for a spot device, out of the spot metros
+	// of the pool.
+	MetroTagName = "garm-metro"
 )
 
 type ToolFetchFunc func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error)
@@ -88,11 +105,19 @@ type extraSpecs struct {
 	DisableUpdates        *bool    `json:"disable_updates,omitempty" jsonschema:"description=Disable automatic updates on the VM."`
 	EnableBootDebug       *bool    `json:"enable_boot_debug,omitempty" jsonschema:"description=Enable boot debug on the VM."`
 	ExtraPackages         []string `json:"extra_packages,omitempty" jsonschema:"description=Extra packages to install on the VM."`
+	RecycleDevices        *bool    `json:"recycle_devices,omitempty" jsonschema:"description=Reinstall and park devices for reuse by the pool when runners are deleted, instead of deleting them."`
+	PlanFallbacks         []string `json:"plan_fallbacks,omitempty" jsonschema:"description=Ordered list of plans to create the devices with when the plan of the pool is out of stock."`
+	SpotInstance          *bool    `json:"spot_instance,omitempty" jsonschema:"description=Create spot devices instead of on-demand devices."`
+	SpotPriceMax          *float64 `json:"spot_price_max,omitempty" jsonschema:"description=The maximum hourly price in USD to bid for spot devices."`
+	SpotBid               *string  `json:"spot_bid,omitempty" jsonschema:"description=Compute the spot bid from the spot price history of the plan as a percentile with an optional markup such as p90+10%. The bid is capped by spot_price_max."`
+	SpotMetros            []string `json:"spot_metros,omitempty" jsonschema:"description=The metros in which spot devices may be created. The metro with the cheapest spot price is chosen."`
+	Tags                  []string `json:"tags,omitempty" jsonschema:"description=Extra tags to set on the devices. Tags are Go templates that may use the variables of the runner."`
+	Description           *string  `json:"description,omitempty" jsonschema:"description=The description of the devices. The description is a Go template that may use the variables of the runner."`
 	// The Cloudconfig struct from common package
 	cloudconfig.CloudConfigSpec
 }
 
-func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerID string) (*RunnerSpec, error) {
+func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerID string, names TagNames) (*RunnerSpec, error) {
 	tools, err := DefaultToolFetch(data.OSType, data.OSArch, data.Tools)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tools: %s", err)
@@ -104,10 +129,11 @@ func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerI
 	}
 
 	tags := []string{
-		fmt.Sprintf("%s=%s", PoolIDTagName, data.PoolID),
-		fmt.Sprintf("%s=%s", ControllerIDTagName, controllerID),
-		fmt.Sprintf("OSArch=%s", data.OSArch),
-		fmt.Sprintf("Name=%s", data.Name),
+		fmt.Sprintf("%s=%s", names.PoolID, data.PoolID),
+		fmt.Sprintf("%s=%s", names.ControllerID, controllerID),
+		fmt.Sprintf("%s=%s", names.OSType, data.OSType),
+		fmt.Sprintf("%s=%s", names.OSArch, data.OSArch),
+		fmt.Sprintf("%s=%s", names.Name, data.Name),
 	}
 
 	spec := &RunnerSpec{
@@ -115,8 +141,17 @@ func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerI
 		BootstrapParams: data,
 		Tools:           tools,
 		Tags:            tags,
+		ControllerID:    controllerID,
+		TagNames:        names,
 	}
 	spec.MergeExtraSpecs(extraSpecs)
+	if extraSpecs.SpotBid != nil {
+		bid, err := ParseSpotBid(*extraSpecs.SpotBid)
+		if err != nil {
+			return nil, fmt.Errorf("error loading extra specs: %w", err)
+		}
+		spec.SpotBid = &bid
+	}
 
 	if err := spec.Validate(); err != nil {
 		return nil, fmt.Errorf("error validating spec: %w", err)
@@ -132,9 +167,28 @@ type RunnerSpec struct {
 	DisableUpdates        bool
 	ExtraPackages         []string
 	EnableBootDebug       bool
-	Tools                 params.RunnerApplicationDownload
-	Tags                  []string
-	BootstrapParams       params.BootstrapInstance
+	RecycleDevices        *bool
+	PlanFallbacks         []string
+	SpotInstance          bool
+	// SpotPriceMax is the maximum hourly price bid for spot devices, in USD.
+	SpotPriceMax *float64
+	// SpotBid computes the bid of spot devices from the spot price history. The
+	// computed bid is capped by SpotPriceMax.
+	SpotBid *SpotBid
+	// SpotMetros are the metros out of which the cheapest is chosen for spot
+	// devices.
+	SpotMetros []string
+	Tools      params.RunnerApplicationDownload
+	Tags       []string
+	// CustomTags are the templates of the extra tags of the pool.
+	CustomTags []string
+	// DescriptionTemplate is the template of the device description of the pool.
+	DescriptionTemplate *string
+	// Description is the rendered device description, set by ApplyTemplates.
+	Description     string
+	ControllerID    string
+	TagNames        TagNames
+	BootstrapParams params.BootstrapInstance
 }
 
 func (r RunnerSpec) Validate() error {
@@ -146,6 +200,14 @@ func (r RunnerSpec) Validate() error {
 		return fmt.Errorf("invalid bootstrap params")
 	}
 
+	if r.SpotPriceMax != nil && *r.SpotPriceMax <= 0 {
+		return fmt.Errorf("spot_price_max must be positive")
+	}
+
+	if r.SpotBid != nil && r.SpotPriceMax == nil {
+		return fmt.Errorf("spot_bid requires spot_price_max as a ceiling")
+	}
+
 	return nil
 }
 
@@ -165,6 +227,34 @@ func (r *RunnerSpec) MergeExtraSpecs(spec extraSpecs) {
 	if spec.EnableBootDebug != nil {
 		r.EnableBootDebug = *spec.EnableBootDebug
 	}
+
+	if spec.RecycleDevices != nil {
+		r.RecycleDevices = spec.RecycleDevices
+	}
+
+	if len(spec.PlanFallbacks) > 0 {
+		r.PlanFallbacks = spec.PlanFallbacks
+	}
+
+	if spec.SpotInstance != nil {
+		r.SpotInstance = *spec.SpotInstance
+	}
+
+	if spec.SpotPriceMax != nil {
+		r.SpotPriceMax = spec.SpotPriceMax
+	}
+
+	if len(spec.SpotMetros) > 0 {
+		r.SpotMetros = spec.SpotMetros
+	}
+
+	if len(spec.Tags) > 0 {
+		r.CustomTags = spec.Tags
+	}
+
+	if spec.Description != nil {
+		r.DescriptionTemplate = spec.Description
+	}
 }
 
 func (r *RunnerSpec) ComposeUserData() (string, error) {
@@ -99,6 +99,16 @@ func TestNewExtraSpecsFromBootstrapData(t *testing.T) {
 			},
 			errString: "",
 		},
+		{
+			name: "specs just with RecycleDevices",
+			specs: params.BootstrapInstance{
+				ExtraSpecs: []byte(`{"recycle_devices": true}`),
+			},
+			expectedOutput: extraSpecs{
+				RecycleDevices: Ptr(true),
+			},
+			errString: "",
+		},
 		{
 			name: "specs just with ExtraPackages",
 			specs: params.BootstrapInstance{
@@ -227,6 +237,18 @@ func TestNewExtraSpecsFromBootstrapData(t *testing.T) {
 			expectedOutput: extraSpecs{},
 			errString:      "extra_context: Invalid type. Expected: object, given: string",
 		},
+		{
+			name: "spot specs",
+			specs: params.BootstrapInstance{
+				ExtraSpecs: []byte(`{"spot_instance": true, "spot_price_max": 0.5, "spot_bid": "p90+10%", "spot_metros": ["am", "fr"]}`),
+			},
+			expectedOutput: extraSpecs{
+				SpotInstance: Ptr(true),
+				SpotPriceMax: Ptr(0.5),
+				SpotBid:      Ptr("p90+10%"),
+				SpotMetros:   []string{"am", "fr"},
+			},
+		},
 		{
 			name: "invalid input - additional property",
 			specs: params.BootstrapInstance{
@@ -292,6 +314,36 @@ func TestValidate(t *testing.T) {
 			},
 			errString: "invalid bootstrap params",
 		},
+		{
+			name: "Negative spot price max",
+			spec: RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:          "name",
+					OSType:        "os",
+					InstanceToken: "token",
+				},
+				Tools: params.RunnerApplicationDownload{
+					DownloadURL: Ptr("url"),
+				},
+				SpotPriceMax: Ptr(-0.5),
+			},
+			errString: "spot_price_max must be positive",
+		},
+		{
+			name: "Spot bid without ceiling",
+			spec: RunnerSpec{
+				BootstrapParams: params.BootstrapInstance{
+					Name:          "name",
+					OSType:        "os",
+					InstanceToken: "token",
+				},
+				Tools: params.RunnerApplicationDownload{
+					DownloadURL: Ptr("url"),
+				},
+				SpotBid: &SpotBid{Percentile: 90},
+			},
+			errString: "spot_bid requires spot_price_max as a ceiling",
+		},
 	}
 
 	for _, tt := range tests {
@@ -340,12 +392,15 @@ func TestGetRunnerSpecFromBootstrapParams(t *testing.T) {
 		Tags: []string{
 			"garm-pool-id=test-pool",
 			"garm-controller-id=test-controller",
+			"OSType=linux",
 			"OSArch=amd64",
 			"Name=test-instance",
 		},
+		ControllerID: "test-controller",
+		TagNames:     LegacyTagNames,
 	}
 
-	output, err := GetRunnerSpecFromBootstrapParams(bootstrapParams, controllerID)
+	output, err := GetRunnerSpecFromBootstrapParams(bootstrapParams, controllerID, LegacyTagNames)
 	require.NoError(t, err)
 	assert.Equal(t, expectedOutput, *output)
 }
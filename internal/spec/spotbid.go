@@ -0,0 +1,86 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var spotBidRegexp = regexp.MustCompile(`^p(\d+(?:\.\d+)?)(?:([+-])(\d+(?:\.\d+)?)%)?$`)
+
+// SpotBid computes the bid of spot devices from the history of the spot price of
+// their plan, as a percentile of the prices with an optional markup.
+type SpotBid struct {
+	// Percentile is the percentile of the prices to bid, between 0 and 100.
+	Percentile float64
+	// Markup is the percentage added to the percentile, which may be negative.
+	Markup float64
+}
+
+// ParseSpotBid parses a spot bid expression, such as "p90" or "p90+10%".
+func ParseSpotBid(bid string) (SpotBid, error) {
+	matches := spotBidRegexp.FindStringSubmatch(bid)
+	if matches == nil {
+		return SpotBid{}, fmt.Errorf("invalid spot bid %q: expected a percentile with an optional markup, such as p90+10%%", bid)
+	}
+	percentile, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return SpotBid{}, fmt.Errorf("invalid spot bid %q: %w", bid, err)
+	}
+	if percentile <= 0 || percentile > 100 {
+		return SpotBid{}, fmt.Errorf("invalid spot bid %q: percentile must be greater than 0 and at most 100", bid)
+	}
+	ret := SpotBid{Percentile: percentile}
+	if matches[3] != "" {
+		ret.Markup, err = strconv.ParseFloat(matches[3], 64)
+		if err != nil {
+			return SpotBid{}, fmt.Errorf("invalid spot bid %q: %w", bid, err)
+		}
+		if matches[2] == "-" {
+			ret.Markup = -ret.Markup
+		}
+		if ret.Markup <= -100 {
+			return SpotBid{}, fmt.Errorf("invalid spot bid %q: markup must be greater than -100%%", bid)
+		}
+	}
+	return ret, nil
+}
+
+// Price returns the bid for the given price history, or false if the history is
+// empty. The percentile is computed with the nearest-rank method.
+func (b SpotBid) Price(history []float64) (float64, bool) {
+	if len(history) == 0 {
+		return 0, false
+	}
+	prices := append([]float64{}, history...)
+	sort.Float64s(prices)
+	rank := int(math.Ceil(b.Percentile / 100 * float64(len(prices))))
+	if rank < 1 {
+		rank = 1
+	}
+	return prices[rank-1] * (1 + b.Markup/100), true
+}
+
+func (b SpotBid) String() string {
+	ret := "p" + strconv.FormatFloat(b.Percentile, 'f', -1, 64)
+	if b.Markup != 0 {
+		ret += fmt.Sprintf("%+g%%", b.Markup)
+	}
+	return ret
+}
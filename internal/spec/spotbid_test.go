@@ -0,0 +1,73 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpotBid(t *testing.T) {
+	tests := []struct {
+		bid       string
+		expected  SpotBid
+		errString string
+	}{
+		{bid: "p90", expected: SpotBid{Percentile: 90}},
+		{bid: "p90+10%", expected: SpotBid{Percentile: 90, Markup: 10}},
+		{bid: "p50-5.5%", expected: SpotBid{Percentile: 50, Markup: -5.5}},
+		{bid: "p100", expected: SpotBid{Percentile: 100}},
+		{bid: "90+10%", errString: "expected a percentile with an optional markup"},
+		{bid: "p90+10", errString: "expected a percentile with an optional markup"},
+		{bid: "p0", errString: "percentile must be greater than 0 and at most 100"},
+		{bid: "p101", errString: "percentile must be greater than 0 and at most 100"},
+		{bid: "p90-100%", errString: "markup must be greater than -100%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bid, func(t *testing.T) {
+			bid, err := ParseSpotBid(tt.bid)
+			if tt.errString != "" {
+				require.Error(t, err)
+				assert.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, bid)
+			assert.Equal(t, tt.bid, bid.String())
+		})
+	}
+}
+
+func TestSpotBidPrice(t *testing.T) {
+	history := []float64{0.5, 0.1, 0.3, 0.2, 0.4, 0.6, 0.9, 0.7, 0.8, 1.0}
+
+	price, ok := SpotBid{Percentile: 90}.Price(history)
+	require.True(t, ok)
+	assert.Equal(t, 0.9, price)
+
+	price, ok = SpotBid{Percentile: 90, Markup: 10}.Price(history)
+	require.True(t, ok)
+	assert.InDelta(t, 0.99, price, 1e-9)
+
+	price, ok = SpotBid{Percentile: 1}.Price(history)
+	require.True(t, ok)
+	assert.Equal(t, 0.1, price)
+
+	_, ok = SpotBid{Percentile: 90}.Price(nil)
+	assert.False(t, ok)
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxTagPrefixLength is the maximum length of a tag prefix.
+const MaxTagPrefixLength = 128
+
+// TagNames holds the names of the tags the provider sets on devices.
+type TagNames struct {
+	// Prefix is the prefix shared by the tag names, which custom tags may not use.
+	Prefix       string
+	Name         string
+	OSType       string
+	OSArch       string
+	PoolID       string
+	ControllerID string
+	Recycle      string
+	Idle         string
+	Warm         string
+	HourlyPrice  string
+	Plan         string
+	Metro        string
+}
+
+// LegacyTagNames are the tag names used when no tag prefix is configured.
+var LegacyTagNames = TagNames{
+	Prefix:       "garm-",
+	Name:         "Name",
+	OSType:       "OSType",
+	OSArch:       "OSArch",
+	PoolID:       PoolIDTagName,
+	ControllerID: ControllerIDTagName,
+	Recycle:      RecycleTagName,
+	Idle:         IdleTagName,
+	Warm:         WarmTagName,
+	HourlyPrice:  HourlyPriceTagName,
+	Plan:         PlanTagName,
+	Metro:        MetroTagName,
+}
+
+// NewTagNames returns the tag names under the given prefix, or the legacy tag
+// names if the prefix is empty.
+func NewTagNames(prefix string) TagNames {
+	if prefix == "" {
+		return LegacyTagNames
+	}
+	return TagNames{
+		Prefix:       prefix,
+		Name:         prefix + "name",
+		OSType:       prefix + "os-type",
+		OSArch:       prefix + "os-arch",
+		PoolID:       prefix + "pool-id",
+		ControllerID: prefix + "controller-id",
+		Recycle:      prefix + "recycle",
+		Idle:         prefix + "idle",
+		Warm:         prefix + "warm",
+		HourlyPrice:  prefix + "hourly-price",
+		Plan:         prefix + "plan",
+		Metro:        prefix + "metro",
+	}
+}
+
+// names returns all the tag names.
+func (n TagNames) names() []string {
+	return []string{n.Name, n.OSType, n.OSArch, n.PoolID, n.ControllerID, n.Recycle, n.Idle, n.Warm, n.HourlyPrice, n.Plan, n.Metro}
+}
+
+// IsReserved returns true if a tag with the given name may only be set by the
+// provider.
+func (n TagNames) IsReserved(name string) bool {
+	if strings.HasPrefix(name, n.Prefix) {
+		return true
+	}
+	for _, reserved := range n.names() {
+		if name == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateTags returns the given tags, with the tags named after the from tag names
+// renamed after the to tag names. Other tags are kept as they are.
+func MigrateTags(tags []string, from, to TagNames) []string {
+	renames := map[string]string{}
+	toNames := to.names()
+	for i, name := range from.names() {
+		renames[name] = toNames[i]
+	}
+
+	ret := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		name, value, ok := strings.Cut(tag, "=")
+		if newName, found := renames[name]; ok && found {
+			tag = fmt.Sprintf("%s=%s", newName, value)
+		}
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+// ValidateTagPrefix checks that the names of the tags under the given prefix can be
+// set on devices, and that they do not clash with the legacy tag names.
+func ValidateTagPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if len(prefix) > MaxTagPrefixLength {
+		return fmt.Errorf("tag prefix is longer than %d characters", MaxTagPrefixLength)
+	}
+	for _, r := range prefix {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return fmt.Errorf("tag prefix %q must not contain spaces or non printable characters", prefix)
+		}
+	}
+	if strings.ContainsAny(prefix, "=,") {
+		return fmt.Errorf("tag prefix %q must not contain = or commas", prefix)
+	}
+	if LegacyTagNames.IsReserved(prefix) {
+		return fmt.Errorf("tag prefix %q clashes with the legacy tag names", prefix)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTagNames(t *testing.T) {
+	assert.Equal(t, LegacyTagNames, NewTagNames(""))
+
+	names := NewTagNames("garm.example.com/")
+	assert.Equal(t, "garm.example.com/name", names.Name)
+	assert.Equal(t, "garm.example.com/pool-id", names.PoolID)
+	assert.True(t, names.IsReserved("garm.example.com/team"))
+	assert.False(t, names.IsReserved("Name"))
+	assert.True(t, LegacyTagNames.IsReserved("Name"))
+	assert.True(t, LegacyTagNames.IsReserved("garm-team"))
+}
+
+func TestMigrateTags(t *testing.T) {
+	tags := []string{
+		"garm-pool-id=pool-1",
+		"garm-controller-id=controller-1",
+		"OSType=linux",
+		"OSArch=amd64",
+		"Name=runner-1",
+		"garm-hourly-price=0.7500",
+		"team=ci",
+		"ephemeral",
+	}
+	names := NewTagNames("garm.example.com/")
+
+	migrated := MigrateTags(tags, LegacyTagNames, names)
+	assert.Equal(t, []string{
+		"garm.example.com/pool-id=pool-1",
+		"garm.example.com/controller-id=controller-1",
+		"garm.example.com/os-type=linux",
+		"garm.example.com/os-arch=amd64",
+		"garm.example.com/name=runner-1",
+		"garm.example.com/hourly-price=0.7500",
+		"team=ci",
+		"ephemeral",
+	}, migrated)
+	assert.Equal(t, migrated, MigrateTags(migrated, LegacyTagNames, names))
+	assert.Equal(t, tags, MigrateTags(migrated, names, LegacyTagNames))
+}
+
+func TestValidateTagPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		errString string
+	}{
+		{
+			name:   "no prefix",
+			prefix: "",
+		},
+		{
+			name:   "domain prefix",
+			prefix: "garm.example.com/",
+		},
+		{
+			name:      "too long",
+			prefix:    strings.Repeat("a", MaxTagPrefixLength+1),
+			errString: "tag prefix is longer than 128 characters",
+		},
+		{
+			name:      "spaces",
+			prefix:    "garm ",
+			errString: "must not contain spaces or non printable characters",
+		},
+		{
+			name:      "equal sign",
+			prefix:    "garm=",
+			errString: "must not contain = or commas",
+		},
+		{
+			name:      "legacy prefix",
+			prefix:    "garm-ci-",
+			errString: "clashes with the legacy tag names",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTagPrefix(tt.prefix)
+			if tt.errString != "" {
+				assert.ErrorContains(t, err, tt.errString)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const (
+	// MaxTagLength is the maximum length of a custom tag, once rendered.
+	MaxTagLength = 255
+	// MaxDescriptionLength is the maximum length of a device description, once
+	// rendered.
+	MaxDescriptionLength = 255
+)
+
+// TemplateData holds the variables available in the templates of custom tags and
+// descriptions.
+type TemplateData struct {
+	PoolID       string
+	ControllerID string
+	RunnerName   string
+	RepoURL      string
+	Flavor       string
+	Image        string
+	Labels       []string
+}
+
+// NewTemplateData returns the template variables of a runner.
+func NewTemplateData(data params.BootstrapInstance, controllerID string) TemplateData {
+	return TemplateData{
+		PoolID:       data.PoolID,
+		ControllerID: controllerID,
+		RunnerName:   data.Name,
+		RepoURL:      data.RepoURL,
+		Flavor:       data.Flavor,
+		Image:        data.Image,
+		Labels:       data.Labels,
+	}
+}
+
+// sampleTemplateData is used to validate templates before any runner exists.
+var sampleTemplateData = TemplateData{
+	PoolID:       "00000000-0000-0000-0000-000000000000",
+	ControllerID: "00000000-0000-0000-0000-000000000000",
+	RunnerName:   "garm-runner",
+	RepoURL:      "https://github.com/org/repo",
+	Flavor:       "c3.small.x86",
+	Image:        "ubuntu_22_04",
+	Labels:       []string{"self-hosted", "linux"},
+}
+
+var templateFuncs = template.FuncMap{
+	"join": func(elems []string, sep string) string {
+		return strings.Join(elems, sep)
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+func renderTemplate(text string, data TemplateData) (string, error) {
+	tpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %w", text, err)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %w", text, err)
+	}
+	return buf.String(), nil
+}
+
+// tagName returns the name of a tag in the name=value form, or the whole tag.
+func tagName(tag string) string {
+	name, _, _ := strings.Cut(tag, "=")
+	return name
+}
+
+// ValidateTag checks that a custom tag, once rendered, can be set on a device,
+// and does not override the tags set by the provider under the given names.
+func ValidateTag(tag string, names TagNames) error {
+	if strings.TrimSpace(tag) == "" {
+		return fmt.Errorf("tag must not be empty")
+	}
+	if len(tag) > MaxTagLength {
+		return fmt.Errorf("tag %q is longer than %d characters", tag, MaxTagLength)
+	}
+	if strings.TrimSpace(tag) != tag {
+		return fmt.Errorf("tag %q must not start or end with spaces", tag)
+	}
+	if strings.ContainsRune(tag, ',') {
+		return fmt.Errorf("tag %q must not contain commas", tag)
+	}
+	for _, r := range tag {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("tag %q must not contain non printable characters", tag)
+		}
+	}
+
+	if names.IsReserved(tagName(tag)) {
+		return fmt.Errorf("tag %q uses a name reserved for the tags of the provider", tag)
+	}
+	return nil
+}
+
+// MergeTags returns the default custom tags, with the custom tags of a pool added.
+// Pool tags replace the default tags with the same name.
+func MergeTags(defaults, pool []string) []string {
+	names := map[string]bool{}
+	for _, tag := range pool {
+		names[tagName(tag)] = true
+	}
+	ret := []string{}
+	for _, tag := range defaults {
+		if !names[tagName(tag)] {
+			ret = append(ret, tag)
+		}
+	}
+	return append(ret, pool...)
+}
+
+// RenderTags renders the templates of custom tags and of a description, and
+// validates the results against the given tag names of the provider.
+func RenderTags(tags []string, description string, data TemplateData, names TagNames) ([]string, string, error) {
+	ret := make([]string, 0, len(tags))
+	for _, text := range tags {
+		tag, err := renderTemplate(text, data)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := ValidateTag(tag, names); err != nil {
+			return nil, "", err
+		}
+		ret = append(ret, tag)
+	}
+
+	rendered, err := renderTemplate(description, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(rendered) > MaxDescriptionLength {
+		return nil, "", fmt.Errorf("description is longer than %d characters", MaxDescriptionLength)
+	}
+	return ret, rendered, nil
+}
+
+// ValidateTemplates checks that the templates of custom tags and of a description
+// render to valid tags and descriptions.
+func ValidateTemplates(tags []string, description string, names TagNames) error {
+	_, _, err := RenderTags(tags, description, sampleTemplateData, names)
+	return err
+}
+
+// ApplyTemplates renders the custom tags and description of the runner, merged
+// with the given defaults, and adds them to the spec.
+func (r *RunnerSpec) ApplyTemplates(defaultTags []string, defaultDescription string) error {
+	description := defaultDescription
+	if r.DescriptionTemplate != nil {
+		description = *r.DescriptionTemplate
+	}
+	tags, description, err := RenderTags(MergeTags(defaultTags, r.CustomTags), description, NewTemplateData(r.BootstrapParams, r.ControllerID), r.TagNames)
+	if err != nil {
+		return err
+	}
+	r.Tags = append(r.Tags, tags...)
+	r.Description = description
+	return nil
+}
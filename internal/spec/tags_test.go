@@ -0,0 +1,195 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTag(t *testing.T) {
+	tests := []struct {
+		name      string
+		tag       string
+		names     TagNames
+		errString string
+	}{
+		{
+			name: "name and value",
+			tag:  "team=ci",
+		},
+		{
+			name: "plain tag",
+			tag:  "ci",
+		},
+		{
+			name:      "empty",
+			tag:       " ",
+			errString: "tag must not be empty",
+		},
+		{
+			name:      "too long",
+			tag:       strings.Repeat("a", MaxTagLength+1),
+			errString: "is longer than 255 characters",
+		},
+		{
+			name:      "surrounding spaces",
+			tag:       " team=ci",
+			errString: "must not start or end with spaces",
+		},
+		{
+			name:      "comma",
+			tag:       "labels=linux,x64",
+			errString: "must not contain commas",
+		},
+		{
+			name:      "non printable",
+			tag:       "team=ci\n",
+			errString: "must not start or end with spaces",
+		},
+		{
+			name:      "garm prefix",
+			tag:       "garm-pool-id=pool",
+			errString: "uses a name reserved for the tags of the provider",
+		},
+		{
+			name:      "reserved name",
+			tag:       "OSType=linux",
+			errString: "uses a name reserved for the tags of the provider",
+		},
+		{
+			name:  "legacy name with a tag prefix",
+			tag:   "Name=runner",
+			names: NewTagNames("garm.example.com/"),
+		},
+		{
+			name:      "tag prefix",
+			tag:       "garm.example.com/team=ci",
+			names:     NewTagNames("garm.example.com/"),
+			errString: "uses a name reserved for the tags of the provider",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := tt.names
+			if names == (TagNames{}) {
+				names = LegacyTagNames
+			}
+			err := ValidateTag(tt.tag, names)
+			if tt.errString != "" {
+				assert.ErrorContains(t, err, tt.errString)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	assert.Equal(t, []string{"cost-center=42", "team=ci", "ephemeral"},
+		MergeTags([]string{"team=infra", "cost-center=42"}, []string{"team=ci", "ephemeral"}))
+	assert.Equal(t, []string{"team=infra"}, MergeTags([]string{"team=infra"}, nil))
+	assert.Equal(t, []string{}, MergeTags(nil, nil))
+}
+
+func TestRenderTags(t *testing.T) {
+	data := TemplateData{
+		PoolID:       "pool-1",
+		ControllerID: "controller-1",
+		RunnerName:   "runner-1",
+		RepoURL:      "https://github.com/org/repo",
+		Flavor:       "c3.small.x86",
+		Image:        "ubuntu_22_04",
+		Labels:       []string{"linux", "x64"},
+	}
+
+	tests := []struct {
+		name                string
+		tags                []string
+		description         string
+		expectedTags        []string
+		expectedDescription string
+		errString           string
+	}{
+		{
+			name:                "static tags",
+			tags:                []string{"team=ci"},
+			expectedTags:        []string{"team=ci"},
+			expectedDescription: "",
+		},
+		{
+			name:                "templated tags and description",
+			tags:                []string{"repo={{ .RepoURL }}", "plan={{ upper .Flavor }}", "labels={{ join .Labels \"+\" }}"},
+			description:         "GARM runner {{ .RunnerName }} of pool {{ .PoolID }}",
+			expectedTags:        []string{"repo=https://github.com/org/repo", "plan=C3.SMALL.X86", "labels=linux+x64"},
+			expectedDescription: "GARM runner runner-1 of pool pool-1",
+		},
+		{
+			name:      "unknown variable",
+			tags:      []string{"repo={{ .Repository }}"},
+			errString: "failed to render template",
+		},
+		{
+			name:      "invalid template",
+			tags:      []string{"repo={{ .RepoURL"},
+			errString: "invalid template",
+		},
+		{
+			name:      "rendered tag is invalid",
+			tags:      []string{"labels={{ join .Labels \",\" }}"},
+			errString: "must not contain commas",
+		},
+		{
+			name:        "description too long",
+			description: strings.Repeat("{{ .RunnerName }}", 50),
+			errString:   "description is longer than 255 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, description, err := RenderTags(tt.tags, tt.description, data, LegacyTagNames)
+			if tt.errString != "" {
+				require.Error(t, err)
+				assert.ErrorContains(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedTags, tags)
+			assert.Equal(t, tt.expectedDescription, description)
+		})
+	}
+}
+
+func TestApplyTemplates(t *testing.T) {
+	spec := RunnerSpec{
+		Tags:                []string{"Name=runner-1"},
+		CustomTags:          []string{"team=ci"},
+		DescriptionTemplate: Ptr("runner {{ .RunnerName }} of {{ .ControllerID }}"),
+		ControllerID:        "controller-1",
+		TagNames:            LegacyTagNames,
+		BootstrapParams:     params.BootstrapInstance{Name: "runner-1", PoolID: "pool-1"},
+	}
+
+	err := spec.ApplyTemplates([]string{"team=infra", "pool={{ .PoolID }}"}, "default description")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Name=runner-1", "pool=pool-1", "team=ci"}, spec.Tags)
+	assert.Equal(t, "runner runner-1 of controller-1", spec.Description)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// NewHTTPExporter returns an exporter sending spans to an OTLP/HTTP receiver.
+// endpoint is the base URL of the receiver, and spans are sent to its /v1/traces
+// path. The headers are added to every request, for example to authenticate.
+func NewHTTPExporter(ctx context.Context, endpoint string, headers map[string]string) (sdktrace.SpanExporter, error) {
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return exporter, nil
+}
+
+// NewFileExporter returns an exporter appending spans to the file at path, one
+// JSON object per line, as written by the OpenTelemetry stdout exporter.
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(&fileWriter{path: path}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file exporter: %w", err)
+	}
+	return exporter, nil
+}
+
+// fileWriter appends every write to a file. The exporter writes each span at
+// once, so locking the file around a write keeps the spans of concurrent provider
+// processes from interleaving.
+type fileWriter struct {
+	path string
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	unlock, err := filelock.Lock(w.path + ".lock")
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open traces file: %w", err)
+	}
+	defer file.Close()
+	n, err := file.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("failed to write traces file: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package tracing records OpenTelemetry traces of the provider commands and of the
+// requests made to the Equinix Metal API. It wraps the OpenTelemetry SDK so that a
+// nil Tracer disables tracing. Spans are batched until Flush is called.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer spans are recorded with.
+const instrumentationName = "github.com/cloudbase/garm-provider-equinix"
+
+// Attribute is a span or resource attribute.
+type Attribute = attribute.KeyValue
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return attribute.String(key, value)
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute {
+	return attribute.Int(key, value)
+}
+
+// SpanKind is the OpenTelemetry kind of a span.
+type SpanKind = trace.SpanKind
+
+const (
+	SpanKindInternal = trace.SpanKindInternal
+	SpanKindClient   = trace.SpanKindClient
+)
+
+// Span is an operation in a trace. All methods may be called on a nil span, which
+// is what Start returns when tracing is disabled.
+type Span struct {
+	span trace.Span
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attributes...)
+}
+
+// End ends the span. A non nil error marks the span as failed.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.SetStatus(codes.Error, err.Error())
+	} else {
+		s.span.SetStatus(codes.Ok, "")
+	}
+	s.span.End()
+}
+
+// Tracer creates spans and exports them once flushed.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewTracer returns a tracer exporting spans through exporter. The resource
+// attributes describe the process that recorded the spans.
+func NewTracer(exporter sdktrace.SpanExporter, attributes ...Attribute) *Tracer {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attributes...)),
+	)
+	return &Tracer{
+		provider: provider,
+		tracer:   provider.Tracer(instrumentationName),
+	}
+}
+
+// Start starts a span, as a child of the span in ctx, if any. The returned context
+// holds the new span. A nil tracer disables tracing: ctx is returned as is, along
+// with a nil span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+	return ctx, &Span{span: span}
+}
+
+// Flush exports the spans ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	if err := t.provider.ForceFlush(ctx); err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	return nil
+}
+
+// ContextWithTraceparent returns a context holding the remote parent described by
+// a W3C traceparent header, so that the spans started from it join the trace of
+// the caller. The context is returned as is if traceparent is empty or invalid.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": strings.TrimSpace(traceparent)})
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStartDisabled(t *testing.T) {
+	var tracer *Tracer
+	ctx := context.Background()
+	spanCtx, span := tracer.Start(ctx, "CreateInstance", SpanKindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, spanCtx)
+	// Spans returned when tracing is disabled can be used as any other.
+	span.SetAttributes(String("key", "value"))
+	span.End(nil)
+	assert.NoError(t, tracer.Flush(ctx))
+}
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewTracer(exporter, String("service.name", "test"))
+
+	ctx := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.Start(ctx, "CreateInstance", SpanKindInternal, String("garm.pool_id", "pool"))
+	_, child := tracer.Start(ctx, "waitDeviceActive.poll", SpanKindInternal)
+	child.SetAttributes(Int("equinix.provisioning_percentage", 42))
+	child.End(errors.New("device failed"))
+	root.End(nil)
+	// Ending a span twice has no effect.
+	root.End(errors.New("ignored"))
+
+	// Spans are only exported once flushed.
+	assert.Empty(t, exporter.GetSpans())
+	require.NoError(t, tracer.Flush(context.Background()))
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	poll, create := spans[0], spans[1]
+	assert.Equal(t, []Attribute{String("service.name", "test")}, create.Resource.Attributes())
+	assert.Equal(t, "CreateInstance", create.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", create.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", create.Parent.SpanID().String())
+	assert.Equal(t, codes.Ok, create.Status.Code)
+	assert.Equal(t, []Attribute{String("garm.pool_id", "pool")}, create.Attributes)
+
+	assert.Equal(t, create.SpanContext.TraceID(), poll.SpanContext.TraceID())
+	assert.Equal(t, create.SpanContext.SpanID(), poll.Parent.SpanID())
+	assert.Equal(t, codes.Error, poll.Status.Code)
+	assert.Equal(t, "device failed", poll.Status.Description)
+	assert.Equal(t, []Attribute{Int("equinix.provisioning_percentage", 42)}, poll.Attributes)
+
+	// Exported spans are not exported again.
+	exporter.Reset()
+	require.NoError(t, tracer.Flush(context.Background()))
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func TestContextWithTraceparent(t *testing.T) {
+	ctx := context.Background()
+	for _, traceparent := range []string{
+		"",
+		"garbage",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929b0e0e473g-00f067aa0ba902b7-01",
+	} {
+		assert.Equal(t, ctx, ContextWithTraceparent(ctx, traceparent), traceparent)
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	var path, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	exporter, err := NewHTTPExporter(context.Background(), server.URL+"/", map[string]string{"Authorization": "Bearer token"})
+	require.NoError(t, err)
+	tracer := NewTracer(exporter)
+	_, span := tracer.Start(context.Background(), "CreateInstance", SpanKindInternal)
+	span.End(nil)
+	require.NoError(t, tracer.Flush(context.Background()))
+	assert.Equal(t, "/v1/traces", path)
+	assert.Equal(t, "Bearer token", auth)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	exporter, err = NewHTTPExporter(context.Background(), failing.URL, nil)
+	require.NoError(t, err)
+	tracer = NewTracer(exporter)
+	_, span = tracer.Start(context.Background(), "CreateInstance", SpanKindInternal)
+	span.End(nil)
+	assert.ErrorContains(t, tracer.Flush(context.Background()), "failed to export spans")
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	exporter, err := NewFileExporter(path)
+	require.NoError(t, err)
+	tracer := NewTracer(exporter)
+	for _, name := range []string{"CreateInstance", "GetInstance"} {
+		_, span := tracer.Start(context.Background(), name, SpanKindInternal)
+		span.End(nil)
+		require.NoError(t, tracer.Flush(context.Background()))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	for i, name := range []string{"CreateInstance", "GetInstance"} {
+		var span struct{ Name string }
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &span))
+		assert.Equal(t, name, span.Name)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "GetInstance", SpanKindInternal)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/metal/v1/devices/76e33e9e-6155-472e-ae76-37b5401f888f", nil)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: NewTransport(tracer, nil)}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	root.End(nil)
+
+	require.NoError(t, tracer.Flush(context.Background()))
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET /devices/{id}", spans[0].Name)
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/metrics"
+)
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+// NewTransport returns an http.RoundTripper recording a client span with tracer
+// for every request made through base, as a child of the span in the request
+// context. The http.DefaultTransport is used if base is nil.
+func NewTransport(tracer *Tracer, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{tracer: tracer, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.tracer == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	_, span := t.tracer.Start(req.Context(), metrics.Operation(req), SpanKindClient,
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Hostname()),
+		String("url.path", req.URL.Path),
+	)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return resp, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.End(fmt.Errorf("%s", resp.Status))
+	} else {
+		span.End(nil)
+	}
+	return resp, err
+}
@@ -16,15 +16,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/cloudbase/garm-provider-common/execution"
 	commonExecution "github.com/cloudbase/garm-provider-common/execution/common"
 
+	"github.com/cloudbase/garm-provider-equinix/internal/cli"
+	"github.com/cloudbase/garm-provider-equinix/internal/command"
+	"github.com/cloudbase/garm-provider-equinix/internal/daemon"
 	"github.com/cloudbase/garm-provider-equinix/provider"
 )
 
@@ -33,12 +37,76 @@ var signals = []os.Signal{
 	syscall.SIGTERM,
 }
 
+// readStdin reads the data GARM passes on the standard input of a command.
+func readStdin(garmCommand string) ([]byte, error) {
+	if !command.NeedsStdin(garmCommand) {
+		return nil, nil
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return nil, fmt.Errorf("%s requires data passed into stdin", garmCommand)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return data, nil
+}
+
+// forward sends the command to the provider daemon, if the config enables it. An
+// error wrapping daemon.ErrUnavailable is returned if the command must be run by
+// this process instead.
+func forward(ctx context.Context, stdin []byte) (daemon.Response, error) {
+	socket, err := daemon.Socket(os.Getenv("GARM_PROVIDER_CONFIG_FILE"))
+	if err != nil {
+		return daemon.Response{}, err
+	}
+	return daemon.Forward(ctx, socket, daemon.NewRequest(os.Getenv, stdin))
+}
+
 func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), signals...)
 	defer stop()
 
-	executionEnv, err := execution.GetEnvironment()
+	if len(os.Args) > 1 && os.Args[1] == cli.AdminCommand {
+		if err := cli.RunAdmin(ctx, os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == cli.ServeCommand {
+		if err := cli.RunServe(ctx, os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	garmCommand := os.Getenv("GARM_COMMAND")
+	stdin, err := readStdin(garmCommand)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resp, err := forward(ctx, stdin)
+	if err == nil {
+		if resp.Error != "" {
+			fmt.Fprintf(os.Stderr, "failed to run command: %s", resp.Error)
+			os.Exit(resp.ExitCode)
+		}
+		if len(resp.Stdout) > 0 {
+			fmt.Fprint(os.Stdout, resp.Stdout)
+		}
+		return
+	}
+	if !errors.Is(err, daemon.ErrUnavailable) {
+		fmt.Fprintf(os.Stderr, "failed to run command: %s", err)
+		os.Exit(1)
+	}
+
+	executionEnv, err := command.Environment(os.Getenv, stdin)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -48,7 +116,10 @@ func main() {
 		log.Fatal(err)
 	}
 
-	result, err := executionEnv.Run(ctx, prov)
+	result, err := command.Run(ctx, executionEnv, os.Getenv, prov)
+	if flushErr := command.Flush(context.Background(), prov); flushErr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", flushErr)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to run command: %s", err)
 		os.Exit(commonExecution.ResolveErrorToExitCode(err))
@@ -0,0 +1,239 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	"github.com/google/uuid"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+var _ Admin = &equinixProvider{}
+
+// Admin exposes the operations used by the operator CLI to inspect and clean up
+// devices managed by GARM, outside of the GARM external provider interface.
+type Admin interface {
+	// ListDevices returns the devices created by the controller. If poolID is not
+	// empty, only devices belonging to that pool are returned.
+	ListDevices(ctx context.Context, poolID string) ([]DeviceInfo, error)
+	// InspectDevice returns details about one device, identified either by the
+	// device ID or by the runner name.
+	InspectDevice(ctx context.Context, instance string) (DeviceInfo, error)
+	// DeleteDevice deletes one device by ID.
+	DeleteDevice(ctx context.Context, deviceID string) error
+	// FindOrphans classifies the devices created by the controller against the
+	// configured orphan rules, merged with the given overrides, and returns the
+	// devices considered orphans.
+	FindOrphans(ctx context.Context, overrides config.OrphansConfig) ([]Orphan, error)
+	// ReapOrphans deletes the given orphans, as returned by FindOrphans.
+	ReapOrphans(ctx context.Context, orphans []Orphan) ([]Orphan, error)
+	// RefillWarmPools creates and deletes devices so that the configured number of
+	// devices is on standby for each warm pool. If dryRun is true, the changes are
+	// only reported.
+	RefillWarmPools(ctx context.Context, dryRun bool) ([]WarmPoolStatus, error)
+	// PruneIdleDevices deletes the devices parked for longer than the configured
+	// maximum idle time. If dryRun is true, the devices are only returned.
+	PruneIdleDevices(ctx context.Context, dryRun bool) ([]DeviceInfo, error)
+	// PoolCosts returns the cost of the devices of every pool, billed between since
+	// and until.
+	PoolCosts(ctx context.Context, since, until time.Time) ([]PoolCost, error)
+	// FindTagMigrations returns the migrations renaming the tags of the devices
+	// created by the controller from the legacy tag names to the tag names under
+	// the configured tag prefix.
+	FindTagMigrations(ctx context.Context) ([]TagMigration, error)
+	// MigrateTags applies the given migrations, as returned by FindTagMigrations.
+	MigrateTags(ctx context.Context, migrations []TagMigration) ([]TagMigration, error)
+}
+
+// DeviceInfo holds the details of a device managed by GARM.
+type DeviceInfo struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name,omitempty"`
+	Hostname     string    `json:"hostname,omitempty"`
+	State        string    `json:"state"`
+	PoolID       string    `json:"pool_id,omitempty"`
+	ControllerID string    `json:"controller_id,omitempty"`
+	Plan         string    `json:"plan,omitempty"`
+	Metro        string    `json:"metro,omitempty"`
+	Addresses    []string  `json:"addresses,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	CreatedAt    time.Time `json:"created_at,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+	// ClaimedAt is the time the device was assigned to its runner, if known.
+	ClaimedAt     time.Time `json:"claimed_at,omitempty"`
+	ProviderFault string    `json:"provider_fault,omitempty"`
+	// Idle is set on devices parked for reuse by their pool.
+	Idle bool `json:"idle,omitempty"`
+	// Warm is set on devices pre-provisioned for their pool.
+	Warm bool `json:"warm,omitempty"`
+	// HourlyPrice is the estimated hourly price of the device in USD, recorded
+	// when it was created for its runner.
+	HourlyPrice float64 `json:"hourly_price,omitempty"`
+	// AccumulatedCost is the estimated cost of the device since it was created,
+	// in USD.
+	AccumulatedCost float64 `json:"accumulated_cost,omitempty"`
+}
+
+// Age returns the amount of time elapsed since the device was created.
+func (d DeviceInfo) Age(now time.Time) time.Duration {
+	if d.CreatedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(d.CreatedAt)
+}
+
+// RunnerAge returns the amount of time elapsed since the device was assigned to
+// its runner. Devices without a claim time were created for their runner.
+func (d DeviceInfo) RunnerAge(now time.Time) time.Duration {
+	if d.ClaimedAt.IsZero() {
+		return d.Age(now)
+	}
+	return now.Sub(d.ClaimedAt)
+}
+
+// NewAdmin returns an Admin for the devices created by the given controller. If
+// controllerID is empty, devices created by any GARM controller are managed.
+func NewAdmin(configPath, controllerID string) (Admin, error) {
+	return newEquinixProvider(configPath, controllerID)
+}
+
+func equinixToDeviceInfo(device metal.Device, names spec.TagNames) DeviceInfo {
+	meta := deviceMetadataOf(device, names)
+	info := DeviceInfo{
+		ID:           device.GetId(),
+		Name:         meta.RunnerName,
+		Hostname:     device.GetHostname(),
+		State:        string(device.GetState()),
+		PoolID:       meta.PoolID,
+		ControllerID: meta.ControllerID,
+		Plan:         device.Plan.GetSlug(),
+		Metro:        device.Metro.GetCode(),
+		Tags:         device.GetTags(),
+		CreatedAt:    device.GetCreatedAt(),
+		UpdatedAt:    device.GetUpdatedAt(),
+		Idle:         isIdleDevice(device, names),
+		Warm:         isWarmDevice(device, names),
+		HourlyPrice:  deviceHourlyPrice(device, names),
+	}
+	if meta.ClaimedAt != nil {
+		info.ClaimedAt = *meta.ClaimedAt
+	}
+	info.AccumulatedCost = accumulatedCost(info.HourlyPrice, info.CreatedAt, time.Now())
+	for _, address := range device.GetIpAddresses() {
+		info.Addresses = append(info.Addresses, address.GetAddress())
+	}
+	if device.GetState() == metal.DEVICESTATE_FAILED {
+		info.ProviderFault = summarizeEvents(device.GetProvisioningEvents())
+	}
+	return info
+}
+
+// isManagedDevice returns true if the device was created by the controller of
+// this provider, or by any GARM controller if no controller ID is set.
+func (a *equinixProvider) isManagedDevice(device metal.Device) bool {
+	controllerID := deviceMetadataOf(device, a.cfg.GetTagNames()).ControllerID
+	if controllerID == "" {
+		return false
+	}
+	return a.controllerID == "" || controllerID == a.controllerID
+}
+
+// ListDevices returns the devices created by the controller.
+func (a *equinixProvider) ListDevices(ctx context.Context, poolID string) ([]DeviceInfo, error) {
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	ret := []DeviceInfo{}
+	for _, device := range devices {
+		if !a.isManagedDevice(device) {
+			continue
+		}
+		info := equinixToDeviceInfo(device, a.cfg.GetTagNames())
+		if poolID != "" && info.PoolID != poolID {
+			continue
+		}
+		ret = append(ret, info)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].CreatedAt.Before(ret[j].CreatedAt)
+	})
+	return ret, nil
+}
+
+// InspectDevice returns details about one device.
+func (a *equinixProvider) InspectDevice(ctx context.Context, instance string) (DeviceInfo, error) {
+	if deviceID, _, ok := parseProviderID(instance); ok {
+		instance = deviceID
+	} else {
+		devices, err := a.findInstancesByName(ctx, instance)
+		if err != nil {
+			return DeviceInfo{}, fmt.Errorf("failed to find instances by name: %w", err)
+		}
+		if len(devices) == 0 {
+			return DeviceInfo{}, fmt.Errorf("device %s not found: %w", instance, gErrors.ErrNotFound)
+		}
+		if len(devices) > 1 {
+			return DeviceInfo{}, fmt.Errorf("found %d devices named %s, please use the device ID", len(devices), instance)
+		}
+		instance = devices[0].GetId()
+	}
+
+	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, instance))
+	if err != nil {
+		if isNotFoundResponse(resp) {
+			return DeviceInfo{}, fmt.Errorf("device %s not found: %w", instance, gErrors.ErrNotFound)
+		}
+		return DeviceInfo{}, fmt.Errorf("failed to find device: %w", err)
+	}
+	if device == nil || !a.isManagedDevice(*device) {
+		return DeviceInfo{}, fmt.Errorf("device %s not found: %w", instance, gErrors.ErrNotFound)
+	}
+
+	info := equinixToDeviceInfo(*device, a.cfg.GetTagNames())
+	if device.GetState() == metal.DEVICESTATE_FAILED {
+		info.ProviderFault = a.deviceFault(ctx, *device)
+	}
+	return info, nil
+}
+
+// DeleteDevice deletes one device created by the controller.
+func (a *equinixProvider) DeleteDevice(ctx context.Context, deviceID string) error {
+	if _, err := uuid.Parse(deviceID); err != nil {
+		return fmt.Errorf("invalid device ID %s: %w", deviceID, err)
+	}
+
+	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+	if err != nil {
+		if isNotFoundResponse(resp) {
+			return nil
+		}
+		return fmt.Errorf("failed to find device: %w", err)
+	}
+	if device == nil || !a.isManagedDevice(*device) {
+		return fmt.Errorf("device %s is not managed by this controller", deviceID)
+	}
+	return a.deleteOneInstance(ctx, deviceID)
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminTestDevices(now time.Time) []metal.Device {
+	return []metal.Device{
+		{
+			Id:       spec.Ptr("76e33e9e-6155-472e-ae76-37b5401f888f"),
+			Hostname: spec.Ptr("runner-1"),
+			Tags: []string{
+				"Name=runner-1",
+				"garm-pool-id=pool-1",
+				"garm-controller-id=mock-controller-id",
+			},
+			IpAddresses: []metal.IPAssignment{
+				{
+					Address: spec.Ptr("10.10.0.4"),
+				},
+			},
+			Plan:      &metal.Plan{Slug: spec.Ptr("c3.small.x86")},
+			Metro:     &metal.DeviceMetro{Code: spec.Ptr("am")},
+			State:     spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			CreatedAt: spec.Ptr(now.Add(-time.Hour)),
+		},
+		{
+			Id: spec.Ptr("a1b2c3d4-6155-472e-ae76-37b5401f888f"),
+			Tags: []string{
+				"Name=runner-2",
+				"garm-pool-id=pool-2",
+				"garm-controller-id=mock-controller-id",
+			},
+			State:     spec.Ptr(metal.DEVICESTATE_FAILED),
+			CreatedAt: spec.Ptr(now.Add(-2 * time.Hour)),
+		},
+		{
+			Id: spec.Ptr("e5f6a7b8-6155-472e-ae76-37b5401f888f"),
+			Tags: []string{
+				"Name=runner-3",
+				"garm-pool-id=pool-1",
+				"garm-controller-id=other-controller-id",
+			},
+			State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+		},
+		{
+			Id:    spec.Ptr("c9d0e1f2-6155-472e-ae76-37b5401f888f"),
+			Tags:  []string{"Name=not-garm"},
+			State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+		},
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	devices := adminTestDevices(now)
+	cli := new(MockClient)
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	tests := []struct {
+		name         string
+		controllerID string
+		poolID       string
+		expectedIDs  []string
+	}{
+		{
+			name:         "all devices of the controller",
+			controllerID: "mock-controller-id",
+			expectedIDs:  []string{devices[1].GetId(), devices[0].GetId()},
+		},
+		{
+			name:         "devices of one pool",
+			controllerID: "mock-controller-id",
+			poolID:       "pool-1",
+			expectedIDs:  []string{devices[0].GetId()},
+		},
+		{
+			name:        "devices of any controller",
+			expectedIDs: []string{devices[2].GetId(), devices[1].GetId(), devices[0].GetId()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &equinixProvider{
+				cli:          cli,
+				cfg:          &config.Config{ProjectID: "project"},
+				controllerID: tt.controllerID,
+			}
+			output, err := a.ListDevices(ctx, tt.poolID)
+			require.NoError(t, err)
+			ids := []string{}
+			for _, device := range output {
+				ids = append(ids, device.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestEquinixToDeviceInfo(t *testing.T) {
+	now := time.Now()
+	device := adminTestDevices(now)[0]
+
+	info := equinixToDeviceInfo(device, spec.LegacyTagNames)
+	assert.Equal(t, DeviceInfo{
+		ID:           device.GetId(),
+		Name:         "runner-1",
+		Hostname:     "runner-1",
+		State:        "active",
+		PoolID:       "pool-1",
+		ControllerID: "mock-controller-id",
+		Plan:         "c3.small.x86",
+		Metro:        "am",
+		Addresses:    []string{"10.10.0.4"},
+		Tags:         device.Tags,
+		CreatedAt:    now.Add(-time.Hour),
+	}, info)
+	assert.Equal(t, time.Hour, info.Age(now))
+}
+
+func TestInspectDevice(t *testing.T) {
+	ctx := context.Background()
+	devices := adminTestDevices(time.Now())
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:          cli,
+		events:       cli,
+		cfg:          &config.Config{ProjectID: "project"},
+		controllerID: "mock-controller-id",
+	}
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("FindDeviceById", ctx, devices[1].GetId()).Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	cli.On("FindDeviceEvents", ctx, devices[1].GetId()).Return(metal.ApiFindDeviceEventsRequest{
+		ApiService: &metal.EventsApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &devices[1], &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	DefaultExecuteFindDeviceEvents = func(r metal.ApiFindDeviceEventsRequest) (*metal.EventList, *http.Response, error) {
+		return &metal.EventList{
+			Events: []metal.Event{
+				{
+					Type:         spec.Ptr("provisioning.failed"),
+					Interpolated: spec.Ptr("hardware failure during provisioning"),
+				},
+			},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	info, err := a.InspectDevice(ctx, "runner-2")
+	require.NoError(t, err)
+	assert.Equal(t, devices[1].GetId(), info.ID)
+	assert.Equal(t, "provisioning.failed: hardware failure during provisioning", info.ProviderFault)
+
+	_, err = a.InspectDevice(ctx, "runner-3")
+	assert.ErrorContains(t, err, "device runner-3 not found")
+}
+
+func TestDeleteDeviceNotManaged(t *testing.T) {
+	ctx := context.Background()
+	devices := adminTestDevices(time.Now())
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:          cli,
+		cfg:          &config.Config{ProjectID: "project"},
+		controllerID: "mock-controller-id",
+	}
+	cli.On("FindDeviceById", ctx, devices[2].GetId()).Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &devices[2], &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	err := a.DeleteDevice(ctx, devices[2].GetId())
+	assert.ErrorContains(t, err, "is not managed by this controller")
+	cli.AssertNotCalled(t, "DeleteDevice", ctx, devices[2].GetId())
+
+	err = a.DeleteDevice(ctx, "runner-1")
+	assert.ErrorContains(t, err, "invalid device ID")
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// consoleLogDirName returns the directory holding the console logs of a runner.
+// Devices without a runner name share the "unknown" directory.
+func consoleLogDirName(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "unknown"
+	}
+	return name
+}
+
+// consoleLogPath returns the path of the console log report of a device.
+func (a *equinixProvider) consoleLogPath(device metal.Device) string {
+	name := deviceMetadataOf(device, a.cfg.GetTagNames()).RunnerName
+	return filepath.Join(a.cfg.ConsoleLogDir, consoleLogDirName(name), device.GetId()+".txt")
+}
+
+// saveScreenshot captures a screenshot of the device console through its BMC, and
+// saves it to the given path.
+func (a *equinixProvider) saveScreenshot(ctx context.Context, deviceID, path string) error {
+	file, _, err := DefaultExecuteCaptureScreenshot(a.console.CaptureScreenshot(ctx, deviceID))
+	if err != nil {
+		return fmt.Errorf("failed to capture screenshot: %w", err)
+	}
+	if file == nil {
+		return fmt.Errorf("no screenshot returned")
+	}
+	// The SDK stores the response body in a temporary file.
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create screenshot file: %w", err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, file); err != nil {
+		return fmt.Errorf("failed to write screenshot: %w", err)
+	}
+	return nil
+}
+
+// captureConsoleLog saves the console output of a device and the details needed
+// to reach its serial console over SOS (Serial Over SSH) to the console log
+// directory, and returns the path of the saved report. The console output is only
+// captured once per device. An empty path is returned if capturing is disabled.
+func (a *equinixProvider) captureConsoleLog(ctx context.Context, device metal.Device, fault string) (string, error) {
+	if a.cfg.ConsoleLogDir == "" || device.GetId() == "" {
+		return "", nil
+	}
+
+	path := a.consoleLogPath(device)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create console log dir: %w", err)
+	}
+
+	var report bytes.Buffer
+	fmt.Fprintf(&report, "Device:      %s\n", device.GetId())
+	fmt.Fprintf(&report, "Runner:      %s\n", deviceMetadataOf(device, a.cfg.GetTagNames()).RunnerName)
+	fmt.Fprintf(&report, "Hostname:    %s\n", device.GetHostname())
+	fmt.Fprintf(&report, "State:       %s\n", device.GetState())
+	fmt.Fprintf(&report, "Plan:        %s\n", device.Plan.GetSlug())
+	fmt.Fprintf(&report, "Metro:       %s\n", device.Metro.GetCode())
+	fmt.Fprintf(&report, "Captured at: %s\n", time.Now().UTC().Format(time.RFC3339))
+	if sos := device.GetSos(); sos != "" {
+		fmt.Fprintf(&report, "SOS:         ssh %s@%s\n", device.GetId(), sos)
+	}
+	screenshotPath := strings.TrimSuffix(path, ".txt") + ".jpg"
+	if err := a.saveScreenshot(ctx, device.GetId(), screenshotPath); err != nil {
+		fmt.Fprintf(&report, "Screenshot:  unavailable (%s)\n", err)
+	} else {
+		fmt.Fprintf(&report, "Screenshot:  %s\n", screenshotPath)
+	}
+	if fault != "" {
+		fmt.Fprintf(&report, "\nFault:\n%s\n", strings.ReplaceAll(fault, "; ", "\n"))
+	}
+
+	if err := os.WriteFile(path, report.Bytes(), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write console log: %w", err)
+	}
+	return path, nil
+}
+
+// withConsoleLog captures the console output of a device, and returns the given
+// fault with a reference to it. Errors are ignored.
+func (a *equinixProvider) withConsoleLog(ctx context.Context, device metal.Device, fault string) string {
+	path, err := a.captureConsoleLog(ctx, device, fault)
+	if err != nil || path == "" {
+		return fault
+	}
+	if fault == "" {
+		return fmt.Sprintf("console log: %s", path)
+	}
+	return fmt.Sprintf("%s (console log: %s)", fault, path)
+}
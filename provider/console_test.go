@@ -0,0 +1,128 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleLogDirName(t *testing.T) {
+	assert.Equal(t, "runner-1", consoleLogDirName("runner-1"))
+	assert.Equal(t, "_etc_passwd", consoleLogDirName("/etc/passwd"))
+	assert.Equal(t, "unknown", consoleLogDirName(""))
+	assert.Equal(t, "unknown", consoleLogDirName(".."))
+}
+
+func TestCaptureConsoleLog(t *testing.T) {
+	ctx := context.Background()
+	device := metal.Device{
+		Id:    spec.Ptr("mock-id"),
+		Tags:  []string{"Name=runner-1"},
+		State: spec.Ptr(metal.DEVICESTATE_FAILED),
+		Sos:   spec.Ptr("sos.am6.platformequinix.com"),
+	}
+
+	tests := []struct {
+		name             string
+		screenshotErr    error
+		expectedInReport []string
+	}{
+		{
+			name: "with screenshot",
+			expectedInReport: []string{
+				"SOS:         ssh mock-id@sos.am6.platformequinix.com",
+				filepath.Join("runner-1", "mock-id.jpg"),
+				"Fault:\nprovisioning.failed: boom\nprovisioning.failed: again",
+			},
+		},
+		{
+			name:          "without screenshot",
+			screenshotErr: fmt.Errorf("not supported"),
+			expectedInReport: []string{
+				"Screenshot:  unavailable (failed to capture screenshot: not supported)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:     cli,
+				console: cli,
+				cfg:     &config.Config{ConsoleLogDir: dir},
+			}
+			cli.On("CaptureScreenshot", ctx, "mock-id").Return(metal.ApiCaptureScreenshotRequest{
+				ApiService: &metal.ConsoleLogDetailsApiService{},
+			}, nil)
+			DefaultExecuteCaptureScreenshot = func(r metal.ApiCaptureScreenshotRequest) (*os.File, *http.Response, error) {
+				if tt.screenshotErr != nil {
+					return nil, &http.Response{StatusCode: http.StatusNotFound}, tt.screenshotErr
+				}
+				file, err := os.CreateTemp(t.TempDir(), "screenshot")
+				require.NoError(t, err)
+				_, err = file.WriteString("jpeg data")
+				require.NoError(t, err)
+				_, err = file.Seek(0, 0)
+				require.NoError(t, err)
+				return file, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			path, err := a.captureConsoleLog(ctx, device, "provisioning.failed: boom; provisioning.failed: again")
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, "runner-1", "mock-id.txt"), path)
+			report, err := os.ReadFile(path)
+			require.NoError(t, err)
+			for _, expected := range tt.expectedInReport {
+				assert.Contains(t, string(report), expected)
+			}
+			if tt.screenshotErr == nil {
+				screenshot, err := os.ReadFile(filepath.Join(dir, "runner-1", "mock-id.jpg"))
+				require.NoError(t, err)
+				assert.Equal(t, "jpeg data", string(screenshot))
+			}
+
+			// The console output is only captured once.
+			fault := a.withConsoleLog(ctx, device, "boom")
+			assert.Equal(t, fmt.Sprintf("boom (console log: %s)", path), fault)
+			cli.AssertNumberOfCalls(t, "CaptureScreenshot", 1)
+		})
+	}
+}
+
+func TestCaptureConsoleLogDisabled(t *testing.T) {
+	cli := new(MockClient)
+	a := &equinixProvider{
+		console: cli,
+		cfg:     &config.Config{},
+	}
+	path, err := a.captureConsoleLog(context.Background(), metal.Device{Id: spec.Ptr("mock-id")}, "boom")
+	require.NoError(t, err)
+	assert.Empty(t, path)
+	assert.Equal(t, "boom", a.withConsoleLog(context.Background(), metal.Device{Id: spec.Ptr("mock-id")}, "boom"))
+	cli.AssertNotCalled(t, "CaptureScreenshot")
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// PoolCost is the cost of the devices of a pool over a period of time, as billed
+// by Equinix Metal.
+type PoolCost struct {
+	// PoolID is empty for the usage that could not be attributed to a pool.
+	PoolID string `json:"pool_id"`
+	// Devices is the number of devices billed.
+	Devices int `json:"devices"`
+	// Total is the cost of the devices, in USD.
+	Total float64 `json:"total"`
+}
+
+// spotPrices returns the current spot market prices of a metro, indexed by plan.
+func (a *equinixProvider) spotPrices(ctx context.Context, metro string) (map[string]float64, error) {
+	metro = strings.ToLower(metro)
+	prices, _, err := DefaultExecuteFindMetroSpotMarketPrices(a.spot.FindMetroSpotMarketPrices(ctx).Metro(metro))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spot market prices: %w", err)
+	}
+
+	// The SDK only models some of the metros and plans. The report is decoded
+	// again, to also get the ones it keeps as additional properties.
+	data, err := json.Marshal(prices.GetSpotMarketPrices())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode spot market prices: %w", err)
+	}
+	var report map[string]map[string]struct {
+		Price *float64 `json:"price"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode spot market prices: %w", err)
+	}
+	ret := map[string]float64{}
+	for plan, price := range report[metro] {
+		if price.Price != nil {
+			ret[plan] = *price.Price
+		}
+	}
+	return ret, nil
+}
+
+// spotPriceHistory returns the spot prices of a plan in a metro between since and
+// until.
+func (a *equinixProvider) spotPriceHistory(ctx context.Context, metro, plan string, since, until time.Time) ([]float64, error) {
+	// The SDK requires a facility. An empty one is sent along with the metro. If
+	// the API rejects it, or filters the history with it, spotBid falls back to
+	// the current spot price of the metro.
+	req := a.spot.FindSpotMarketPricesHistory(ctx).
+		Facility("").
+		Metro(strings.ToLower(metro)).
+		Plan(plan).
+		From(strconv.FormatInt(since.Unix(), 10)).
+		Until(strconv.FormatInt(until.Unix(), 10))
+	history, _, err := DefaultExecuteFindSpotMarketPricesHistory(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spot market price history: %w", err)
+	}
+	var ret []float64
+	// Datapoints are pairs of a price and a timestamp.
+	for _, datapoint := range history.GetPricesHistory().Datapoints {
+		if len(datapoint) > 0 {
+			ret = append(ret, float64(datapoint[0]))
+		}
+	}
+	return ret, nil
+}
+
+// hourlyPrice returns the estimated hourly price of a device, in USD. Spot
+// devices are priced at the current spot market price of the metro.
+func (a *equinixProvider) hourlyPrice(ctx context.Context, metro, plan string, spot bool) (float64, error) {
+	var prices map[string]float64
+	var err error
+	if spot {
+		prices, err = a.spotPrices(ctx, metro)
+	} else {
+		prices, err = a.planHourlyPrices(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	price, ok := prices[plan]
+	if !ok {
+		return 0, fmt.Errorf("unknown hourly price of plan %q", plan)
+	}
+	return price, nil
+}
+
+// hourlyPriceTag returns the tag recording the hourly price of a device.
+func hourlyPriceTag(price float64, names spec.TagNames) string {
+	return fmt.Sprintf("%s=%s", names.HourlyPrice, strconv.FormatFloat(price, 'f', 4, 64))
+}
+
+// deviceHourlyPrice returns the hourly price recorded on a device, or zero if it
+// is unknown.
+func deviceHourlyPrice(device metal.Device, names spec.TagNames) float64 {
+	price, err := strconv.ParseFloat(extractTagsAsMap(device)[names.HourlyPrice], 64)
+	if err != nil {
+		return 0
+	}
+	return price
+}
+
+// accumulatedCost returns the estimated cost of a device since it was created.
+func accumulatedCost(hourlyPrice float64, createdAt, now time.Time) float64 {
+	if createdAt.IsZero() || now.Before(createdAt) {
+		return 0
+	}
+	return hourlyPrice * now.Sub(createdAt).Hours()
+}
+
+// PoolCosts returns the cost of the devices of every pool, billed between since
+// and until. The usage reported by Equinix Metal is attributed to pools by device
+// hostname, using the pools of the devices that still exist and the pools recorded
+// in the state directory for the hostnames of deleted devices. The rest of the
+// usage of the project is reported without a pool ID.
+func (a *equinixProvider) PoolCosts(ctx context.Context, since, until time.Time) ([]PoolCost, error) {
+	poolByHostname, err := a.recordedHostnamePools()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	for _, device := range devices {
+		if !a.isManagedDevice(device) {
+			continue
+		}
+		poolByHostname[device.GetHostname()] = deviceMetadataOf(device, a.cfg.GetTagNames()).PoolID
+	}
+
+	req := a.usages.FindProjectUsage(ctx, a.cfg.ProjectID).
+		CreatedAfter(since.UTC().Format(time.RFC3339)).
+		CreatedBefore(until.UTC().Format(time.RFC3339))
+	usages, _, err := DefaultExecuteFindProjectUsage(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project usage: %w", err)
+	}
+
+	costs := map[string]*PoolCost{}
+	seen := map[string]bool{}
+	for _, usage := range usages.GetUsages() {
+		total, err := strconv.ParseFloat(usage.GetTotal(), 64)
+		if err != nil {
+			continue
+		}
+		poolID := poolByHostname[usage.GetName()]
+		cost, ok := costs[poolID]
+		if !ok {
+			cost = &PoolCost{PoolID: poolID}
+			costs[poolID] = cost
+		}
+		cost.Total += total
+		if key := poolID + "/" + usage.GetName(); !seen[key] {
+			seen[key] = true
+			cost.Devices++
+		}
+	}
+
+	ret := make([]PoolCost, 0, len(costs))
+	for _, cost := range costs {
+		ret = append(ret, *cost)
+	}
+	// Unattributed usage comes last.
+	sort.Slice(ret, func(i, j int) bool {
+		if (ret[i].PoolID == "") != (ret[j].PoolID == "") {
+			return ret[j].PoolID == ""
+		}
+		return ret[i].PoolID < ret[j].PoolID
+	})
+	return ret, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHourlyPrice(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		plans: cli,
+		spot:  cli,
+		cfg:   &config.Config{ProjectID: "project"},
+	}
+	cli.On("FindPlansByProject", ctx, "project").Return(metal.ApiFindPlansByProjectRequest{
+		ApiService: &metal.PlansApiService{},
+	}, nil)
+	DefaultExecuteFindPlansByProject = func(r metal.ApiFindPlansByProjectRequest) (*metal.PlanList, *http.Response, error) {
+		return &metal.PlanList{Plans: []metal.Plan{
+			{Slug: spec.Ptr("c3.small.x86"), Pricing: map[string]interface{}{"hour": 0.75}},
+		}}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("FindMetroSpotMarketPrices", ctx).Return(metal.ApiFindMetroSpotMarketPricesRequest{
+		ApiService: &metal.SpotMarketApiService{},
+	}, nil)
+	DefaultExecuteFindMetroSpotMarketPrices = func(r metal.ApiFindMetroSpotMarketPricesRequest) (*metal.SpotMarketPricesPerMetroList, *http.Response, error) {
+		return &metal.SpotMarketPricesPerMetroList{
+			SpotMarketPrices: &metal.SpotMarketPricesPerMetroReport{
+				Da: &metal.SpotPricesPerFacility{
+					AdditionalProperties: map[string]interface{}{
+						"c3.small.x86": map[string]interface{}{"price": 0.21},
+					},
+				},
+				// Metros not modeled by the SDK are kept as additional properties.
+				AdditionalProperties: map[string]interface{}{
+					"fr": map[string]interface{}{
+						"c3.small.x86": map[string]interface{}{"price": 0.35},
+					},
+				},
+			},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	tests := []struct {
+		name      string
+		metro     string
+		plan      string
+		spot      bool
+		expected  float64
+		errString string
+	}{
+		{name: "on demand", metro: "DA", plan: "c3.small.x86", expected: 0.75},
+		{name: "spot", metro: "DA", plan: "c3.small.x86", spot: true, expected: 0.21},
+		{name: "spot in unmodeled metro", metro: "FR", plan: "c3.small.x86", spot: true, expected: 0.35},
+		{name: "unknown plan", metro: "DA", plan: "m3.large.x86", errString: `unknown hourly price of plan "m3.large.x86"`},
+		{name: "unknown spot plan", metro: "SV", plan: "c3.small.x86", spot: true, errString: `unknown hourly price of plan "c3.small.x86"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, err := a.hourlyPrice(ctx, tt.metro, tt.plan, tt.spot)
+			if tt.errString != "" {
+				assert.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, price, 1e-6)
+		})
+	}
+}
+
+func TestDeviceHourlyPrice(t *testing.T) {
+	device := metal.Device{Tags: []string{hourlyPriceTag(0.75, spec.LegacyTagNames)}}
+	assert.Equal(t, "garm-hourly-price=0.7500", device.Tags[0])
+	assert.Equal(t, 0.75, deviceHourlyPrice(device, spec.LegacyTagNames))
+	assert.Equal(t, 0.0, deviceHourlyPrice(metal.Device{}, spec.LegacyTagNames))
+	assert.Equal(t, 0.0, deviceHourlyPrice(metal.Device{Tags: []string{"garm-hourly-price=bogus"}}, spec.LegacyTagNames))
+}
+
+func TestAccumulatedCost(t *testing.T) {
+	now := time.Now()
+	assert.InDelta(t, 2.25, accumulatedCost(0.75, now.Add(-3*time.Hour), now), 1e-9)
+	assert.Equal(t, 0.0, accumulatedCost(0.75, time.Time{}, now))
+	assert.Equal(t, 0.0, accumulatedCost(0.75, now.Add(time.Hour), now))
+}
+
+func TestPoolCosts(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:          cli,
+		usages:       cli,
+		cfg:          &config.Config{ProjectID: "project", StateDir: t.TempDir()},
+		controllerID: "mock-controller-id",
+	}
+	// Deleted devices are attributed to the pool recorded for their hostname.
+	a.recordHostname("deleted-runner", "pool-2")
+	other := &equinixProvider{cfg: a.cfg, controllerID: "other-controller-id"}
+	other.recordHostname("deleted-elsewhere", "pool-3")
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: []metal.Device{
+			{Hostname: spec.Ptr("runner-1"), Tags: []string{"garm-pool-id=pool-1", "garm-controller-id=mock-controller-id"}},
+			{Hostname: spec.Ptr("runner-2"), Tags: []string{"garm-pool-id=pool-2", "garm-controller-id=mock-controller-id"}},
+			{Hostname: spec.Ptr("other"), Tags: []string{"garm-pool-id=pool-3", "garm-controller-id=other-controller-id"}},
+		}}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("FindProjectUsage", ctx, "project").Return(metal.ApiFindProjectUsageRequest{
+		ApiService: &metal.UsagesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectUsage = func(r metal.ApiFindProjectUsageRequest) (*metal.ProjectUsageList, *http.Response, error) {
+		return &metal.ProjectUsageList{Usages: []metal.ProjectUsage{
+			{Name: spec.Ptr("runner-1"), Total: spec.Ptr("1.50")},
+			{Name: spec.Ptr("runner-1"), Total: spec.Ptr("0.25")},
+			{Name: spec.Ptr("runner-2"), Total: spec.Ptr("3")},
+			{Name: spec.Ptr("deleted-runner"), Total: spec.Ptr("2")},
+			{Name: spec.Ptr("deleted-elsewhere"), Total: spec.Ptr("1")},
+			{Name: spec.Ptr("other"), Total: spec.Ptr("4")},
+			{Name: spec.Ptr("broken"), Total: spec.Ptr("n/a")},
+		}}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	costs, err := a.PoolCosts(ctx, time.Now().Add(-24*time.Hour), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []PoolCost{
+		{PoolID: "pool-1", Devices: 1, Total: 1.75},
+		{PoolID: "pool-2", Devices: 2, Total: 5},
+		{Devices: 2, Total: 5},
+	}, costs)
+}
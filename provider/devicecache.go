@@ -0,0 +1,112 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// deviceCache is the listing of the devices of the project, as cached in the
+// state directory.
+type deviceCache struct {
+	FetchedAt time.Time      `json:"fetched_at"`
+	Devices   []metal.Device `json:"devices"`
+}
+
+// deviceCacheLockTimeout is the time invalidating the device cache waits for a
+// listing in progress.
+const deviceCacheLockTimeout = 10 * time.Second
+
+func (a *equinixProvider) deviceCachePath() (string, error) {
+	return a.statePath(fmt.Sprintf("devices-%s.json", a.cfg.ProjectID))
+}
+
+// listCachedProjectDevices lists the devices of the project, through the device
+// cache when one is configured. The cache is locked while the devices are listed,
+// so that the provider processes started together by GARM make a single request,
+// and share its result until the cache expires. The cache is only an optimization:
+// the devices are listed from the API if it can not be used.
+func (a *equinixProvider) listCachedProjectDevices(ctx context.Context) ([]metal.Device, error) {
+	ttl := a.cfg.DeviceCacheTTL
+	if ttl <= 0 {
+		return a.fetchProjectDevices(ctx)
+	}
+
+	path, err := a.deviceCachePath()
+	if err != nil {
+		return a.fetchProjectDevices(ctx)
+	}
+	unlock, err := filelock.LockContext(ctx, path+".lock")
+	if err != nil {
+		return a.fetchProjectDevices(ctx)
+	}
+	defer unlock()
+
+	now := time.Now()
+	if data, err := os.ReadFile(path); err == nil {
+		var cache deviceCache
+		if err := json.Unmarshal(data, &cache); err == nil {
+			if age := now.Sub(cache.FetchedAt); age >= 0 && age < ttl {
+				return cache.Devices, nil
+			}
+		}
+	}
+
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The userdata of a device holds the registration token of its runner, which
+	// must not be written to disk. It is never read from a listing.
+	for idx := range devices {
+		devices[idx].Userdata = nil
+	}
+	if data, err := json.Marshal(deviceCache{FetchedAt: now, Devices: devices}); err == nil {
+		_ = filelock.WriteFileAtomic(path, data, 0o600)
+	}
+	return devices, nil
+}
+
+// invalidateDeviceCache drops the cached device listing, after a device was
+// created, deleted or handed to another runner. Failing to invalidate the cache
+// is not an error: the listing is only stale until the cache expires.
+func (a *equinixProvider) invalidateDeviceCache() {
+	if a.cfg.DeviceCacheTTL <= 0 {
+		return
+	}
+	path, err := a.deviceCachePath()
+	if err != nil {
+		return
+	}
+	// The lock is held while the devices are listed, which is not waited for
+	// longer than deviceCacheLockTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), deviceCacheLockTimeout)
+	defer cancel()
+	unlock, err := filelock.LockContext(ctx, path+".lock")
+	if err != nil {
+		_ = os.Remove(path)
+		return
+	}
+	defer unlock()
+	_ = os.Remove(path)
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCachedProjectDevices(t *testing.T) {
+	tests := []struct {
+		name          string
+		ttl           time.Duration
+		invalidate    bool
+		expectedCalls int
+	}{
+		{
+			name:          "cache disabled",
+			expectedCalls: 2,
+		},
+		{
+			name:          "cache hit",
+			ttl:           time.Minute,
+			expectedCalls: 1,
+		},
+		{
+			name:          "cache invalidated",
+			ttl:           time.Minute,
+			invalidate:    true,
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli: cli,
+				cfg: &config.Config{
+					ProjectID:      "project",
+					StateDir:       t.TempDir(),
+					DeviceCacheTTL: tt.ttl,
+				},
+			}
+			cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{}, nil)
+
+			calls := 0
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				calls++
+				return &metal.DeviceList{
+					Devices: []metal.Device{
+						{
+							Id:       spec.Ptr("mock-id"),
+							Tags:     []string{"Name=mock-name"},
+							State:    spec.Ptr(metal.DEVICESTATE_ACTIVE),
+							Userdata: spec.Ptr("#!/bin/bash\nexport TOKEN=secret"),
+						},
+					},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			for i := 0; i < 2; i++ {
+				devices, err := a.listCachedProjectDevices(ctx)
+				require.NoError(t, err)
+				require.Len(t, devices, 1)
+				assert.Equal(t, "mock-id", devices[0].GetId())
+				assert.Equal(t, []string{"Name=mock-name"}, devices[0].GetTags())
+				assert.Equal(t, metal.DEVICESTATE_ACTIVE, devices[0].GetState())
+				if tt.ttl > 0 {
+					assert.Nil(t, devices[0].Userdata, "userdata must not be cached")
+				}
+				if tt.invalidate {
+					a.invalidateDeviceCache()
+				}
+			}
+			assert.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
+
+func TestListCachedProjectDevicesExpires(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID:      "project",
+			StateDir:       t.TempDir(),
+			DeviceCacheTTL: 10 * time.Millisecond,
+		},
+	}
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{}, nil)
+	calls := 0
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		calls++
+		return &metal.DeviceList{}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	_, err := a.listCachedProjectDevices(ctx)
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = a.listCachedProjectDevices(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// planCandidates returns the plans to create a device with, in order: the plan of
+// the pool, then its fallback plans.
+func planCandidates(plan string, fallbacks []string) []string {
+	ret := []string{plan}
+	for _, fallback := range fallbacks {
+		if !strings.EqualFold(fallback, plan) && !containsFold(ret, fallback) {
+			ret = append(ret, fallback)
+		}
+	}
+	return ret
+}
+
+func containsFold(values []string, value string) bool {
+	for _, val := range values {
+		if strings.EqualFold(val, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCapacity returns false if the capacity API reports that no device of the
+// given plan can be created in the metro. The capacity API is only a hint, so
+// callers go on with the plan and metro if it fails.
+func (a *equinixProvider) hasCapacity(ctx context.Context, metro, plan string) (bool, error) {
+	input := metal.CapacityInput{
+		Servers: []metal.ServerInfo{
+			{
+				Metro:    &metro,
+				Plan:     &plan,
+				Quantity: spec.Ptr("1"),
+			},
+		},
+	}
+	capacity, _, err := DefaultExecuteCheckCapacityForMetro(a.capacity.CheckCapacityForMetro(ctx).CapacityInput(input))
+	if err != nil {
+		return false, fmt.Errorf("failed to check capacity: %w", err)
+	}
+	for _, server := range capacity.GetServers() {
+		if !server.GetAvailable() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// withPlan returns the input of a device of the given plan, tagged with the plan
+// and, if known, its hourly price.
+func (a *equinixProvider) withPlan(ctx context.Context, input metal.DeviceCreateInMetroInput, plan string) metal.DeviceCreateInMetroInput {
+	names := a.cfg.GetTagNames()
+	input.Plan = plan
+	input.Tags = append(append([]string{}, input.Tags...), fmt.Sprintf("%s=%s", names.Plan, plan))
+	if !a.cfg.TrackCosts {
+		return input
+	}
+	if price, err := a.hourlyPrice(ctx, input.Metro, plan, input.GetSpotInstance()); err == nil {
+		input.Tags = append(input.Tags, hourlyPriceTag(price, names))
+	}
+	return input
+}
+
+// createDeviceWithFallbacks creates the device of a runner with the first of the
+// given plans which is in stock. If there are several plans, those the capacity
+// API reports as out of stock are skipped, and the next plan is tried if creating
+// the device fails for lack of capacity. The plan of the device is returned, along
+// with the device.
+func (a *equinixProvider) createDeviceWithFallbacks(ctx context.Context, runnerName string, input metal.DeviceCreateInMetroInput, plans []string) (*metal.Device, string, error) {
+	var errs []error
+	for idx, plan := range plans {
+		if len(plans) > 1 {
+			if available, err := a.hasCapacity(ctx, input.Metro, plan); err == nil && !available {
+				log.Printf("runner %s: plan %s is out of stock in metro %s", runnerName, plan, input.Metro)
+				errs = append(errs, fmt.Errorf("%w: plan %s is out of stock in metro %s", errNoCapacity, plan, input.Metro))
+				continue
+			}
+		}
+
+		device, err := a.createDevice(ctx, a.withPlan(ctx, input, plan))
+		if err == nil {
+			if idx > 0 {
+				log.Printf("runner %s: created device %s with fallback plan %s", runnerName, device.GetId(), plan)
+			}
+			return device, plan, nil
+		}
+		if len(plans) == 1 {
+			return nil, plan, err
+		}
+		if !errors.Is(err, errNoCapacity) {
+			return nil, plan, err
+		}
+		log.Printf("runner %s: failed to create device with plan %s: %s", runnerName, plan, err)
+		errs = append(errs, err)
+	}
+	return nil, plans[len(plans)-1], fmt.Errorf("%w: all plans are out of stock (%s): %w", errCreateDevice, strings.Join(plans, ", "), errors.Join(errs...))
+}
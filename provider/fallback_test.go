@@ -0,0 +1,201 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCandidates(t *testing.T) {
+	assert.Equal(t, []string{"c3.small.x86"}, planCandidates("c3.small.x86", nil))
+	assert.Equal(t, []string{"c3.small.x86", "m3.small.x86", "m3.large.x86"},
+		planCandidates("c3.small.x86", []string{"m3.small.x86", "C3.SMALL.X86", "m3.large.x86", "m3.small.x86"}))
+}
+
+func TestCreateDeviceWithFallbacks(t *testing.T) {
+	outOfStock := fmt.Errorf("503 Service Unavailable")
+	tests := []struct {
+		name            string
+		plans           []string
+		capacity        []bool
+		createErrs      []error
+		expectedPlan    string
+		expectedCreates int
+		errString       string
+		errIs           error
+	}{
+		{
+			name:            "single plan",
+			plans:           []string{"c3.small.x86"},
+			createErrs:      []error{nil},
+			expectedPlan:    "c3.small.x86",
+			expectedCreates: 1,
+		},
+		{
+			name:            "single plan out of stock",
+			plans:           []string{"c3.small.x86"},
+			createErrs:      []error{outOfStock},
+			expectedPlan:    "c3.small.x86",
+			expectedCreates: 1,
+			errIs:           errNoCapacity,
+		},
+		{
+			name:            "plan reported out of stock by the capacity check",
+			plans:           []string{"c3.small.x86", "m3.small.x86"},
+			capacity:        []bool{false, true},
+			createErrs:      []error{nil},
+			expectedPlan:    "m3.small.x86",
+			expectedCreates: 1,
+		},
+		{
+			name:            "plan reported out of stock by CreateDevice",
+			plans:           []string{"c3.small.x86", "m3.small.x86"},
+			capacity:        []bool{true, true},
+			createErrs:      []error{outOfStock, nil},
+			expectedPlan:    "m3.small.x86",
+			expectedCreates: 2,
+		},
+		{
+			name:            "other errors are not retried",
+			plans:           []string{"c3.small.x86", "m3.small.x86"},
+			capacity:        []bool{true, true},
+			createErrs:      []error{errors.New("invalid userdata")},
+			expectedPlan:    "c3.small.x86",
+			expectedCreates: 1,
+			errString:       "invalid userdata",
+		},
+		{
+			name:            "all plans out of stock",
+			plans:           []string{"c3.small.x86", "m3.small.x86"},
+			capacity:        []bool{false, true},
+			createErrs:      []error{outOfStock},
+			expectedPlan:    "m3.small.x86",
+			expectedCreates: 1,
+			errString:       "all plans are out of stock (c3.small.x86, m3.small.x86)",
+			errIs:           errNoCapacity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:      cli,
+				plans:    cli,
+				capacity: cli,
+				cfg: &config.Config{
+					ProjectID: "project",
+				},
+			}
+			checks := 0
+			cli.On("CheckCapacityForMetro", ctx).Return(metal.ApiCheckCapacityForMetroRequest{
+				ApiService: &metal.CapacityApiService{},
+			}, nil)
+			DefaultExecuteCheckCapacityForMetro = func(r metal.ApiCheckCapacityForMetroRequest) (*metal.CapacityCheckPerMetroList, *http.Response, error) {
+				available := tt.capacity[checks]
+				checks++
+				return &metal.CapacityCheckPerMetroList{
+					Servers: []metal.CapacityCheckPerMetroInfo{{Available: &available}},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			creates := 0
+			cli.On("CreateDevice", ctx, "project").Return(metal.ApiCreateDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteCreateDevice = func(r metal.ApiCreateDeviceRequest) (*metal.Device, *http.Response, error) {
+				err := tt.createErrs[creates]
+				creates++
+				if err == outOfStock {
+					return nil, &http.Response{StatusCode: http.StatusServiceUnavailable}, err
+				}
+				if err != nil {
+					return nil, &http.Response{StatusCode: http.StatusUnprocessableEntity}, err
+				}
+				return &metal.Device{Id: spec.Ptr("device-1")}, &http.Response{StatusCode: http.StatusCreated}, nil
+			}
+
+			input := metal.DeviceCreateInMetroInput{Metro: "am", Plan: tt.plans[0]}
+			device, plan, err := a.createDeviceWithFallbacks(ctx, "runner-1", input, tt.plans)
+			assert.Equal(t, tt.expectedPlan, plan)
+			assert.Equal(t, tt.expectedCreates, creates)
+			assert.Equal(t, len(tt.capacity) > 0 && len(tt.plans) > 1, checks > 0)
+			if tt.errString != "" || tt.errIs != nil {
+				require.Error(t, err)
+				if tt.errString != "" {
+					assert.ErrorContains(t, err, tt.errString)
+				}
+				if tt.errIs != nil {
+					assert.ErrorIs(t, err, tt.errIs)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "device-1", device.GetId())
+		})
+	}
+}
+
+func TestWithPlan(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		plans: cli,
+		cfg:   &config.Config{ProjectID: "project", TagPrefix: "garm.example.com/", TrackCosts: true},
+	}
+	cli.On("FindPlansByProject", ctx, "project").Return(metal.ApiFindPlansByProjectRequest{
+		ApiService: &metal.PlansApiService{},
+	}, nil)
+	DefaultExecuteFindPlansByProject = func(r metal.ApiFindPlansByProjectRequest) (*metal.PlanList, *http.Response, error) {
+		return &metal.PlanList{
+			Plans: []metal.Plan{
+				{Slug: spec.Ptr("m3.small.x86"), Pricing: map[string]interface{}{"hour": 1.05}},
+			},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	tags := []string{"garm.example.com/name=runner-1"}
+	input := a.withPlan(ctx, metal.DeviceCreateInMetroInput{Plan: "c3.small.x86", Tags: tags}, "m3.small.x86")
+	assert.Equal(t, "m3.small.x86", input.Plan)
+	assert.Equal(t, []string{
+		"garm.example.com/name=runner-1",
+		"garm.example.com/plan=m3.small.x86",
+		"garm.example.com/hourly-price=1.0500",
+	}, input.Tags)
+	assert.Equal(t, []string{"garm.example.com/name=runner-1"}, tags)
+}
+
+func TestWithPlanWithoutCostTracking(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		plans: cli,
+		cfg:   &config.Config{ProjectID: "project"},
+	}
+
+	input := a.withPlan(ctx, metal.DeviceCreateInMetroInput{Plan: "c3.small.x86"}, "m3.small.x86")
+	assert.Equal(t, []string{"garm-plan=m3.small.x86"}, input.Tags)
+	cli.AssertNotCalled(t, "FindPlansByProject", ctx, "project")
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hostnameRetention is the amount of time the pools of device hostnames are
+// remembered, to attribute the usage of deleted devices to their pool.
+const hostnameRetention = 400 * 24 * time.Hour
+
+// hostnameRecord records the pool of a device hostname. Equinix Metal reports
+// usage by device hostname, which is all that is left of a deleted device.
+type hostnameRecord struct {
+	Hostname     string    `json:"hostname"`
+	ControllerID string    `json:"controller_id"`
+	PoolID       string    `json:"pool_id"`
+	RecordedAt   time.Time `json:"recorded_at"`
+}
+
+func (a *equinixProvider) hostnamesDir() (string, error) {
+	return a.statePath("hostnames")
+}
+
+// recordHostname records the pool of a device hostname. Records are appended to a
+// file per day, and the files older than the retention period are removed when a
+// new file is started. Recording is best effort: it must not fail the creation of
+// the device.
+func (a *equinixProvider) recordHostname(hostname, poolID string) {
+	if hostname == "" || poolID == "" {
+		return
+	}
+	if err := a.appendHostnameRecord(hostname, poolID, time.Now().UTC()); err != nil {
+		log.Printf("failed to record pool of hostname %s: %s", hostname, err)
+	}
+}
+
+func (a *equinixProvider) appendHostnameRecord(hostname, poolID string, now time.Time) error {
+	data, err := json.Marshal(hostnameRecord{
+		Hostname:     hostname,
+		ControllerID: a.controllerID,
+		PoolID:       poolID,
+		RecordedAt:   now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode hostname record: %w", err)
+	}
+	dir, err := a.hostnamesDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create hostnames directory: %w", err)
+	}
+
+	path := filepath.Join(dir, now.Format(time.DateOnly)+".jsonl")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		pruneHostnameRecords(dir, now)
+	}
+	// Records are much smaller than the size up to which appends are atomic, so
+	// concurrent provider processes do not interleave them.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open hostname records: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write hostname record: %w", err)
+	}
+	return nil
+}
+
+// pruneHostnameRecords removes the files of records older than the retention
+// period from dir.
+func pruneHostnameRecords(dir string, now time.Time) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		day, err := time.Parse(time.DateOnly, strings.TrimSuffix(entry.Name(), ".jsonl"))
+		if err != nil || now.Sub(day) <= hostnameRetention {
+			continue
+		}
+		_ = os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// recordedHostnamePools returns the pools of the device hostnames recorded for the
+// controller, or for all controllers if no controller ID is set. When a hostname
+// was recorded more than once, the latest record wins. Unreadable records are
+// skipped.
+func (a *equinixProvider) recordedHostnamePools() (map[string]string, error) {
+	dir, err := a.hostnamesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read hostnames directory: %w", err)
+	}
+	// Files are named after their day, so they sort in the order they were written.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	ret := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record hostnameRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				continue
+			}
+			if a.controllerID != "" && record.ControllerID != a.controllerID {
+				continue
+			}
+			ret[record.Hostname] = record.PoolID
+		}
+		file.Close()
+	}
+	return ret, nil
+}
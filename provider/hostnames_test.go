@@ -0,0 +1,76 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordedHostnamePools(t *testing.T) {
+	cfg := &config.Config{ProjectID: "project", StateDir: t.TempDir()}
+	a := &equinixProvider{cfg: cfg, controllerID: "controller-1"}
+	other := &equinixProvider{cfg: cfg, controllerID: "controller-2"}
+
+	pools, err := a.recordedHostnamePools()
+	require.NoError(t, err)
+	assert.Empty(t, pools)
+
+	now := time.Now().UTC()
+	require.NoError(t, a.appendHostnameRecord("runner-1", "pool-1", now.AddDate(0, 0, -1)))
+	require.NoError(t, a.appendHostnameRecord("runner-2", "pool-1", now.AddDate(0, 0, -1)))
+	// The latest record of a hostname wins.
+	require.NoError(t, a.appendHostnameRecord("runner-2", "pool-2", now))
+	require.NoError(t, other.appendHostnameRecord("runner-3", "pool-3", now))
+	// Hostnames without a pool are not recorded.
+	a.recordHostname("runner-4", "")
+
+	pools, err = a.recordedHostnamePools()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"runner-1": "pool-1", "runner-2": "pool-2"}, pools)
+
+	// Without a controller ID, the records of all controllers are read.
+	pools, err = (&equinixProvider{cfg: cfg}).recordedHostnamePools()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"runner-1": "pool-1", "runner-2": "pool-2", "runner-3": "pool-3"}, pools)
+}
+
+func TestPruneHostnameRecords(t *testing.T) {
+	a := &equinixProvider{
+		cfg:          &config.Config{ProjectID: "project", StateDir: t.TempDir()},
+		controllerID: "controller-1",
+	}
+	now := time.Now().UTC()
+	expired := now.Add(-hostnameRetention - 48*time.Hour)
+	require.NoError(t, a.appendHostnameRecord("runner-1", "pool-1", expired))
+	require.NoError(t, a.appendHostnameRecord("runner-2", "pool-1", now.AddDate(0, 0, -1)))
+
+	// Starting the file of a new day removes the expired files.
+	require.NoError(t, a.appendHostnameRecord("runner-3", "pool-1", now))
+	dir, err := a.hostnamesDir()
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, expired.Format(time.DateOnly)+".jsonl"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	pools, err := a.recordedHostnamePools()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"runner-2": "pool-1", "runner-3": "pool-1"}, pools)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// idleSince returns the time a device was parked. Devices parked by older
+// versions of the provider have no park time, and were last updated when parked.
+func idleSince(device metal.Device, names spec.TagNames) time.Time {
+	if parkedAt := deviceMetadataOf(device, names).ParkedAt; parkedAt != nil {
+		return *parkedAt
+	}
+	return device.GetUpdatedAt()
+}
+
+// isIdleExpired returns true if the device has been parked for longer than the
+// maximum idle time.
+func isIdleExpired(device metal.Device, names spec.TagNames, maxIdleTime time.Duration, now time.Time) bool {
+	if maxIdleTime <= 0 || !isIdleDevice(device, names) {
+		return false
+	}
+	since := idleSince(device, names)
+	return !since.IsZero() && now.Sub(since) > maxIdleTime
+}
+
+// pruneIdleDevices deletes the devices parked for longer than the maximum idle
+// time. If poolID is not empty, only the devices of that pool are considered. The
+// deleted devices are returned, along with the devices still parked. If dryRun is
+// true, the expired devices are only returned. The standby lock must be held.
+func (a *equinixProvider) pruneIdleDevices(ctx context.Context, poolID string, dryRun bool) ([]metal.Device, []metal.Device, error) {
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	names := a.cfg.GetTagNames()
+	now := time.Now()
+	var expired, parked []metal.Device
+	var errs []error
+	for _, device := range devices {
+		if !a.isManagedDevice(device) || !isIdleDevice(device, names) {
+			continue
+		}
+		if poolID != "" && deviceMetadataOf(device, names).PoolID != poolID {
+			continue
+		}
+		switch device.GetState() {
+		case metal.DEVICESTATE_DELETED, metal.DEVICESTATE_DEPROVISIONING:
+			continue
+		}
+		if !isIdleExpired(device, names, a.cfg.MaxIdleTime, now) {
+			parked = append(parked, device)
+			continue
+		}
+		if !dryRun {
+			if err := a.deleteOneInstance(ctx, device.GetId()); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete device %s: %w", device.GetId(), err))
+				continue
+			}
+		}
+		expired = append(expired, device)
+	}
+	return expired, parked, errors.Join(errs...)
+}
+
+// parkIfRoom parks a device, unless its pool already has the maximum number of
+// parked devices. The devices of the pool parked for longer than the maximum idle
+// time are deleted first. Parking is serialized with claims, so that the parked
+// devices are counted accurately.
+func (a *equinixProvider) parkIfRoom(ctx context.Context, device metal.Device) (bool, error) {
+	if a.cfg.MaxIdleDevices <= 0 && a.cfg.MaxIdleTime <= 0 {
+		return true, a.parkDevice(ctx, device)
+	}
+
+	unlock, err := a.lockState(ctx, "standby.lock")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	poolID := deviceMetadataOf(device, a.cfg.GetTagNames()).PoolID
+	_, parked, err := a.pruneIdleDevices(ctx, poolID, false)
+	if err != nil {
+		return false, err
+	}
+	if a.cfg.MaxIdleDevices > 0 && len(parked) >= a.cfg.MaxIdleDevices {
+		return false, nil
+	}
+	return true, a.parkDevice(ctx, device)
+}
+
+// PruneIdleDevices deletes the devices parked for longer than the configured
+// maximum idle time, and returns them. If dryRun is true, no device is deleted.
+func (a *equinixProvider) PruneIdleDevices(ctx context.Context, dryRun bool) ([]DeviceInfo, error) {
+	ret := []DeviceInfo{}
+	if a.cfg.MaxIdleTime <= 0 {
+		return ret, nil
+	}
+
+	unlock, err := a.lockState(ctx, "standby.lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	expired, _, pruneErr := a.pruneIdleDevices(ctx, "", dryRun)
+	names := a.cfg.GetTagNames()
+	for _, device := range expired {
+		ret = append(ret, equinixToDeviceInfo(device, names))
+	}
+	return ret, pruneErr
+}
@@ -0,0 +1,260 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func parkedTestDevice(id string, parkedAt time.Time) metal.Device {
+	device := idleTestDevice(id, time.Now())
+	meta := deviceMetadata{
+		Version:      metadataVersion,
+		ControllerID: "mock-controller-id",
+		PoolID:       "test-pool",
+		ParkedAt:     &parkedAt,
+	}
+	device.Customdata = meta.customdata()
+	return device
+}
+
+func TestIsIdleExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		device      metal.Device
+		maxIdleTime time.Duration
+		expected    bool
+	}{
+		{
+			name:        "parked recently",
+			device:      parkedTestDevice("device-1", now.Add(-time.Minute)),
+			maxIdleTime: time.Hour,
+			expected:    false,
+		},
+		{
+			name:        "parked long ago",
+			device:      parkedTestDevice("device-1", now.Add(-2*time.Hour)),
+			maxIdleTime: time.Hour,
+			expected:    true,
+		},
+		{
+			name:        "parked long ago without max idle time",
+			device:      parkedTestDevice("device-1", now.Add(-2*time.Hour)),
+			maxIdleTime: 0,
+			expected:    false,
+		},
+		{
+			name:        "parked by an older version",
+			device:      idleTestDevice("device-1", now.Add(-2*time.Hour)),
+			maxIdleTime: time.Hour,
+			expected:    true,
+		},
+		{
+			name: "device assigned to a runner",
+			device: metal.Device{
+				Tags:      []string{"garm-pool-id=test-pool", "garm-controller-id=mock-controller-id"},
+				UpdatedAt: spec.Ptr(now.Add(-2 * time.Hour)),
+			},
+			maxIdleTime: time.Hour,
+			expected:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isIdleExpired(tt.device, spec.LegacyTagNames, tt.maxIdleTime, now))
+		})
+	}
+}
+
+func TestParkIfRoom(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	expiredID := "2b0a6c1e-4a8f-4c47-9d3b-6a0e5f0b7d21"
+	runnerDevice := metal.Device{
+		Id: spec.Ptr(instanceID),
+		Tags: []string{
+			"Name=runner-1",
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"garm-recycle=true",
+		},
+		State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+	}
+	otherPool := parkedTestDevice("other-pool-device", time.Now())
+	otherPool.Customdata = deviceMetadata{
+		Version:      metadataVersion,
+		ControllerID: "mock-controller-id",
+		PoolID:       "other-pool",
+	}.customdata()
+
+	tests := []struct {
+		name            string
+		maxIdleDevices  int
+		maxIdleTime     time.Duration
+		parked          []metal.Device
+		expectedParked  bool
+		expectedDeleted bool
+	}{
+		{
+			name:           "no limits",
+			parked:         []metal.Device{parkedTestDevice("device-1", time.Now())},
+			expectedParked: true,
+		},
+		{
+			name:           "room left",
+			maxIdleDevices: 2,
+			parked:         []metal.Device{parkedTestDevice("device-1", time.Now()), otherPool},
+			expectedParked: true,
+		},
+		{
+			name:           "pool full",
+			maxIdleDevices: 1,
+			parked:         []metal.Device{parkedTestDevice("device-1", time.Now())},
+			expectedParked: false,
+		},
+		{
+			name:            "expired device makes room",
+			maxIdleDevices:  1,
+			maxIdleTime:     time.Hour,
+			parked:          []metal.Device{parkedTestDevice(expiredID, time.Now().Add(-2*time.Hour))},
+			expectedParked:  true,
+			expectedDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli: cli,
+				cfg: &config.Config{
+					ProjectID:      "project",
+					StateDir:       t.TempDir(),
+					MaxIdleDevices: tt.maxIdleDevices,
+					MaxIdleTime:    tt.maxIdleTime,
+				},
+				controllerID: "mock-controller-id",
+			}
+			cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				return &metal.DeviceList{Devices: tt.parked}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("FindDeviceById", ctx, mock.Anything).Return(metal.ApiFindDeviceByIdRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				return &runnerDevice, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("DeleteDevice", ctx, mock.Anything).Return(metal.ApiDeleteDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNoContent}, nil
+			}
+			cli.On("UpdateDevice", ctx, instanceID).Return(metal.ApiUpdateDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteUpdateDevice = func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error) {
+				return &runnerDevice, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("PerformAction", ctx, instanceID).Return(metal.ApiPerformActionRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecutePerformAction = func(r metal.ApiPerformActionRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			parked, err := a.parkIfRoom(ctx, runnerDevice)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedParked, parked)
+			if tt.expectedParked {
+				cli.AssertCalled(t, "UpdateDevice", ctx, instanceID)
+			} else {
+				cli.AssertNotCalled(t, "UpdateDevice", ctx, instanceID)
+			}
+			if tt.expectedDeleted {
+				cli.AssertCalled(t, "DeleteDevice", ctx, expiredID)
+			} else {
+				cli.AssertNotCalled(t, "DeleteDevice", ctx, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestPruneIdleDevices(t *testing.T) {
+	ctx := context.Background()
+	expiredID := "2b0a6c1e-4a8f-4c47-9d3b-6a0e5f0b7d21"
+	devices := []metal.Device{
+		parkedTestDevice(expiredID, time.Now().Add(-2*time.Hour)),
+		parkedTestDevice("3f1c2a9b-7e4d-4b8a-8c6f-1d2e3f4a5b6c", time.Now()),
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		t.Run(map[bool]string{true: "dry run", false: "prune"}[dryRun], func(t *testing.T) {
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli: cli,
+				cfg: &config.Config{
+					ProjectID:   "project",
+					StateDir:    t.TempDir(),
+					MaxIdleTime: time.Hour,
+				},
+				controllerID: "mock-controller-id",
+			}
+			cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("FindDeviceById", ctx, expiredID).Return(metal.ApiFindDeviceByIdRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				return &devices[0], &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("DeleteDevice", ctx, expiredID).Return(metal.ApiDeleteDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNoContent}, nil
+			}
+
+			pruned, err := a.PruneIdleDevices(ctx, dryRun)
+			require.NoError(t, err)
+			require.Len(t, pruned, 1)
+			assert.Equal(t, expiredID, pruned[0].ID)
+			if dryRun {
+				cli.AssertNotCalled(t, "DeleteDevice", ctx, expiredID)
+			} else {
+				cli.AssertCalled(t, "DeleteDevice", ctx, expiredID)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// OperationPhase is the phase of a CreateInstance operation, as recorded in the
+// operation journal.
+type OperationPhase string
+
+const (
+	// OperationPhaseCreating is set while the device of the runner is being
+	// created or claimed.
+	OperationPhaseCreating OperationPhase = "creating"
+	// OperationPhaseProvisioning is set once the device exists, while waiting for
+	// it to become active.
+	OperationPhaseProvisioning OperationPhase = "provisioning"
+)
+
+// journalGracePeriod is added to the provisioning timeout to decide that an
+// operation was interrupted, to account for the time spent creating the device.
+const journalGracePeriod = 10 * time.Minute
+
+// Operation is a CreateInstance operation recorded in the operation journal. The
+// record is removed when CreateInstance returns, so a record that outlives the
+// provisioning timeout belongs to a provider process that was killed.
+type Operation struct {
+	RunnerName   string         `json:"runner_name"`
+	ControllerID string         `json:"controller_id"`
+	PoolID       string         `json:"pool_id"`
+	DeviceID     string         `json:"device_id,omitempty"`
+	Phase        OperationPhase `json:"phase"`
+	StartedAt    time.Time      `json:"started_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// interrupted returns true if the process running the operation must have given
+// up on it by now.
+func (o Operation) interrupted(provisioningTimeout time.Duration, now time.Time) bool {
+	return now.Sub(o.UpdatedAt) > provisioningTimeout+journalGracePeriod
+}
+
+func (a *equinixProvider) journalDir() (string, error) {
+	return a.statePath("journal")
+}
+
+func (a *equinixProvider) operationPath(controllerID, runnerName string) (string, error) {
+	return a.statePath("journal", url.PathEscape(controllerID+"_"+runnerName)+".json")
+}
+
+// loadOperation returns the operation recorded for a runner of the controller, or
+// nil if there is none.
+func (a *equinixProvider) loadOperation(runnerName string) (*Operation, error) {
+	path, err := a.operationPath(a.controllerID, runnerName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read operation journal: %w", err)
+	}
+	var op Operation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, fmt.Errorf("failed to decode operation journal: %w", err)
+	}
+	return &op, nil
+}
+
+func (a *equinixProvider) saveOperation(op *Operation) error {
+	op.UpdatedAt = time.Now()
+	data, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("failed to encode operation: %w", err)
+	}
+	dir, err := a.journalDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	path, err := a.operationPath(op.ControllerID, op.RunnerName)
+	if err != nil {
+		return err
+	}
+	if err := filelock.WriteFileAtomic(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write operation journal: %w", err)
+	}
+	return nil
+}
+
+func (a *equinixProvider) removeOperation(op Operation) error {
+	path, err := a.operationPath(op.ControllerID, op.RunnerName)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove operation from journal: %w", err)
+	}
+	return nil
+}
+
+// listOperations returns the operations recorded for the controller, or for all
+// controllers if no controller ID is set. Unreadable records are skipped.
+func (a *equinixProvider) listOperations() ([]Operation, error) {
+	dir, err := a.journalDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read journal directory: %w", err)
+	}
+
+	var ret []Operation
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var op Operation
+		if err := json.Unmarshal(data, &op); err != nil {
+			continue
+		}
+		if a.controllerID != "" && op.ControllerID != a.controllerID {
+			continue
+		}
+		ret = append(ret, op)
+	}
+	return ret, nil
+}
+
+// interruptedOperations returns the operations of the journal that were
+// interrupted.
+func (a *equinixProvider) interruptedOperations(now time.Time) ([]Operation, error) {
+	ops, err := a.listOperations()
+	if err != nil {
+		return nil, err
+	}
+	var ret []Operation
+	for _, op := range ops {
+		if op.interrupted(a.cfg.GetProvisioningTimeout(), now) {
+			ret = append(ret, op)
+		}
+	}
+	return ret, nil
+}
+
+// startOperation records in the journal that a runner is being created. If an
+// earlier operation for the runner was interrupted, it is returned as previous,
+// and its start time and device are kept, so that the device can be resumed. The
+// journal is best effort: failing to write it is logged, and must not fail the
+// creation of the runner.
+func (a *equinixProvider) startOperation(poolID, runnerName string) (op, previous *Operation) {
+	op = &Operation{
+		RunnerName:   runnerName,
+		ControllerID: a.controllerID,
+		PoolID:       poolID,
+		Phase:        OperationPhaseCreating,
+		StartedAt:    time.Now(),
+	}
+	// An unreadable record is replaced.
+	if loaded, err := a.loadOperation(runnerName); err == nil && loaded != nil {
+		previous = loaded
+		op.StartedAt = previous.StartedAt
+		op.DeviceID = previous.DeviceID
+		op.Phase = previous.Phase
+	}
+	if err := a.saveOperation(op); err != nil {
+		log.Printf("runner %s: failed to record operation: %s", runnerName, err)
+	}
+	return op, previous
+}
+
+// setOperationDevice records the device an operation waits for. Failing to
+// update the journal is logged: the device can still be found by its tags.
+func (a *equinixProvider) setOperationDevice(op *Operation, deviceID string) {
+	op.DeviceID = deviceID
+	op.Phase = OperationPhaseProvisioning
+	if err := a.saveOperation(op); err != nil {
+		log.Printf("runner %s: failed to record device %s: %s", op.RunnerName, deviceID, err)
+	}
+}
+
+// finishOperation removes an operation from the journal once CreateInstance
+// returns. Operations interrupted by ctx being done, which happens when the
+// provider process is asked to stop, are kept so that they can be resumed.
+func (a *equinixProvider) finishOperation(ctx context.Context, op *Operation) {
+	if ctx.Err() != nil {
+		return
+	}
+	_ = a.removeOperation(*op)
+}
+
+// journaledDevice returns the device recorded in the journal by an operation, if
+// it still exists and was not handed to another runner since.
+func (a *equinixProvider) journaledDevice(ctx context.Context, op *Operation) (*metal.Device, error) {
+	if op == nil || op.DeviceID == "" {
+		return nil, nil
+	}
+	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, op.DeviceID))
+	if err != nil {
+		if isNotFoundResponse(resp) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find device %s: %w", op.DeviceID, err)
+	}
+	if device == nil || !servesRunner(*device, op.RunnerName, a.cfg.GetTagNames()) {
+		return nil, nil
+	}
+	return device, nil
+}
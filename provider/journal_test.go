@@ -0,0 +1,297 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeOperation writes an operation to the journal as is, without updating its
+// update time.
+func writeOperation(t *testing.T, a *equinixProvider, op Operation) {
+	data, err := json.Marshal(op)
+	require.NoError(t, err)
+	dir, err := a.journalDir()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	path, err := a.operationPath(op.ControllerID, op.RunnerName)
+	require.NoError(t, err)
+	require.NoError(t, filelock.WriteFileAtomic(path, data, 0o600))
+}
+
+func TestOperationJournal(t *testing.T) {
+	a := &equinixProvider{
+		cfg:          &config.Config{StateDir: t.TempDir()},
+		controllerID: "mock-controller-id",
+	}
+
+	op, previous := a.startOperation("pool-1", "runner-1")
+	assert.Nil(t, previous)
+	assert.Equal(t, OperationPhaseCreating, op.Phase)
+
+	a.setOperationDevice(op, "device-1")
+	loaded, err := a.loadOperation("runner-1")
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	assert.Equal(t, "device-1", loaded.DeviceID)
+	assert.Equal(t, OperationPhaseProvisioning, loaded.Phase)
+
+	// The provider process is asked to stop while waiting for the device.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.finishOperation(ctx, op)
+	ops, err := a.listOperations()
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+
+	// The next CreateInstance for the runner picks up the device.
+	resumed, previous := a.startOperation("pool-1", "runner-1")
+	require.NotNil(t, previous)
+	assert.Equal(t, "device-1", resumed.DeviceID)
+	assert.Equal(t, op.StartedAt.Unix(), resumed.StartedAt.Unix())
+
+	a.finishOperation(context.Background(), resumed)
+	loaded, err = a.loadOperation("runner-1")
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+func TestOperationJournalBestEffort(t *testing.T) {
+	// The state directory can not be created.
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	a := &equinixProvider{
+		cfg:          &config.Config{StateDir: file},
+		controllerID: "mock-controller-id",
+	}
+
+	op, _ := a.startOperation("pool-1", "runner-1")
+	require.NotNil(t, op)
+	assert.Equal(t, "runner-1", op.RunnerName)
+	a.setOperationDevice(op, "device-1")
+	assert.Equal(t, "device-1", op.DeviceID)
+	a.finishOperation(context.Background(), op)
+}
+
+func TestCreateInstanceResumesJournaledDevice(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			AuthToken: "token",
+			MetroCode: "AM",
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+		},
+		controllerID: "mock-controller-id",
+	}
+	bootstrapParams := params.BootstrapInstance{
+		Name:          "test-instance",
+		InstanceToken: "test-token",
+		OSArch:        params.Amd64,
+		OSType:        params.Linux,
+		Image:         "ubuntu_22_04",
+		Flavor:        "c3.small.x86",
+		Tools: []params.RunnerApplicationDownload{
+			{
+				OS:           spec.Ptr("linux"),
+				Architecture: spec.Ptr("x64"),
+				DownloadURL:  spec.Ptr("http://test.com"),
+				Filename:     spec.Ptr("runner.tar.gz"),
+			},
+		},
+		PoolID: "test-pool",
+	}
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return bootstrapParams.Tools[0], nil
+	}
+	journaled := metal.Device{
+		Id: spec.Ptr("journaled-id"),
+		Tags: []string{
+			"Name=test-instance",
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"OSType=linux",
+			"OSArch=amd64",
+		},
+		State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+	}
+	writeOperation(t, a, Operation{
+		RunnerName:   "test-instance",
+		ControllerID: "mock-controller-id",
+		PoolID:       "test-pool",
+		DeviceID:     "journaled-id",
+		Phase:        OperationPhaseProvisioning,
+		StartedAt:    time.Now().Add(-time.Minute),
+		UpdatedAt:    time.Now().Add(-time.Minute),
+	})
+	cli.On("FindDeviceById", ctx, "journaled-id").Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &journaled, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	DefaultExecuteCreateDevice = func(r metal.ApiCreateDeviceRequest) (*metal.Device, *http.Response, error) {
+		t.Fatal("CreateDevice must not be called when a device was journaled")
+		return nil, nil, nil
+	}
+
+	output, err := a.CreateInstance(ctx, bootstrapParams)
+	require.NoError(t, err)
+	assert.Equal(t, "journaled-id", output.ProviderID)
+	// The device is resumed without listing the devices of the project.
+	cli.AssertNotCalled(t, "FindProjectDevices", ctx, "project")
+
+	op, err := a.loadOperation("test-instance")
+	require.NoError(t, err)
+	assert.Nil(t, op, "the operation must be removed once finished")
+}
+
+func TestDeleteInstanceDeletesJournaledDevice(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+		},
+		controllerID: "mock-controller-id",
+	}
+	deviceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	writeOperation(t, a, Operation{
+		RunnerName:   "runner-1",
+		ControllerID: "mock-controller-id",
+		DeviceID:     deviceID,
+		Phase:        OperationPhaseProvisioning,
+	})
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("FindDeviceById", ctx, deviceID).Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &metal.Device{
+			Id:    spec.Ptr(deviceID),
+			State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("DeleteDevice", ctx, deviceID).Return(metal.ApiDeleteDeviceRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	deleted := 0
+	DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+		deleted++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	require.NoError(t, a.DeleteInstance(ctx, "runner-1"))
+	assert.Equal(t, 1, deleted)
+	op, err := a.loadOperation("runner-1")
+	require.NoError(t, err)
+	assert.Nil(t, op)
+}
+
+func TestFindOrphansReportsInterruptedOperations(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	devices := adminTestDevices(now)
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+		},
+		controllerID: "mock-controller-id",
+	}
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	stale := now.Add(-2 * config.DefaultProvisioningTimeout)
+	// The device of runner-1 would not be an orphan otherwise.
+	writeOperation(t, a, Operation{
+		RunnerName:   "runner-1",
+		ControllerID: "mock-controller-id",
+		PoolID:       "pool-1",
+		DeviceID:     devices[0].GetId(),
+		Phase:        OperationPhaseProvisioning,
+		StartedAt:    stale,
+		UpdatedAt:    stale,
+	})
+	// The process was killed before the device of runner-9 was created.
+	writeOperation(t, a, Operation{
+		RunnerName:   "runner-9",
+		ControllerID: "mock-controller-id",
+		PoolID:       "pool-1",
+		Phase:        OperationPhaseCreating,
+		StartedAt:    stale,
+		UpdatedAt:    stale,
+	})
+	// Still running.
+	writeOperation(t, a, Operation{
+		RunnerName:   "runner-10",
+		ControllerID: "mock-controller-id",
+		PoolID:       "pool-1",
+		Phase:        OperationPhaseCreating,
+		StartedAt:    now,
+		UpdatedAt:    now,
+	})
+
+	orphans, err := a.FindOrphans(ctx, config.OrphansConfig{})
+	require.NoError(t, err)
+	interrupted := map[string]Orphan{}
+	for _, orphan := range orphans {
+		if orphan.Reason == OrphanReasonInterrupted {
+			interrupted[orphan.Name] = orphan
+		}
+	}
+	require.Len(t, interrupted, 2)
+	assert.Equal(t, devices[0].GetId(), interrupted["runner-1"].ID)
+	require.NotNil(t, interrupted["runner-1"].Operation)
+	assert.Equal(t, OperationPhaseProvisioning, interrupted["runner-1"].Operation.Phase)
+	assert.Empty(t, interrupted["runner-9"].ID)
+	assert.Equal(t, "pool-1", interrupted["runner-9"].PoolID)
+
+	// The reported operations identify their journal records.
+	orphan := interrupted["runner-9"]
+	require.NoError(t, a.removeOperation(*orphan.Operation))
+	ops, err := a.listOperations()
+	require.NoError(t, err)
+	assert.Len(t, ops, 2)
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+const (
+	// metadataKey is the key of the GARM metadata record in the customdata of
+	// devices.
+	metadataKey = "garm"
+	// metadataVersion is the version of the GARM metadata records written by the
+	// provider.
+	metadataVersion = 1
+)
+
+// deviceMetadata is the record of the GARM runner of a device, stored in the
+// customdata of the device. Unlike tags, the customdata is not shown for editing in
+// the Equinix Metal console, so the record survives manual tag edits.
+type deviceMetadata struct {
+	Version         int           `json:"version"`
+	ControllerID    string        `json:"controller_id,omitempty"`
+	PoolID          string        `json:"pool_id,omitempty"`
+	RunnerName      string        `json:"runner_name,omitempty"`
+	OSType          params.OSType `json:"os_type,omitempty"`
+	OSArch          params.OSArch `json:"os_arch,omitempty"`
+	ProviderVersion string        `json:"provider_version,omitempty"`
+	// ClaimedAt is the time the device was assigned to its runner. Recycled and
+	// warm devices are assigned to runners long after they are created.
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+	// ParkedAt is the time a recycled device was parked for reuse by its pool.
+	ParkedAt *time.Time `json:"parked_at,omitempty"`
+}
+
+// newDeviceMetadata returns the metadata record of a device created or claimed by
+// this provider. The runner fields are left empty for standby devices.
+func (a *equinixProvider) newDeviceMetadata(poolID string, bootstrapParams *params.BootstrapInstance) deviceMetadata {
+	meta := deviceMetadata{
+		Version:         metadataVersion,
+		ControllerID:    a.controllerID,
+		PoolID:          poolID,
+		ProviderVersion: Version,
+	}
+	if bootstrapParams != nil {
+		meta.RunnerName = bootstrapParams.Name
+		meta.OSType = bootstrapParams.OSType
+		meta.OSArch = bootstrapParams.OSArch
+		claimedAt := time.Now().UTC()
+		meta.ClaimedAt = &claimedAt
+	}
+	return meta
+}
+
+// customdata returns the device customdata holding the metadata record.
+func (m deviceMetadata) customdata() map[string]interface{} {
+	record := map[string]interface{}{}
+	// The record only holds strings, integers and timestamps, which always marshal.
+	data, _ := json.Marshal(m)
+	_ = json.Unmarshal(data, &record)
+	return map[string]interface{}{metadataKey: record}
+}
+
+// parseDeviceMetadata returns the metadata record held in the customdata of a
+// device.
+func parseDeviceMetadata(customdata map[string]interface{}) (deviceMetadata, error) {
+	record, ok := customdata[metadataKey]
+	if !ok {
+		return deviceMetadata{}, fmt.Errorf("no metadata record")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return deviceMetadata{}, fmt.Errorf("failed to marshal metadata record: %w", err)
+	}
+	var meta deviceMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return deviceMetadata{}, fmt.Errorf("invalid metadata record: %w", err)
+	}
+	if meta.Version != metadataVersion {
+		return deviceMetadata{}, fmt.Errorf("unsupported metadata record version %d", meta.Version)
+	}
+	return meta, nil
+}
+
+// deviceMetadataOf returns the GARM metadata of a device. The metadata record in
+// the customdata of the device is preferred. Fields missing from it, or all fields
+// if the record is missing or malformed, are read from the tags of the device, as
+// devices created by older versions of the provider only have tags.
+func deviceMetadataOf(device metal.Device, names spec.TagNames) deviceMetadata {
+	meta, err := parseDeviceMetadata(device.GetCustomdata())
+	if err != nil {
+		meta = deviceMetadata{}
+	}
+
+	tags := extractTagsAsMap(device)
+	if meta.ControllerID == "" {
+		meta.ControllerID = tags[names.ControllerID]
+	}
+	if meta.PoolID == "" {
+		meta.PoolID = tags[names.PoolID]
+	}
+	if meta.RunnerName == "" {
+		meta.RunnerName = tags[names.Name]
+	}
+	if meta.OSType == "" {
+		meta.OSType = params.OSType(tags[names.OSType])
+	}
+	if meta.OSArch == "" {
+		meta.OSArch = params.OSArch(tags[names.OSArch])
+	}
+	return meta
+}
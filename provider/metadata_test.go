@@ -0,0 +1,275 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceMetadataCustomdata(t *testing.T) {
+	a := &equinixProvider{controllerID: "controller-1"}
+	meta := a.newDeviceMetadata("pool-1", &params.BootstrapInstance{
+		Name:   "runner-1",
+		OSType: params.Linux,
+		OSArch: params.Arm64,
+	})
+
+	require.NotNil(t, meta.ClaimedAt)
+
+	customdata := meta.customdata()
+	assert.Equal(t, map[string]interface{}{
+		"version":          float64(metadataVersion),
+		"controller_id":    "controller-1",
+		"pool_id":          "pool-1",
+		"runner_name":      "runner-1",
+		"os_type":          "linux",
+		"os_arch":          "arm64",
+		"provider_version": Version,
+		"claimed_at":       meta.ClaimedAt.Format(time.RFC3339Nano),
+	}, customdata[metadataKey])
+
+	parsed, err := parseDeviceMetadata(customdata)
+	require.NoError(t, err)
+	assert.Equal(t, meta, parsed)
+
+	standby := a.newDeviceMetadata("pool-1", nil)
+	assert.Equal(t, map[string]interface{}{
+		"version":          float64(metadataVersion),
+		"controller_id":    "controller-1",
+		"pool_id":          "pool-1",
+		"provider_version": Version,
+	}, standby.customdata()[metadataKey])
+}
+
+func TestParseDeviceMetadata(t *testing.T) {
+	tests := []struct {
+		name       string
+		customdata map[string]interface{}
+		errString  string
+	}{
+		{
+			name:       "no customdata",
+			customdata: nil,
+			errString:  "no metadata record",
+		},
+		{
+			name:       "other customdata",
+			customdata: map[string]interface{}{"owner": "ci"},
+			errString:  "no metadata record",
+		},
+		{
+			name:       "malformed record",
+			customdata: map[string]interface{}{metadataKey: "runner-1"},
+			errString:  "invalid metadata record",
+		},
+		{
+			name:       "unsupported version",
+			customdata: map[string]interface{}{metadataKey: map[string]interface{}{"version": 99}},
+			errString:  "unsupported metadata record version 99",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDeviceMetadata(tt.customdata)
+			assert.ErrorContains(t, err, tt.errString)
+		})
+	}
+}
+
+func TestDeviceMetadataOf(t *testing.T) {
+	tags := []string{
+		"Name=tag-runner",
+		"garm-pool-id=tag-pool",
+		"garm-controller-id=tag-controller",
+		"OSType=windows",
+		"OSArch=amd64",
+	}
+	tests := []struct {
+		name     string
+		device   metal.Device
+		expected deviceMetadata
+	}{
+		{
+			name: "record preferred over tags",
+			device: metal.Device{
+				Tags: tags,
+				Customdata: map[string]interface{}{metadataKey: map[string]interface{}{
+					"version":       1,
+					"controller_id": "controller-1",
+					"pool_id":       "pool-1",
+					"runner_name":   "runner-1",
+					"os_type":       "linux",
+					"os_arch":       "arm64",
+				}},
+			},
+			expected: deviceMetadata{
+				Version:      metadataVersion,
+				ControllerID: "controller-1",
+				PoolID:       "pool-1",
+				RunnerName:   "runner-1",
+				OSType:       params.Linux,
+				OSArch:       params.Arm64,
+			},
+		},
+		{
+			name: "missing fields read from tags",
+			device: metal.Device{
+				Tags: tags,
+				Customdata: map[string]interface{}{metadataKey: map[string]interface{}{
+					"version":       1,
+					"controller_id": "controller-1",
+					"pool_id":       "pool-1",
+				}},
+			},
+			expected: deviceMetadata{
+				Version:      metadataVersion,
+				ControllerID: "controller-1",
+				PoolID:       "pool-1",
+				RunnerName:   "tag-runner",
+				OSType:       params.Windows,
+				OSArch:       params.Amd64,
+			},
+		},
+		{
+			name: "malformed record ignored",
+			device: metal.Device{
+				Tags:       tags,
+				Customdata: map[string]interface{}{metadataKey: []interface{}{"runner-1"}},
+			},
+			expected: deviceMetadata{
+				ControllerID: "tag-controller",
+				PoolID:       "tag-pool",
+				RunnerName:   "tag-runner",
+				OSType:       params.Windows,
+				OSArch:       params.Amd64,
+			},
+		},
+		{
+			name:     "no record nor tags",
+			device:   metal.Device{},
+			expected: deviceMetadata{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, deviceMetadataOf(tt.device, spec.LegacyTagNames))
+		})
+	}
+}
+
+func TestDeviceMetadataOfWithTagPrefix(t *testing.T) {
+	device := metal.Device{
+		Tags: []string{
+			"Name=web-1",
+			"garm.example.com/name=runner-1",
+			"garm.example.com/pool-id=pool-1",
+			"garm.example.com/controller-id=controller-1",
+		},
+	}
+	assert.Equal(t, deviceMetadata{
+		ControllerID: "controller-1",
+		PoolID:       "pool-1",
+		RunnerName:   "runner-1",
+	}, deviceMetadataOf(device, spec.NewTagNames("garm.example.com/")))
+}
+
+func TestListInstancesSkipsMalformedDevices(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			AuthToken: "token",
+			MetroCode: "AM",
+			ProjectID: "project",
+		},
+		controllerID: "controller-1",
+	}
+	record := func(name string) map[string]interface{} {
+		return map[string]interface{}{metadataKey: map[string]interface{}{
+			"version":       1,
+			"controller_id": "controller-1",
+			"pool_id":       "pool-1",
+			"runner_name":   name,
+			"os_type":       "linux",
+			"os_arch":       "amd64",
+		}}
+	}
+	devices := metal.DeviceList{
+		Devices: []metal.Device{
+			{
+				// The tags of this device were removed by hand.
+				Id:         spec.Ptr("device-1"),
+				Customdata: record("runner-1"),
+				State:      spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			},
+			{
+				// This device has no runner name, neither in its record nor in its tags.
+				Id:         spec.Ptr("device-2"),
+				Tags:       []string{"Nmae=runner-2"},
+				Customdata: record(""),
+				State:      spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			},
+			{
+				Id: spec.Ptr("device-3"),
+				Tags: []string{
+					"Name=runner-3",
+					"garm-pool-id=pool-1",
+					"garm-controller-id=controller-1",
+					"OSType=linux",
+					"OSArch=amd64",
+				},
+				State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			},
+		},
+	}
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &devices, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	output, err := a.ListInstances(ctx, "pool-1")
+	require.NoError(t, err)
+	assert.Equal(t, []params.ProviderInstance{
+		{
+			ProviderID: "device-1",
+			Name:       "runner-1",
+			OSType:     params.Linux,
+			OSArch:     params.Amd64,
+			Status:     params.InstanceRunning,
+		},
+		{
+			ProviderID: "device-3",
+			Name:       "runner-3",
+			OSType:     params.Linux,
+			OSArch:     params.Amd64,
+			Status:     params.InstanceRunning,
+		},
+	}, output)
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/metrics"
+	"github.com/cloudbase/garm-provider-equinix/internal/tracing"
+)
+
+// isCapacityError returns true if a device could not be created because the
+// requested plan is out of stock in the metro.
+func isCapacityError(resp *http.Response, err error) bool {
+	if resp != nil && resp.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "capacity") || strings.Contains(msg, "out of stock")
+}
+
+// provisioningFailureReason returns the reason a device failed to provision, as
+// reported in the metrics.
+func provisioningFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota"
+	case errors.Is(err, errNoCapacity):
+		return "capacity"
+	case errors.Is(err, errCreateDevice):
+		return "create_error"
+	case errors.Is(err, errDeviceFailed):
+		return "device_failed"
+	case errors.Is(err, errDeviceDeleted):
+		return "device_deleted"
+	case errors.Is(err, errProvisioningTimeout):
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// recordProvisioning records the time it took for a device to become active, or
+// the reason it failed to.
+func (a *equinixProvider) recordProvisioning(metro, plan string, start time.Time, err error) {
+	metro = strings.ToLower(metro)
+	if err != nil {
+		a.metrics.IncProvisioningFailure(metro, plan, provisioningFailureReason(err))
+		return
+	}
+	a.metrics.ObserveTimeToActive(metro, plan, time.Since(start))
+}
+
+// Tracer returns the tracer of the provider, which is nil if tracing is disabled.
+func (a *equinixProvider) Tracer() *tracing.Tracer {
+	return a.tracer
+}
+
+// Metrics returns the registry holding the metrics recorded by the provider.
+func (a *equinixProvider) Metrics() *metrics.Registry {
+	return a.metrics
+}
+
+// FlushTelemetry merges the metrics recorded so far into the configured textfile,
+// and exports the spans ended so far.
+func (a *equinixProvider) FlushTelemetry(ctx context.Context) error {
+	var errs []error
+	if a.cfg.Metrics.Textfile != "" {
+		if err := a.metrics.MergeTextfile(a.cfg.Metrics.Textfile); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write metrics: %w", err))
+		}
+	}
+	if err := a.tracer.Flush(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCapacityError(t *testing.T) {
+	assert.True(t, isCapacityError(&http.Response{StatusCode: http.StatusServiceUnavailable}, fmt.Errorf("503 Service Unavailable")))
+	assert.True(t, isCapacityError(nil, fmt.Errorf("422 Unprocessable Entity: not enough capacity in metro da")))
+	assert.False(t, isCapacityError(&http.Response{StatusCode: http.StatusUnauthorized}, fmt.Errorf("401 Unauthorized")))
+	assert.False(t, isCapacityError(nil, nil))
+}
+
+func TestProvisioningFailureReason(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{fmt.Errorf("%w: max_devices is 10", ErrQuotaExceeded), "quota"},
+		{fmt.Errorf("%w: %w: boom", errCreateDevice, errNoCapacity), "capacity"},
+		{fmt.Errorf("%w: boom", errCreateDevice), "create_error"},
+		{fmt.Errorf("failed to wait: %w (fault): %w", errDeviceFailed, errStopRetry), "device_failed"},
+		{fmt.Errorf("failed to wait: %w: %w", errDeviceDeleted, errStopRetry), "device_deleted"},
+		{fmt.Errorf("failed to wait: %w: attempt count exceeded", errProvisioningTimeout), "timeout"},
+		{fmt.Errorf("failed to claim standby device"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, provisioningFailureReason(tt.err))
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// TagMigration holds the tags of a device, renamed from the legacy tag names to
+// the tag names under the configured tag prefix.
+type TagMigration struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name,omitempty"`
+	OldTags []string `json:"old_tags"`
+	NewTags []string `json:"new_tags"`
+	// Migrated is true once the new tags are set on the device.
+	Migrated bool `json:"migrated"`
+}
+
+// FindTagMigrations returns the migrations renaming the tags of the devices created
+// by the controller from the legacy tag names to the tag names under the configured
+// tag prefix.
+func (a *equinixProvider) FindTagMigrations(ctx context.Context) ([]TagMigration, error) {
+	names := a.cfg.GetTagNames()
+	if names == spec.LegacyTagNames {
+		return nil, fmt.Errorf("tag_prefix is not set")
+	}
+
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	migrations := []TagMigration{}
+	for _, device := range devices {
+		// Only the legacy tags identify the devices left to migrate. The metadata
+		// record is ignored, as it does not tell which tags the device has.
+		controllerID, ok := extractTagsAsMap(device)[spec.LegacyTagNames.ControllerID]
+		if !ok || (a.controllerID != "" && controllerID != a.controllerID) {
+			continue
+		}
+		newTags := spec.MigrateTags(device.GetTags(), spec.LegacyTagNames, names)
+		if slices.Equal(newTags, device.GetTags()) {
+			continue
+		}
+		migrations = append(migrations, TagMigration{
+			ID:      device.GetId(),
+			Name:    deviceMetadataOf(device, spec.LegacyTagNames).RunnerName,
+			OldTags: device.GetTags(),
+			NewTags: newTags,
+		})
+	}
+	return migrations, nil
+}
+
+// MigrateTags applies the given migrations, as returned by FindTagMigrations. The
+// devices are not looked up again, so that only the migrations confirmed by the
+// caller are applied. All migrations are attempted, even if some of them fail.
+func (a *equinixProvider) MigrateTags(ctx context.Context, migrations []TagMigration) ([]TagMigration, error) {
+	migrations = slices.Clone(migrations)
+
+	var errs []error
+	for idx, migration := range migrations {
+		_, _, err := DefaultExecuteUpdateDevice(a.cli.UpdateDevice(ctx, migration.ID).DeviceUpdateInput(metal.DeviceUpdateInput{
+			Tags: migration.NewTags,
+		}))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update tags of device %s: %w", migration.ID, err))
+			continue
+		}
+		migrations[idx].Migrated = true
+	}
+	if len(migrations) > 0 {
+		a.invalidateDeviceCache()
+	}
+	return migrations, errors.Join(errs...)
+}
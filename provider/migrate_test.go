@@ -0,0 +1,121 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateTags(t *testing.T) {
+	devices := metal.DeviceList{
+		Devices: []metal.Device{
+			{
+				Id: spec.Ptr("device-1"),
+				Tags: []string{
+					"garm-pool-id=pool-1",
+					"garm-controller-id=controller-1",
+					"OSType=linux",
+					"OSArch=amd64",
+					"Name=runner-1",
+					"team=ci",
+				},
+			},
+			{
+				// Devices of other controllers are left alone.
+				Id:   spec.Ptr("device-2"),
+				Tags: []string{"garm-controller-id=controller-2", "Name=runner-2"},
+			},
+			{
+				// Devices of other tools are left alone.
+				Id:   spec.Ptr("device-3"),
+				Tags: []string{"Name=web-1"},
+			},
+			{
+				// Migrated devices are left alone.
+				Id:   spec.Ptr("device-4"),
+				Tags: []string{"garm.example.com/controller-id=controller-1", "garm.example.com/name=runner-4"},
+			},
+		},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		ctx := context.Background()
+		cli := new(MockClient)
+		a := &equinixProvider{
+			cli: cli,
+			cfg: &config.Config{
+				ProjectID: "project",
+				TagPrefix: "garm.example.com/",
+			},
+			controllerID: "controller-1",
+		}
+		cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+		DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+			return &devices, &http.Response{StatusCode: http.StatusOK}, nil
+		}
+		cli.On("UpdateDevice", ctx, "device-1").Return(metal.ApiUpdateDeviceRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+		DefaultExecuteUpdateDevice = func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error) {
+			return &metal.Device{}, &http.Response{StatusCode: http.StatusOK}, nil
+		}
+
+		migrations, err := a.FindTagMigrations(ctx)
+		require.NoError(t, err)
+		if !dryRun {
+			migrations, err = a.MigrateTags(ctx, migrations)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []TagMigration{
+			{
+				ID:      "device-1",
+				Name:    "runner-1",
+				OldTags: devices.Devices[0].Tags,
+				NewTags: []string{
+					"garm.example.com/pool-id=pool-1",
+					"garm.example.com/controller-id=controller-1",
+					"garm.example.com/os-type=linux",
+					"garm.example.com/os-arch=amd64",
+					"garm.example.com/name=runner-1",
+					"team=ci",
+				},
+				Migrated: !dryRun,
+			},
+		}, migrations)
+		if dryRun {
+			cli.AssertNotCalled(t, "UpdateDevice", ctx, "device-1")
+		} else {
+			cli.AssertCalled(t, "UpdateDevice", ctx, "device-1")
+		}
+	}
+}
+
+func TestMigrateTagsWithoutPrefix(t *testing.T) {
+	a := &equinixProvider{
+		cfg: &config.Config{ProjectID: "project"},
+	}
+	_, err := a.FindTagMigrations(context.Background())
+	assert.ErrorContains(t, err, "tag_prefix is not set")
+}
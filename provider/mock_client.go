@@ -49,3 +49,48 @@ func (m *MockClient) PerformAction(ctx context.Context, id string) metal.ApiPerf
 	args := m.Called(ctx, id)
 	return args.Get(0).(metal.ApiPerformActionRequest)
 }
+
+func (m *MockClient) UpdateDevice(ctx context.Context, id string) metal.ApiUpdateDeviceRequest {
+	args := m.Called(ctx, id)
+	return args.Get(0).(metal.ApiUpdateDeviceRequest)
+}
+
+func (m *MockClient) FindDeviceEvents(ctx context.Context, id string) metal.ApiFindDeviceEventsRequest {
+	args := m.Called(ctx, id)
+	return args.Get(0).(metal.ApiFindDeviceEventsRequest)
+}
+
+func (m *MockClient) FindOperatingSystems(ctx context.Context) metal.ApiFindOperatingSystemsRequest {
+	args := m.Called(ctx)
+	return args.Get(0).(metal.ApiFindOperatingSystemsRequest)
+}
+
+func (m *MockClient) CaptureScreenshot(ctx context.Context, id string) metal.ApiCaptureScreenshotRequest {
+	args := m.Called(ctx, id)
+	return args.Get(0).(metal.ApiCaptureScreenshotRequest)
+}
+
+func (m *MockClient) FindPlansByProject(ctx context.Context, id string) metal.ApiFindPlansByProjectRequest {
+	args := m.Called(ctx, id)
+	return args.Get(0).(metal.ApiFindPlansByProjectRequest)
+}
+
+func (m *MockClient) FindMetroSpotMarketPrices(ctx context.Context) metal.ApiFindMetroSpotMarketPricesRequest {
+	args := m.Called(ctx)
+	return args.Get(0).(metal.ApiFindMetroSpotMarketPricesRequest)
+}
+
+func (m *MockClient) FindSpotMarketPricesHistory(ctx context.Context) metal.ApiFindSpotMarketPricesHistoryRequest {
+	args := m.Called(ctx)
+	return args.Get(0).(metal.ApiFindSpotMarketPricesHistoryRequest)
+}
+
+func (m *MockClient) FindProjectUsage(ctx context.Context, id string) metal.ApiFindProjectUsageRequest {
+	args := m.Called(ctx, id)
+	return args.Get(0).(metal.ApiFindProjectUsageRequest)
+}
+
+func (m *MockClient) CheckCapacityForMetro(ctx context.Context) metal.ApiCheckCapacityForMetroRequest {
+	args := m.Called(ctx)
+	return args.Get(0).(metal.ApiCheckCapacityForMetroRequest)
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// OrphanReason describes why a device is considered an orphan.
+type OrphanReason string
+
+const (
+	// OrphanReasonMissingTags is set on devices that carry the controller tag, but
+	// are missing the pool tag, or the runner name tag if they are not on standby.
+	OrphanReasonMissingTags OrphanReason = "missing_tags"
+	// OrphanReasonUnknownPool is set on devices that belong to a pool which is not
+	// in the list of allowed pools.
+	OrphanReasonUnknownPool OrphanReason = "unknown_pool"
+	// OrphanReasonFailed is set on devices that have been failed or inactive for
+	// longer than the failed grace period.
+	OrphanReasonFailed OrphanReason = "failed"
+	// OrphanReasonMaxAge is set on devices assigned to their runner for longer than
+	// the maximum age.
+	OrphanReasonMaxAge OrphanReason = "max_age_exceeded"
+	// OrphanReasonInterrupted is set on the operations of the journal that were
+	// interrupted, and never resumed, and on their devices.
+	OrphanReasonInterrupted OrphanReason = "interrupted"
+)
+
+// Orphan is a device which is considered to be no longer managed by GARM.
+type Orphan struct {
+	DeviceInfo
+	Reason OrphanReason `json:"reason"`
+	// Operation is the interrupted operation that created the device, if any. The
+	// ID of an orphan is empty if the device of the operation no longer exists,
+	// or was never created.
+	Operation *Operation `json:"operation,omitempty"`
+	// Deleted is set once the orphan was deleted by ReapOrphans.
+	Deleted bool `json:"deleted"`
+}
+
+// classifyOrphan returns the reason for which a device is considered an orphan
+// according to the given rules. The second return value is false if the device
+// is not an orphan.
+func classifyOrphan(device DeviceInfo, rules config.OrphansConfig, now time.Time) (OrphanReason, bool) {
+	if device.PoolID == "" || (device.Name == "" && !device.Idle && !device.Warm) {
+		return OrphanReasonMissingTags, true
+	}
+
+	if len(rules.AllowedPoolIDs) > 0 {
+		allowed := false
+		for _, poolID := range rules.AllowedPoolIDs {
+			if poolID == device.PoolID {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return OrphanReasonUnknownPool, true
+		}
+	}
+
+	switch metal.DeviceState(device.State) {
+	case metal.DEVICESTATE_FAILED, metal.DEVICESTATE_INACTIVE:
+		since := device.UpdatedAt
+		if since.IsZero() {
+			since = device.CreatedAt
+		}
+		if !since.IsZero() && now.Sub(since) > rules.GetFailedGracePeriod() {
+			return OrphanReasonFailed, true
+		}
+	}
+
+	// Standby devices are expected to outlive runners, and recycled devices are
+	// only as old as their runner.
+	if rules.MaxAge > 0 && !device.Idle && !device.Warm && device.RunnerAge(now) > rules.MaxAge {
+		return OrphanReasonMaxAge, true
+	}
+	return "", false
+}
+
+// matchOperation returns the interrupted operation that created a device, if any.
+func matchOperation(device DeviceInfo, ops []Operation) *Operation {
+	for idx, op := range ops {
+		if op.DeviceID != "" && op.DeviceID == device.ID {
+			return &ops[idx]
+		}
+		if op.DeviceID == "" && op.RunnerName == device.Name && op.ControllerID == device.ControllerID {
+			return &ops[idx]
+		}
+	}
+	return nil
+}
+
+// FindOrphans returns the devices created by the controller that are considered
+// orphans according to the configured rules, merged with the given overrides.
+// The operations of the journal that were interrupted are returned as well, with
+// their device if it still exists.
+func (a *equinixProvider) FindOrphans(ctx context.Context, overrides config.OrphansConfig) ([]Orphan, error) {
+	devices, err := a.ListDevices(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ops, err := a.interruptedOperations(now)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := a.cfg.Orphans.Merge(overrides)
+	matched := map[*Operation]bool{}
+	ret := []Orphan{}
+	for _, device := range devices {
+		switch metal.DeviceState(device.State) {
+		case metal.DEVICESTATE_DELETED, metal.DEVICESTATE_DEPROVISIONING:
+			// Already on its way out.
+			continue
+		}
+		op := matchOperation(device, ops)
+		reason, ok := classifyOrphan(device, rules, now)
+		if !ok && op != nil {
+			reason, ok = OrphanReasonInterrupted, true
+		}
+		if !ok {
+			continue
+		}
+		if op != nil {
+			matched[op] = true
+		}
+		ret = append(ret, Orphan{
+			DeviceInfo: device,
+			Reason:     reason,
+			Operation:  op,
+		})
+	}
+
+	for idx, op := range ops {
+		if matched[&ops[idx]] {
+			continue
+		}
+		ret = append(ret, Orphan{
+			DeviceInfo: DeviceInfo{
+				Name:         op.RunnerName,
+				PoolID:       op.PoolID,
+				ControllerID: op.ControllerID,
+				CreatedAt:    op.StartedAt,
+			},
+			Reason:    OrphanReasonInterrupted,
+			Operation: &ops[idx],
+		})
+	}
+	return ret, nil
+}
+
+// ReapOrphans deletes the given orphans, as returned by FindOrphans, and removes
+// their interrupted operations from the journal. The orphans are not looked up
+// again, so that only the orphans confirmed by the caller are deleted. All orphans
+// are attempted, even if some of them fail to be deleted.
+func (a *equinixProvider) ReapOrphans(ctx context.Context, orphans []Orphan) ([]Orphan, error) {
+	orphans = slices.Clone(orphans)
+
+	var errs []error
+	for idx, orphan := range orphans {
+		if orphan.ID != "" {
+			if err := a.deleteOneInstance(ctx, orphan.ID); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete device %s: %w", orphan.ID, err))
+				continue
+			}
+		}
+		if orphan.Operation != nil {
+			if err := a.removeOperation(*orphan.Operation); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove operation of %s: %w", orphan.Operation.RunnerName, err))
+				continue
+			}
+		}
+		orphans[idx].Deleted = true
+	}
+	return orphans, errors.Join(errs...)
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyOrphan(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		device         DeviceInfo
+		rules          config.OrphansConfig
+		expectedReason OrphanReason
+		expectedOrphan bool
+	}{
+		{
+			name: "healthy device",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "active",
+				CreatedAt: now.Add(-time.Hour),
+			},
+			rules:          config.OrphansConfig{AllowedPoolIDs: []string{"pool-1"}, MaxAge: 24 * time.Hour},
+			expectedOrphan: false,
+		},
+		{
+			name: "missing tags",
+			device: DeviceInfo{
+				PoolID: "pool-1",
+				State:  "active",
+			},
+			expectedReason: OrphanReasonMissingTags,
+			expectedOrphan: true,
+		},
+		{
+			name: "parked device",
+			device: DeviceInfo{
+				PoolID: "pool-1",
+				State:  "active",
+				Idle:   true,
+			},
+			expectedOrphan: false,
+		},
+		{
+			name: "unknown pool",
+			device: DeviceInfo{
+				Name:   "runner",
+				PoolID: "pool-2",
+				State:  "active",
+			},
+			rules:          config.OrphansConfig{AllowedPoolIDs: []string{"pool-1"}},
+			expectedReason: OrphanReasonUnknownPool,
+			expectedOrphan: true,
+		},
+		{
+			name: "failed within the grace period",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "failed",
+				CreatedAt: now.Add(-3 * time.Hour),
+				UpdatedAt: now.Add(-time.Hour),
+			},
+			expectedOrphan: false,
+		},
+		{
+			name: "failed beyond the grace period",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "inactive",
+				CreatedAt: now.Add(-3 * time.Hour),
+			},
+			expectedReason: OrphanReasonFailed,
+			expectedOrphan: true,
+		},
+		{
+			name: "max age exceeded",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "active",
+				CreatedAt: now.Add(-48 * time.Hour),
+			},
+			rules:          config.OrphansConfig{MaxAge: 24 * time.Hour},
+			expectedReason: OrphanReasonMaxAge,
+			expectedOrphan: true,
+		},
+		{
+			name: "recycled device claimed recently",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "active",
+				CreatedAt: now.Add(-48 * time.Hour),
+				ClaimedAt: now.Add(-time.Hour),
+			},
+			rules:          config.OrphansConfig{MaxAge: 24 * time.Hour},
+			expectedOrphan: false,
+		},
+		{
+			name: "recycled device claimed long ago",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "active",
+				CreatedAt: now.Add(-72 * time.Hour),
+				ClaimedAt: now.Add(-48 * time.Hour),
+			},
+			rules:          config.OrphansConfig{MaxAge: 24 * time.Hour},
+			expectedReason: OrphanReasonMaxAge,
+			expectedOrphan: true,
+		},
+		{
+			name: "parked device older than the max age",
+			device: DeviceInfo{
+				PoolID:    "pool-1",
+				State:     "active",
+				Idle:      true,
+				CreatedAt: now.Add(-48 * time.Hour),
+			},
+			rules:          config.OrphansConfig{MaxAge: 24 * time.Hour},
+			expectedOrphan: false,
+		},
+		{
+			name: "warm device older than the max age",
+			device: DeviceInfo{
+				PoolID:    "pool-1",
+				State:     "active",
+				Warm:      true,
+				CreatedAt: now.Add(-48 * time.Hour),
+			},
+			rules:          config.OrphansConfig{MaxAge: 24 * time.Hour},
+			expectedOrphan: false,
+		},
+		{
+			name: "no max age",
+			device: DeviceInfo{
+				Name:      "runner",
+				PoolID:    "pool-1",
+				State:     "active",
+				CreatedAt: now.Add(-48 * time.Hour),
+			},
+			expectedOrphan: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := classifyOrphan(tt.device, tt.rules, now)
+			assert.Equal(t, tt.expectedOrphan, ok)
+			assert.Equal(t, tt.expectedReason, reason)
+		})
+	}
+}
+
+func TestReapOrphans(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	devices := adminTestDevices(now)
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+			Orphans: config.OrphansConfig{
+				AllowedPoolIDs: []string{"pool-1"},
+			},
+		},
+		controllerID: "mock-controller-id",
+	}
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	orphanID := devices[1].GetId()
+	cli.On("FindDeviceById", ctx, orphanID).Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &devices[1], &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("DeleteDevice", ctx, orphanID).Return(metal.ApiDeleteDeviceRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	deleted := 0
+	DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+		deleted++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	orphans, err := a.FindOrphans(ctx, config.OrphansConfig{})
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, orphanID, orphans[0].ID)
+	assert.Equal(t, OrphanReasonUnknownPool, orphans[0].Reason)
+
+	// Only the given orphans are deleted.
+	reaped, err := a.ReapOrphans(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, reaped)
+	assert.Equal(t, 0, deleted)
+
+	reaped, err = a.ReapOrphans(ctx, orphans)
+	require.NoError(t, err)
+	require.Len(t, reaped, 1)
+	assert.True(t, reaped[0].Deleted)
+	assert.False(t, orphans[0].Deleted)
+	assert.Equal(t, 1, deleted)
+
+	// Overrides replace the configured rules.
+	found, err := a.FindOrphans(ctx, config.OrphansConfig{AllowedPoolIDs: []string{"pool-1", "pool-2"}, FailedGracePeriod: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return nil, &http.Response{StatusCode: http.StatusInternalServerError}, fmt.Errorf("boom")
+	}
+	reaped, err = a.ReapOrphans(ctx, orphans)
+	assert.ErrorContains(t, err, "failed to delete device "+orphanID)
+	require.Len(t, reaped, 1)
+	assert.False(t, reaped[0].Deleted)
+}
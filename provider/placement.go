@@ -0,0 +1,82 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// spotOffer is the spot price of a plan in a metro.
+type spotOffer struct {
+	Metro string
+	Price float64
+}
+
+// cheapestSpotMetro returns the metro with the cheapest spot price for the plan,
+// out of the given metros, which has capacity for it. Metros in which the plan
+// is not sold on the spot market, or whose price is above the max bid, are
+// skipped.
+func (a *equinixProvider) cheapestSpotMetro(ctx context.Context, runnerName, plan string, metros []string, maxPrice *float64) (spotOffer, error) {
+	var offers []spotOffer
+	seen := map[string]bool{}
+	for _, metro := range metros {
+		metro = strings.ToLower(metro)
+		if seen[metro] {
+			continue
+		}
+		seen[metro] = true
+		prices, err := a.spotPrices(ctx, metro)
+		if err != nil {
+			log.Printf("runner %s: skipping metro %s: %s", runnerName, metro, err)
+			continue
+		}
+		price, ok := prices[plan]
+		if !ok {
+			continue
+		}
+		if maxPrice != nil && price > *maxPrice {
+			log.Printf("runner %s: skipping metro %s: spot price %.4f of plan %s is above the max bid %.4f", runnerName, metro, price, plan, *maxPrice)
+			continue
+		}
+		offers = append(offers, spotOffer{Metro: metro, Price: price})
+	}
+	// The order of the metros breaks ties.
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].Price < offers[j].Price
+	})
+
+	for _, offer := range offers {
+		if available, err := a.hasCapacity(ctx, offer.Metro, plan); err == nil && !available {
+			log.Printf("runner %s: plan %s is out of stock in metro %s", runnerName, plan, offer.Metro)
+			continue
+		}
+		return offer, nil
+	}
+	return spotOffer{}, fmt.Errorf("%w: no metro of %s has spot capacity for plan %s within the max bid", errNoCapacity, strings.Join(metros, ", "), plan)
+}
+
+// withSpotMetro returns the input of a device placed in the given metro, tagged
+// with the metro.
+func (a *equinixProvider) withSpotMetro(input metal.DeviceCreateInMetroInput, metro string) metal.DeviceCreateInMetroInput {
+	input.Metro = metro
+	input.Tags = append(append([]string{}, input.Tags...), fmt.Sprintf("%s=%s", a.cfg.GetTagNames().Metro, metro))
+	return input
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheapestSpotMetro(t *testing.T) {
+	tests := []struct {
+		name     string
+		metros   []string
+		maxPrice *float64
+		// outOfStock is the number of capacity checks reporting that the plan
+		// is out of stock. Metros are checked from the cheapest.
+		outOfStock int
+		expected   spotOffer
+		errString  string
+	}{
+		{
+			name:     "cheapest metro",
+			metros:   []string{"AM", "DA", "FR"},
+			expected: spotOffer{Metro: "da", Price: 0.21},
+		},
+		{
+			name:       "cheapest metro out of stock",
+			metros:     []string{"am", "da", "fr"},
+			outOfStock: 1,
+			expected:   spotOffer{Metro: "fr", Price: 0.35},
+		},
+		{
+			name:     "metros without a price are skipped",
+			metros:   []string{"sv", "am"},
+			expected: spotOffer{Metro: "am", Price: 0.6},
+		},
+		{
+			name:      "prices above the max bid",
+			metros:    []string{"am", "fr"},
+			maxPrice:  spec.Ptr(0.3),
+			errString: "no capacity available: no metro of am, fr has spot capacity for plan c3.small.x86 within the max bid",
+		},
+		{
+			name:     "price equal to the max bid",
+			metros:   []string{"am", "fr"},
+			maxPrice: spec.Ptr(0.35),
+			expected: spotOffer{Metro: "fr", Price: 0.35},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := new(MockClient)
+			a := &equinixProvider{
+				spot:     cli,
+				capacity: cli,
+				cfg:      &config.Config{ProjectID: "project"},
+			}
+			cli.On("FindMetroSpotMarketPrices", ctx).Return(metal.ApiFindMetroSpotMarketPricesRequest{
+				ApiService: &metal.SpotMarketApiService{},
+			}, nil)
+			DefaultExecuteFindMetroSpotMarketPrices = func(r metal.ApiFindMetroSpotMarketPricesRequest) (*metal.SpotMarketPricesPerMetroList, *http.Response, error) {
+				return &metal.SpotMarketPricesPerMetroList{
+					SpotMarketPrices: &metal.SpotMarketPricesPerMetroReport{
+						AdditionalProperties: map[string]interface{}{
+							"am": map[string]interface{}{
+								"c3.small.x86": map[string]interface{}{"price": 0.6},
+							},
+							"da": map[string]interface{}{
+								"c3.small.x86": map[string]interface{}{"price": 0.21},
+							},
+							"fr": map[string]interface{}{
+								"c3.small.x86": map[string]interface{}{"price": 0.35},
+							},
+							"sv": map[string]interface{}{
+								"m3.small.x86": map[string]interface{}{"price": 0.1},
+							},
+						},
+					},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			checks := 0
+			cli.On("CheckCapacityForMetro", ctx).Return(metal.ApiCheckCapacityForMetroRequest{
+				ApiService: &metal.CapacityApiService{},
+			}, nil)
+			DefaultExecuteCheckCapacityForMetro = func(r metal.ApiCheckCapacityForMetroRequest) (*metal.CapacityCheckPerMetroList, *http.Response, error) {
+				checks++
+				return &metal.CapacityCheckPerMetroList{
+					Servers: []metal.CapacityCheckPerMetroInfo{{Available: spec.Ptr(checks > tt.outOfStock)}},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			offer, err := a.cheapestSpotMetro(ctx, "runner-1", "c3.small.x86", tt.metros, tt.maxPrice)
+			if tt.errString != "" {
+				require.Error(t, err)
+				assert.ErrorContains(t, err, tt.errString)
+				assert.ErrorIs(t, err, errNoCapacity)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, offer)
+		})
+	}
+}
+
+func TestWithSpotMetro(t *testing.T) {
+	a := &equinixProvider{cfg: &config.Config{}}
+	tags := []string{"Name=runner-1"}
+	input := a.withSpotMetro(metal.DeviceCreateInMetroInput{Metro: "AM", Tags: tags}, "da")
+	assert.Equal(t, "da", input.Metro)
+	assert.Equal(t, []string{"Name=runner-1", "garm-metro=da"}, input.Tags)
+	assert.Equal(t, []string{"Name=runner-1"}, tags)
+}
@@ -17,12 +17,22 @@ package provider
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
 
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
 	execution "github.com/cloudbase/garm-provider-common/execution/v0.1.0"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+	"github.com/cloudbase/garm-provider-equinix/internal/metrics"
+	"github.com/cloudbase/garm-provider-equinix/internal/ratelimit"
 	"github.com/cloudbase/garm-provider-equinix/internal/spec"
-	"github.com/google/uuid"
+	"github.com/cloudbase/garm-provider-equinix/internal/tracing"
 	"golang.org/x/sync/errgroup"
 
 	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
@@ -33,6 +43,10 @@ var _ execution.ExternalProvider = &equinixProvider{}
 var Version = "v0.0.0-unknown"
 
 func NewEquinixProvider(configPath, controllerID string) (execution.ExternalProvider, error) {
+	return newEquinixProvider(configPath, controllerID)
+}
+
+func newEquinixProvider(configPath, controllerID string) (*equinixProvider, error) {
 	conf, err := config.NewConfig(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("error loading config: %w", err)
@@ -40,13 +54,43 @@ func NewEquinixProvider(configPath, controllerID string) (execution.ExternalProv
 
 	configuration := metal.NewConfiguration()
 	configuration.AddDefaultHeader("X-Auth-Token", conf.AuthToken)
+	// The rate limiter is the innermost transport, so that the time spent waiting
+	// for it shows in the spans of the requests.
+	var transport http.RoundTripper
+	if conf.RateLimit.IsSet() {
+		// The rate limiter shares its state with the other provider processes.
+		if err := filelock.PrivateDir(conf.GetStateDir()); err != nil {
+			return nil, fmt.Errorf("invalid state directory: %w", err)
+		}
+		limiter := ratelimit.New(
+			filepath.Join(conf.GetStateDir(), "ratelimit.json"),
+			conf.RateLimit.RequestsPerSecond, conf.RateLimit.Burst)
+		transport = ratelimit.NewTransport(limiter, nil)
+	}
+	registry := metrics.NewRegistry()
+	tracer, err := newTracer(conf.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	configuration.HTTPClient = &http.Client{
+		Transport: tracing.NewTransport(tracer, metrics.NewTransport(registry, transport)),
+	}
 
 	api_client := metal.NewAPIClient(configuration)
 
 	return &equinixProvider{
 		cfg:          conf,
 		cli:          api_client.DevicesApi,
+		events:       api_client.EventsApi,
+		os:           api_client.OperatingSystemsApi,
+		console:      api_client.ConsoleLogDetailsApi,
+		plans:        api_client.PlansApi,
+		spot:         api_client.SpotMarketApi,
+		capacity:     api_client.CapacityApi,
+		usages:       api_client.UsagesApi,
 		controllerID: controllerID,
+		tracer:       tracer,
+		metrics:      registry,
 	}, nil
 }
 
@@ -56,23 +100,113 @@ type DevicesApiServiceInterface interface {
 	CreateDevice(ctx context.Context, id string) metal.ApiCreateDeviceRequest
 	DeleteDevice(ctx context.Context, id string) metal.ApiDeleteDeviceRequest
 	PerformAction(ctx context.Context, id string) metal.ApiPerformActionRequest
+	UpdateDevice(ctx context.Context, id string) metal.ApiUpdateDeviceRequest
+}
+
+type EventsApiServiceInterface interface {
+	FindDeviceEvents(ctx context.Context, id string) metal.ApiFindDeviceEventsRequest
+}
+
+type OperatingSystemsApiServiceInterface interface {
+	FindOperatingSystems(ctx context.Context) metal.ApiFindOperatingSystemsRequest
+}
+
+type ConsoleLogDetailsApiServiceInterface interface {
+	CaptureScreenshot(ctx context.Context, id string) metal.ApiCaptureScreenshotRequest
+}
+
+type PlansApiServiceInterface interface {
+	FindPlansByProject(ctx context.Context, id string) metal.ApiFindPlansByProjectRequest
+}
+
+type SpotMarketApiServiceInterface interface {
+	FindMetroSpotMarketPrices(ctx context.Context) metal.ApiFindMetroSpotMarketPricesRequest
+	FindSpotMarketPricesHistory(ctx context.Context) metal.ApiFindSpotMarketPricesHistoryRequest
+}
+
+type CapacityApiServiceInterface interface {
+	CheckCapacityForMetro(ctx context.Context) metal.ApiCheckCapacityForMetroRequest
+}
+
+type UsagesApiServiceInterface interface {
+	FindProjectUsage(ctx context.Context, id string) metal.ApiFindProjectUsageRequest
 }
 
 type equinixProvider struct {
 	cli          DevicesApiServiceInterface
+	events       EventsApiServiceInterface
+	os           OperatingSystemsApiServiceInterface
+	console      ConsoleLogDetailsApiServiceInterface
+	plans        PlansApiServiceInterface
+	spot         SpotMarketApiServiceInterface
+	capacity     CapacityApiServiceInterface
+	usages       UsagesApiServiceInterface
 	cfg          *config.Config
 	controllerID string
+
+	// tracer records the spans of the provider. Tracing is disabled if nil.
+	tracer *tracing.Tracer
+	// metrics holds the metrics recorded by the provider, until flushed to the
+	// configured textfile. Metrics are not recorded if nil.
+	metrics *metrics.Registry
+
+	// osCatalog caches the Equinix Metal operating systems, indexed by slug.
+	osCatalog   map[string]metal.OperatingSystem
+	osCatalogMu sync.Mutex
+
+	// planPrices caches the on-demand hourly prices of the plans, indexed by slug,
+	// for planPricesTTL.
+	planPrices   map[string]float64
+	planPricesAt time.Time
+	planPricesMu sync.Mutex
+
+	// stateDirOnce guards the creation of the state directory, on first use.
+	stateDirOnce sync.Once
+	stateDirPath string
+	stateDirErr  error
 }
 
 func (a *equinixProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (instance params.ProviderInstance, err error) {
-	spec, err := spec.GetRunnerSpecFromBootstrapParams(bootstrapParams, a.controllerID)
+	names := a.cfg.GetTagNames()
+	spec, err := spec.GetRunnerSpecFromBootstrapParams(bootstrapParams, a.controllerID, names)
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to get runner spec: %w", err)
 	}
+	op, previous := a.startOperation(bootstrapParams.PoolID, bootstrapParams.Name)
+	defer a.finishOperation(ctx, op)
+
+	// Only an interrupted attempt to create the runner, as recorded in the
+	// journal, can have left a device behind.
+	var existing *metal.Device
+	if previous != nil {
+		journaled, err := a.journaledDevice(ctx, op)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to look up journaled device: %w", err)
+		}
+		if journaled != nil {
+			existing = oldestLiveDevice([]metal.Device{*journaled})
+		}
+		if existing == nil {
+			existing, err = a.findExistingDevice(ctx, bootstrapParams.Name)
+			if err != nil {
+				return params.ProviderInstance{}, fmt.Errorf("failed to look up existing devices: %w", err)
+			}
+		}
+	}
+	if existing != nil {
+		// A previous attempt to create this runner already created a device. Resume
+		// waiting on it instead of creating a duplicate.
+		a.setOperationDevice(op, existing.GetId())
+		return a.waitDeviceActive(ctx, existing.GetId())
+	}
+
 	userdata, err := spec.ComposeUserData()
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to compose userdata: %w", err)
 	}
+	if err := spec.ApplyTemplates(a.cfg.Tags, a.cfg.Description); err != nil {
+		return params.ProviderInstance{}, fmt.Errorf("failed to render tags: %w", err)
+	}
 
 	metro := a.cfg.MetroCode
 	if spec.MetroCode != "" {
@@ -83,69 +217,186 @@ func (a *equinixProvider) CreateInstance(ctx context.Context, bootstrapParams pa
 		// Equnix has a maximum of 15 characters for Windows
 		hostname = hostname[:15]
 	}
-	deviceRequest := metal.CreateDeviceRequest{
-		DeviceCreateInMetroInput: &metal.DeviceCreateInMetroInput{
-			Metro:                 metro,
-			Plan:                  bootstrapParams.Flavor,
-			OperatingSystem:       bootstrapParams.Image,
-			Tags:                  spec.Tags,
-			Userdata:              &userdata,
-			HardwareReservationId: spec.HardwareReservationID,
-			Hostname:              &hostname,
-		},
+	recycle := a.cfg.RecycleDevices
+	if spec.RecycleDevices != nil {
+		recycle = *spec.RecycleDevices
+	}
+	tags := spec.Tags
+	if recycle {
+		tags = withRecycleTag(tags, names)
+	}
+	input := metal.DeviceCreateInMetroInput{
+		Metro:                 metro,
+		Plan:                  bootstrapParams.Flavor,
+		OperatingSystem:       bootstrapParams.Image,
+		Tags:                  tags,
+		Userdata:              &userdata,
+		HardwareReservationId: spec.HardwareReservationID,
+		Hostname:              &hostname,
+		Customdata:            a.newDeviceMetadata(bootstrapParams.PoolID, &bootstrapParams).customdata(),
+	}
+	if spec.Description != "" {
+		input.Description = &spec.Description
 	}
+	if spec.SpotInstance {
+		input.SpotInstance = &spec.SpotInstance
+		if spec.SpotPriceMax != nil {
+			priceMax := float32(*spec.SpotPriceMax)
+			input.SpotPriceMax = &priceMax
+		}
+	}
+
+	// Fallback plans can not be used with a hardware reservation, which is bound
+	// to a plan.
+	plans := []string{input.Plan}
+	if spec.HardwareReservationID == nil {
+		plans = planCandidates(input.Plan, spec.PlanFallbacks)
+	}
+
+	// The pool of the hostname is recorded before the device is claimed or
+	// created, so that its usage can be attributed once it is deleted.
+	a.recordHostname(hostname, bootstrapParams.PoolID)
 
-	device, _, err := DefaultExecuteCreateDevice(a.cli.CreateDevice(context.Background(), a.cfg.ProjectID).CreateDeviceRequest(deviceRequest))
+	start := time.Now()
+	defer func() {
+		a.recordProvisioning(input.Metro, input.Plan, start, err)
+	}()
+
+	if _, warm := a.cfg.GetWarmPool(bootstrapParams.PoolID); recycle || warm {
+		claimed, err := a.claimStandbyDevice(ctx, bootstrapParams.Name, bootstrapParams.PoolID, a.withPlan(ctx, input, input.Plan))
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to claim standby device: %w", err)
+		}
+		if claimed != nil {
+			a.setOperationDevice(op, claimed.GetId())
+			if err := a.waitReinstallStarted(ctx, claimed.GetId()); err != nil {
+				return params.ProviderInstance{}, err
+			}
+			return a.waitDeviceActive(ctx, claimed.GetId())
+		}
+	}
+
+	spotMetros := a.cfg.SpotMetros
+	if len(spec.SpotMetros) > 0 {
+		spotMetros = spec.SpotMetros
+	}
+	// Devices using a hardware reservation are bound to the metro of the
+	// reservation.
+	if spec.SpotInstance && len(spotMetros) > 0 && spec.HardwareReservationID == nil {
+		offer, err := a.cheapestSpotMetro(ctx, bootstrapParams.Name, input.Plan, spotMetros, spec.SpotPriceMax)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("%w: %w", errCreateDevice, err)
+		}
+		log.Printf("runner %s: placing spot device in metro %s at %.4f USD per hour", bootstrapParams.Name, offer.Metro, offer.Price)
+		input = a.withSpotMetro(input, offer.Metro)
+	}
+	if spec.SpotInstance && spec.SpotBid != nil {
+		// The bid is computed for the plan of the pool, and also used for its
+		// fallback plans.
+		bid := float32(a.spotBid(ctx, bootstrapParams.Name, input.Metro, input.Plan, *spec.SpotBid, *spec.SpotPriceMax))
+		input.SpotPriceMax = &bid
+	}
+
+	device, plan, err := a.createDeviceWithFallbacks(ctx, bootstrapParams.Name, input, plans)
+	input.Plan = plan
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create device: %w", err)
+		return params.ProviderInstance{}, err
 	}
 	if device == nil || device.GetId() == "" {
 		return params.ProviderInstance{}, fmt.Errorf("device ID is empty")
 	}
-	return a.waitDeviceActive(ctx, device.GetId())
+	a.setOperationDevice(op, device.GetId())
+	return a.provisionDevice(ctx, op, device.GetId(), &input, plans)
 }
 
-// GetInstance will return details about one instance.
+// GetInstance will return details about one instance. The instance may be
+// identified either by its provider ID or by the runner name.
 func (a *equinixProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
-	device, _, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, instance))
+	if instance == "" {
+		return params.ProviderInstance{}, fmt.Errorf("instance ID is empty")
+	}
+
+	deviceID, runnerName, ok := parseProviderID(instance)
+	if !ok {
+		runnerName = instance
+		// The journal records the device of a runner even if it was not tagged, or
+		// its tags were edited.
+		var journaled *metal.Device
+		if op, err := a.loadOperation(instance); err == nil && op != nil {
+			journaled, _ = a.journaledDevice(ctx, op)
+		}
+		if journaled != nil {
+			deviceID = journaled.GetId()
+		} else {
+			devices, err := a.findInstancesByName(ctx, instance)
+			if err != nil {
+				return params.ProviderInstance{}, fmt.Errorf("failed to find instances by name: %w", err)
+			}
+			if len(devices) == 0 {
+				return params.ProviderInstance{}, fmt.Errorf("device %s not found: %w", instance, gErrors.ErrNotFound)
+			}
+			device := oldestLiveDevice(devices)
+			if device == nil {
+				device = &devices[0]
+			}
+			deviceID = device.GetId()
+		}
+	}
+
+	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
 	if err != nil {
+		if isNotFoundResponse(resp) {
+			return params.ProviderInstance{}, fmt.Errorf("device %s not found: %w", instance, gErrors.ErrNotFound)
+		}
 		return params.ProviderInstance{}, fmt.Errorf("failed to find device: %w", err)
 	}
-	if device == nil {
-		return params.ProviderInstance{}, fmt.Errorf("device not found")
+	// A recycled device that was parked or claimed by another runner since is not
+	// the instance asked for anymore.
+	if device == nil || !servesRunner(*device, runnerName, a.cfg.GetTagNames()) {
+		return params.ProviderInstance{}, fmt.Errorf("device %s not found: %w", instance, gErrors.ErrNotFound)
 	}
-	ret, err := equinixToGarmInstance(*device)
+	ret, err := a.toGarmInstance(ctx, *device)
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to convert device to garm instance: %w", err)
 	}
+	if ret.Status == params.InstanceError {
+		fault := a.deviceFault(ctx, *device)
+		if fault == "" {
+			fault = string(ret.ProviderFault)
+		}
+		if fault = a.withConsoleLog(ctx, *device, fault); fault != "" {
+			ret.ProviderFault = []byte(fault)
+		}
+	}
 	return ret, nil
 }
 
 // ListInstances will list all instances for a provider.
 func (a *equinixProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
-	devices, _, err := DefaultExecuteFindProjectDevices(a.cli.FindProjectDevices(ctx, a.cfg.ProjectID))
+	devices, err := a.listCachedProjectDevices(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list devices: %w", err)
 	}
+	names := a.cfg.GetTagNames()
 	ret := []params.ProviderInstance{}
-	for _, device := range devices.GetDevices() {
-		tags := extractTagsAsMap(device)
-		devicePoolID, ok := tags[spec.PoolIDTagName]
-		if !ok || devicePoolID != poolID {
+	for _, device := range devices {
+		if isStandbyDevice(device, names) {
+			// Parked and pre-provisioned devices are not assigned to any runner.
 			continue
 		}
-
-		deviceControllerID, ok := tags[spec.ControllerIDTagName]
-		if !ok || deviceControllerID != a.controllerID {
+		meta := deviceMetadataOf(device, names)
+		if meta.PoolID != poolID || meta.ControllerID != a.controllerID {
 			continue
 		}
 
-		instance, err := equinixToGarmInstance(device)
+		instance, err := a.toGarmInstance(ctx, device)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert device to garm instance: %w", err)
+			// A single malformed device, for instance one whose tags were edited by
+			// hand, must not hide the other instances of the pool from garm.
+			log.Printf("skipping device %s: failed to convert device to garm instance: %s", device.GetId(), err)
+			continue
 		}
 		ret = append(ret, instance)
-
 	}
 	return ret, nil
 }
@@ -156,29 +407,57 @@ func (a *equinixProvider) DeleteInstance(ctx context.Context, instance string) e
 		return fmt.Errorf("instance ID is empty")
 	}
 
-	_, err := uuid.Parse(instance)
-	if err != nil {
+	deviceID, runnerName, ok := parseProviderID(instance)
+	if !ok {
 		instances, err := a.findInstancesByName(ctx, instance)
 		if err != nil {
 			return fmt.Errorf("failed to find instances by name: %w", err)
 		}
-		if len(instances) == 0 {
-			return nil
+		deviceIDs := []string{}
+		for _, inst := range instances {
+			deviceIDs = append(deviceIDs, inst.GetId())
+		}
+		// Clean up after a CreateInstance that was interrupted, including its device
+		// if it can not be found by its tags. The journal is best effort, so the
+		// devices found by their tags are deleted even if it can not be read.
+		op, err := a.loadOperation(instance)
+		if err != nil {
+			log.Printf("runner %s: failed to read operation journal: %s", instance, err)
+		}
+		journaled, err := a.journaledDevice(ctx, op)
+		if err != nil {
+			return err
+		}
+		if journaled != nil && !slices.Contains(deviceIDs, journaled.GetId()) {
+			deviceIDs = append(deviceIDs, journaled.GetId())
 		}
 
-		if len(instances) > 1 {
-			g, ctx := errgroup.WithContext(ctx)
-			for _, inst := range instances {
-				inst := inst
+		switch len(deviceIDs) {
+		case 0:
+		case 1:
+			if err := a.recycleOrDeleteInstance(ctx, deviceIDs[0], instance); err != nil {
+				return err
+			}
+		default:
+			g, gctx := errgroup.WithContext(ctx)
+			for _, deviceID := range deviceIDs {
+				deviceID := deviceID
 				g.Go(func() error {
-					return a.deleteOneInstance(ctx, inst.GetId())
+					return a.recycleOrDeleteInstance(gctx, deviceID, instance)
 				})
 			}
-			return a.waitForErrorGroupOrContextCancelled(ctx, g)
+			if err := a.waitForErrorGroupOrContextCancelled(ctx, g); err != nil {
+				return err
+			}
+		}
+		if op != nil {
+			if err := a.removeOperation(*op); err != nil {
+				log.Printf("runner %s: %s", instance, err)
+			}
 		}
-		instance = instances[0].GetId()
+		return nil
 	}
-	return a.deleteOneInstance(ctx, instance)
+	return a.recycleOrDeleteInstance(ctx, deviceID, runnerName)
 }
 
 // RemoveAllInstances will remove all instances created by this provider.
@@ -188,6 +467,9 @@ func (a *equinixProvider) RemoveAllInstances(ctx context.Context) error {
 
 // Stop shuts down the instance.
 func (a *equinixProvider) Stop(ctx context.Context, instance string, force bool) error {
+	if deviceID, _, ok := parseProviderID(instance); ok {
+		instance = deviceID
+	}
 	_, err := DefaultExecutePerformAction(a.cli.PerformAction(ctx, instance).DeviceActionInput(metal.DeviceActionInput{
 		Type: metal.DEVICEACTIONINPUTTYPE_POWER_OFF,
 	}))
@@ -199,6 +481,9 @@ func (a *equinixProvider) Stop(ctx context.Context, instance string, force bool)
 
 // Start boots up an instance.
 func (a *equinixProvider) Start(ctx context.Context, instance string) error {
+	if deviceID, _, ok := parseProviderID(instance); ok {
+		instance = deviceID
+	}
 	_, err := DefaultExecutePerformAction(a.cli.PerformAction(ctx, instance).DeviceActionInput(metal.DeviceActionInput{
 		Type: metal.DEVICEACTIONINPUTTYPE_POWER_ON,
 	}))
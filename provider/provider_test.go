@@ -16,10 +16,13 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-equinix/config"
 	"github.com/cloudbase/garm-provider-equinix/internal/spec"
@@ -32,12 +35,14 @@ func TestCreateInstance(t *testing.T) {
 	ctx := context.Background()
 	cli := new(MockClient)
 	a := &equinixProvider{
-		cli: cli,
+		cli:   cli,
+		plans: cli,
 		cfg: &config.Config{
 			AuthToken:             "token",
 			MetroCode:             "AM",
 			HardwareReservationID: nil,
 			ProjectID:             "project",
+			StateDir:              t.TempDir(),
 		},
 		controllerID: "mock-controller-id",
 	}
@@ -242,13 +247,105 @@ func TestCreateInstance(t *testing.T) {
 				require.NoError(t, err)
 			}
 			assert.Equal(t, tt.expectedOutput, output)
+			// Without a journaled attempt, no existing device is looked for.
+			cli.AssertNotCalled(t, "FindProjectDevices", ctx, a.cfg.ProjectID)
+			// Prices are only looked up when costs are tracked.
+			cli.AssertNotCalled(t, "FindPlansByProject", ctx, a.cfg.ProjectID)
 		})
 	}
 }
 
+func TestCreateInstanceAdoptsExistingDevice(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			AuthToken: "token",
+			MetroCode: "AM",
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+		},
+		controllerID: "mock-controller-id",
+	}
+	bootstrapParams := params.BootstrapInstance{
+		Name:          "test-instance",
+		InstanceToken: "test-token",
+		OSArch:        params.Amd64,
+		OSType:        params.Linux,
+		Image:         "ubuntu_22_04",
+		Flavor:        "c3.small.x86",
+		Tools: []params.RunnerApplicationDownload{
+			{
+				OS:           spec.Ptr("linux"),
+				Architecture: spec.Ptr("x64"),
+				DownloadURL:  spec.Ptr("http://test.com"),
+				Filename:     spec.Ptr("runner.tar.gz"),
+			},
+		},
+		PoolID: "test-pool",
+	}
+	existing := metal.Device{
+		Id: spec.Ptr("existing-id"),
+		Tags: []string{
+			"Name=test-instance",
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"OSType=linux",
+			"OSArch=amd64",
+		},
+		State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+	}
+	failed := metal.Device{
+		Id: spec.Ptr("failed-id"),
+		Tags: []string{
+			"Name=test-instance",
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+		},
+		State: spec.Ptr(metal.DEVICESTATE_FAILED),
+	}
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return bootstrapParams.Tools[0], nil
+	}
+	// A previous attempt was interrupted before the device it created was
+	// journaled.
+	writeOperation(t, a, Operation{
+		RunnerName:   "test-instance",
+		ControllerID: "mock-controller-id",
+		PoolID:       "test-pool",
+		Phase:        OperationPhaseCreating,
+		StartedAt:    time.Now().Add(-time.Minute),
+		UpdatedAt:    time.Now().Add(-time.Minute),
+	})
+	cli.On("FindProjectDevices", ctx, a.cfg.ProjectID).Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: []metal.Device{failed, existing}}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("FindDeviceById", ctx, "existing-id").Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &existing, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	DefaultExecuteCreateDevice = func(r metal.ApiCreateDeviceRequest) (*metal.Device, *http.Response, error) {
+		t.Fatal("CreateDevice must not be called when a device already exists")
+		return nil, nil, nil
+	}
+
+	output, err := a.CreateInstance(ctx, bootstrapParams)
+	require.NoError(t, err)
+	assert.Equal(t, "existing-id", output.ProviderID)
+	assert.Equal(t, params.InstanceRunning, output.Status)
+	cli.AssertNotCalled(t, "CreateDevice", ctx, a.cfg.ProjectID)
+}
+
 func TestGetInstance(t *testing.T) {
 	ctx := context.Background()
 	cli := new(MockClient)
+	deviceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
 	a := &equinixProvider{
 		cli: cli,
 		cfg: &config.Config{
@@ -256,11 +353,12 @@ func TestGetInstance(t *testing.T) {
 			MetroCode:             "AM",
 			HardwareReservationID: nil,
 			ProjectID:             "project",
+			StateDir:              t.TempDir(),
 		},
 		controllerID: "mock-controller-id",
 	}
 	device := metal.Device{
-		Id: spec.Ptr("mock-id"),
+		Id: spec.Ptr(deviceID),
 		Tags: []string{
 			"Name=mock-name",
 			"garm-pool-id=test-pool",
@@ -275,14 +373,17 @@ func TestGetInstance(t *testing.T) {
 		},
 		State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
 	}
-	cli.On("FindDeviceById", ctx, "mock-id").Return(metal.ApiFindDeviceByIdRequest{
+	cli.On("FindDeviceById", ctx, deviceID).Return(metal.ApiFindDeviceByIdRequest{
 		ApiService: &metal.DevicesApiService{},
 	}, nil)
-	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
-		return &device, &http.Response{StatusCode: http.StatusOK}, nil
+	cli.On("FindProjectDevices", ctx, a.cfg.ProjectID).Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: []metal.Device{device}}, &http.Response{StatusCode: http.StatusOK}, nil
 	}
 	expectedOutput := params.ProviderInstance{
-		ProviderID: "mock-id",
+		ProviderID: deviceID,
 		Name:       "mock-name",
 		OSType:     params.Linux,
 		OSArch:     params.Amd64,
@@ -295,9 +396,69 @@ func TestGetInstance(t *testing.T) {
 		Status: params.InstanceRunning,
 	}
 
-	output, err := a.GetInstance(ctx, "mock-id")
-	require.NoError(t, err)
-	assert.Equal(t, expectedOutput, output)
+	tests := []struct {
+		name           string
+		instance       string
+		response       *http.Response
+		err            error
+		expectedOutput params.ProviderInstance
+		errString      string
+		notFound       bool
+	}{
+		{
+			name:           "lookup by device ID",
+			instance:       deviceID,
+			response:       &http.Response{StatusCode: http.StatusOK},
+			expectedOutput: expectedOutput,
+		},
+		{
+			name:           "lookup by runner name",
+			instance:       "mock-name",
+			response:       &http.Response{StatusCode: http.StatusOK},
+			expectedOutput: expectedOutput,
+		},
+		{
+			name:      "unknown runner name",
+			instance:  "unknown-name",
+			errString: "device unknown-name not found",
+			notFound:  true,
+		},
+		{
+			name:      "device not found",
+			instance:  deviceID,
+			response:  &http.Response{StatusCode: http.StatusNotFound},
+			err:       fmt.Errorf("404 Not Found"),
+			errString: "not found",
+			notFound:  true,
+		},
+		{
+			name:      "API error",
+			instance:  deviceID,
+			response:  &http.Response{StatusCode: http.StatusInternalServerError},
+			err:       fmt.Errorf("500 Internal Server Error"),
+			errString: "failed to find device",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				if tt.err != nil {
+					return nil, tt.response, tt.err
+				}
+				return &device, tt.response, nil
+			}
+			output, err := a.GetInstance(ctx, tt.instance)
+			if tt.errString != "" {
+				require.Error(t, err)
+				assert.ErrorContains(t, err, tt.errString)
+				assert.Equal(t, tt.notFound, errors.Is(err, gErrors.ErrNotFound))
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedOutput, output)
+		})
+	}
 }
 
 func TestListInstances(t *testing.T) {
@@ -371,6 +532,7 @@ func TestDeleteInstance(t *testing.T) {
 			MetroCode:             "AM",
 			HardwareReservationID: nil,
 			ProjectID:             "test-pool",
+			StateDir:              t.TempDir(),
 		},
 		controllerID: "mock-controller-id",
 	}
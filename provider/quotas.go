@@ -0,0 +1,149 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// ErrQuotaExceeded is returned by CreateInstance when creating a device would
+// exceed the quotas of the controller.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// planPricesTTL is how long the prices of the plans are cached by a provider.
+const planPricesTTL = time.Hour
+
+// planHourlyPrices returns the on-demand hourly price of the plans available to
+// the project, indexed by slug. The prices are cached for planPricesTTL, so the
+// quota check and the price tag of a device share one lookup.
+func (a *equinixProvider) planHourlyPrices(ctx context.Context) (map[string]float64, error) {
+	a.planPricesMu.Lock()
+	defer a.planPricesMu.Unlock()
+	if a.planPrices != nil && time.Since(a.planPricesAt) < planPricesTTL {
+		return a.planPrices, nil
+	}
+
+	plans, _, err := DefaultExecuteFindPlansByProject(a.plans.FindPlansByProject(ctx, a.cfg.ProjectID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	ret := map[string]float64{}
+	for _, plan := range plans.GetPlans() {
+		if price, ok := plan.GetPricing()["hour"].(float64); ok {
+			ret[plan.GetSlug()] = price
+		}
+	}
+	a.planPrices = ret
+	a.planPricesAt = time.Now()
+	return ret, nil
+}
+
+// checkQuotas returns an error wrapping ErrQuotaExceeded if creating a device of
+// the given plan would exceed the quotas of the controller. All the devices of the
+// controller count towards the quotas, including parked and warm devices. The
+// prices of the plans are only needed if the hourly spend is limited.
+func (a *equinixProvider) checkQuotas(ctx context.Context, plan string, prices map[string]float64) error {
+	quotas := a.cfg.Quotas
+	if !quotas.IsSet() {
+		return nil
+	}
+
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %w", err)
+	}
+	total := 0
+	byPlan := map[string]int{}
+	for _, device := range devices {
+		if !a.isManagedDevice(device) {
+			continue
+		}
+		switch device.GetState() {
+		case metal.DEVICESTATE_DELETED, metal.DEVICESTATE_DEPROVISIONING:
+			continue
+		}
+		total++
+		byPlan[device.Plan.GetSlug()]++
+	}
+
+	if quotas.MaxDevices > 0 && total+1 > quotas.MaxDevices {
+		return fmt.Errorf("%w: the controller already has %d devices, max_devices is %d", ErrQuotaExceeded, total, quotas.MaxDevices)
+	}
+	if max, ok := quotas.MaxDevicesPerPlan[plan]; ok && max > 0 && byPlan[plan]+1 > max {
+		return fmt.Errorf("%w: the controller already has %d %s devices, max_devices_per_plan is %d", ErrQuotaExceeded, byPlan[plan], plan, max)
+	}
+
+	if quotas.MaxHourlySpend > 0 {
+		// The spend quota can not be enforced if a price is unknown, so creating
+		// devices is refused rather than risking to overspend.
+		spend := 0.0
+		byPlan[plan]++
+		for slug, count := range byPlan {
+			price, ok := prices[slug]
+			if !ok {
+				return fmt.Errorf("%w: unknown hourly price of plan %q", ErrQuotaExceeded, slug)
+			}
+			spend += price * float64(count)
+		}
+		if spend > quotas.MaxHourlySpend {
+			return fmt.Errorf("%w: estimated hourly spend would be $%.2f, max_hourly_spend is $%.2f", ErrQuotaExceeded, spend, quotas.MaxHourlySpend)
+		}
+	}
+	return nil
+}
+
+// createDevice creates a device, after checking the quotas of the controller. The
+// quota check and the creation are serialized across the provider processes, so
+// that runners created concurrently can not exceed the quotas together. Waiting
+// for the other processes gives up when ctx is done.
+func (a *equinixProvider) createDevice(ctx context.Context, input metal.DeviceCreateInMetroInput) (*metal.Device, error) {
+	if a.cfg.Quotas.IsSet() {
+		// The prices do not depend on the devices being created, so they are looked
+		// up before taking the lock, to keep it short.
+		var prices map[string]float64
+		if a.cfg.Quotas.MaxHourlySpend > 0 {
+			var err error
+			if prices, err = a.planHourlyPrices(ctx); err != nil {
+				return nil, err
+			}
+		}
+		unlock, err := a.lockState(ctx, "quotas.lock")
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		if err := a.checkQuotas(ctx, input.Plan, prices); err != nil {
+			return nil, err
+		}
+	}
+
+	deviceRequest := metal.CreateDeviceRequest{
+		DeviceCreateInMetroInput: &input,
+	}
+	device, resp, err := DefaultExecuteCreateDevice(a.cli.CreateDevice(ctx, a.cfg.ProjectID).CreateDeviceRequest(deviceRequest))
+	if err != nil {
+		if isCapacityError(resp, err) {
+			err = fmt.Errorf("%w: %w", errNoCapacity, err)
+		}
+		return nil, fmt.Errorf("%w: %w", errCreateDevice, err)
+	}
+	a.invalidateDeviceCache()
+	return device, nil
+}
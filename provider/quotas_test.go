@@ -0,0 +1,215 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func quotaTestDevice(plan, controllerID string, state metal.DeviceState) metal.Device {
+	return metal.Device{
+		Tags:  []string{"garm-controller-id=" + controllerID},
+		Plan:  &metal.Plan{Slug: spec.Ptr(plan)},
+		State: spec.Ptr(state),
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	ctx := context.Background()
+	devices := []metal.Device{
+		quotaTestDevice("m3.large.x86", "mock-controller-id", metal.DEVICESTATE_ACTIVE),
+		quotaTestDevice("m3.large.x86", "mock-controller-id", metal.DEVICESTATE_PROVISIONING),
+		quotaTestDevice("c3.small.x86", "mock-controller-id", metal.DEVICESTATE_ACTIVE),
+		// Devices being deleted and devices of other controllers do not count.
+		quotaTestDevice("m3.large.x86", "mock-controller-id", metal.DEVICESTATE_DEPROVISIONING),
+		quotaTestDevice("m3.large.x86", "other-controller-id", metal.DEVICESTATE_ACTIVE),
+	}
+	plans := []metal.Plan{
+		{Slug: spec.Ptr("m3.large.x86"), Pricing: map[string]interface{}{"hour": 3.1}},
+		{Slug: spec.Ptr("c3.small.x86"), Pricing: map[string]interface{}{"hour": 0.75}},
+	}
+
+	tests := []struct {
+		name      string
+		quotas    config.QuotasConfig
+		plan      string
+		errString string
+	}{
+		{
+			name: "no quotas",
+			plan: "m3.large.x86",
+		},
+		{
+			name:   "within quotas",
+			quotas: config.QuotasConfig{MaxDevices: 4, MaxDevicesPerPlan: map[string]int{"m3.large.x86": 3}, MaxHourlySpend: 11},
+			plan:   "m3.large.x86",
+		},
+		{
+			name:      "max devices",
+			quotas:    config.QuotasConfig{MaxDevices: 3},
+			plan:      "c3.small.x86",
+			errString: "quota exceeded: the controller already has 3 devices, max_devices is 3",
+		},
+		{
+			name:      "max devices per plan",
+			quotas:    config.QuotasConfig{MaxDevicesPerPlan: map[string]int{"m3.large.x86": 2}},
+			plan:      "m3.large.x86",
+			errString: "quota exceeded: the controller already has 2 m3.large.x86 devices, max_devices_per_plan is 2",
+		},
+		{
+			name:   "max devices of another plan",
+			quotas: config.QuotasConfig{MaxDevicesPerPlan: map[string]int{"m3.large.x86": 2}},
+			plan:   "c3.small.x86",
+		},
+		{
+			name:      "max hourly spend",
+			quotas:    config.QuotasConfig{MaxHourlySpend: 9},
+			plan:      "m3.large.x86",
+			errString: "quota exceeded: estimated hourly spend would be $10.05, max_hourly_spend is $9.00",
+		},
+		{
+			name:      "unknown price",
+			quotas:    config.QuotasConfig{MaxHourlySpend: 100},
+			plan:      "n3.xlarge.x86",
+			errString: `quota exceeded: unknown hourly price of plan "n3.xlarge.x86"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:          cli,
+				plans:        cli,
+				cfg:          &config.Config{ProjectID: "project", Quotas: tt.quotas},
+				controllerID: "mock-controller-id",
+			}
+			cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("FindPlansByProject", ctx, "project").Return(metal.ApiFindPlansByProjectRequest{
+				ApiService: &metal.PlansApiService{},
+			}, nil)
+			DefaultExecuteFindPlansByProject = func(r metal.ApiFindPlansByProjectRequest) (*metal.PlanList, *http.Response, error) {
+				return &metal.PlanList{Plans: plans}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			prices, err := a.planHourlyPrices(ctx)
+			require.NoError(t, err)
+			err = a.checkQuotas(ctx, tt.plan, prices)
+			if tt.errString == "" {
+				require.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrQuotaExceeded)
+				assert.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestPlanHourlyPricesCache(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		plans: cli,
+		cfg:   &config.Config{ProjectID: "project"},
+	}
+	cli.On("FindPlansByProject", ctx, "project").Return(metal.ApiFindPlansByProjectRequest{
+		ApiService: &metal.PlansApiService{},
+	}, nil)
+	lookups := 0
+	DefaultExecuteFindPlansByProject = func(r metal.ApiFindPlansByProjectRequest) (*metal.PlanList, *http.Response, error) {
+		lookups++
+		return &metal.PlanList{
+			Plans: []metal.Plan{
+				{Slug: spec.Ptr("c3.small.x86"), Pricing: map[string]interface{}{"hour": 0.5}},
+			},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	for range 2 {
+		prices, err := a.planHourlyPrices(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]float64{"c3.small.x86": 0.5}, prices)
+	}
+	assert.Equal(t, 1, lookups)
+
+	// Expired prices are looked up again.
+	a.planPricesAt = time.Now().Add(-planPricesTTL)
+	_, err := a.planHourlyPrices(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, lookups)
+}
+
+func TestCreateDeviceChecksQuotas(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+			Quotas:    config.QuotasConfig{MaxDevices: 1},
+		},
+		controllerID: "mock-controller-id",
+	}
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: []metal.Device{
+			quotaTestDevice("c3.small.x86", "mock-controller-id", metal.DEVICESTATE_ACTIVE),
+		}}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	_, err := a.createDevice(ctx, metal.DeviceCreateInMetroInput{Plan: "c3.small.x86"})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	cli.AssertNotCalled(t, "CreateDevice", ctx, "project")
+}
+
+func TestCreateDeviceLockTimeout(t *testing.T) {
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "project",
+			StateDir:  t.TempDir(),
+			Quotas:    config.QuotasConfig{MaxDevices: 1},
+		},
+		controllerID: "mock-controller-id",
+	}
+	// Another process holds the quotas lock for longer than the command may wait.
+	unlock, err := a.lockState(context.Background(), "quotas.lock")
+	require.NoError(t, err)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = a.createDevice(ctx, metal.DeviceCreateInMetroInput{Plan: "c3.small.x86"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	cli.AssertNotCalled(t, "FindProjectDevices", ctx, "project")
+}
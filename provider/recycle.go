@@ -0,0 +1,335 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	"github.com/google/uuid"
+	"github.com/juju/clock"
+	"github.com/juju/retry"
+	"github.com/pkg/errors"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// providerIDSeparator separates the device ID from the runner name in the provider
+// ID of a recyclable device.
+const providerIDSeparator = "/"
+
+// isIdleDevice returns true if the device is parked for reuse by its pool.
+func isIdleDevice(device metal.Device, names spec.TagNames) bool {
+	return extractTagsAsMap(device)[names.Idle] == "true"
+}
+
+// isWarmDevice returns true if the device is pre-provisioned for its pool.
+func isWarmDevice(device metal.Device, names spec.TagNames) bool {
+	return extractTagsAsMap(device)[names.Warm] == "true"
+}
+
+// isStandbyDevice returns true if the device is parked or pre-provisioned for its
+// pool, and may be handed out to a new runner.
+func isStandbyDevice(device metal.Device, names spec.TagNames) bool {
+	return isIdleDevice(device, names) || isWarmDevice(device, names)
+}
+
+// isRecyclable returns true if the device should be parked for reuse by its pool
+// instead of being deleted.
+func isRecyclable(device metal.Device, names spec.TagNames) bool {
+	return extractTagsAsMap(device)[names.Recycle] == "true"
+}
+
+// withRecycleTag returns the given tags, with the tag marking devices for recycling
+// appended.
+func withRecycleTag(tags []string, names spec.TagNames) []string {
+	ret := append([]string{}, tags...)
+	return append(ret, fmt.Sprintf("%s=true", names.Recycle))
+}
+
+// idleDeviceTags returns the tags of a device once it is parked. Only the tags
+// identifying the pool and the controller are kept.
+func idleDeviceTags(device metal.Device, names spec.TagNames) []string {
+	tags := extractTagsAsMap(device)
+	ret := []string{}
+	for _, name := range []string{names.PoolID, names.ControllerID, names.Recycle} {
+		if val, ok := tags[name]; ok {
+			ret = append(ret, fmt.Sprintf("%s=%s", name, val))
+		}
+	}
+	return append(ret, fmt.Sprintf("%s=true", names.Idle))
+}
+
+// parkDevice removes the runner tags and userdata from a device and reinstalls
+// it, so it can later be claimed by a new runner of the same pool.
+func (a *equinixProvider) parkDevice(ctx context.Context, device metal.Device) error {
+	deviceID := device.GetId()
+	names := a.cfg.GetTagNames()
+	meta := deviceMetadataOf(device, names)
+	parkedAt := time.Now().UTC()
+	idle := deviceMetadata{
+		Version:         metadataVersion,
+		ControllerID:    meta.ControllerID,
+		PoolID:          meta.PoolID,
+		ProviderVersion: Version,
+		ParkedAt:        &parkedAt,
+	}
+	_, _, err := DefaultExecuteUpdateDevice(a.cli.UpdateDevice(ctx, deviceID).DeviceUpdateInput(metal.DeviceUpdateInput{
+		Tags:        idleDeviceTags(device, names),
+		Userdata:    spec.Ptr(""),
+		Description: spec.Ptr(""),
+		Customdata:  idle.customdata(),
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	a.invalidateDeviceCache()
+
+	_, err = DefaultExecutePerformAction(a.cli.PerformAction(ctx, deviceID).DeviceActionInput(metal.DeviceActionInput{
+		Type:            metal.DEVICEACTIONINPUTTYPE_REINSTALL,
+		DeprovisionFast: spec.Ptr(true),
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to reinstall device: %w", err)
+	}
+	return nil
+}
+
+// providerIDOf returns the provider ID of the runner on a device. A recycled
+// device keeps its device ID from one runner to the next, so the provider ID of
+// a recyclable device also holds the runner name. This keeps a stale call for a
+// previous runner of the device from acting on the runner it now serves.
+func providerIDOf(device metal.Device, runnerName string, names spec.TagNames) string {
+	if !isRecyclable(device, names) || runnerName == "" {
+		return device.GetId()
+	}
+	return device.GetId() + providerIDSeparator + runnerName
+}
+
+// parseProviderID splits a provider ID into the device ID and the runner name it
+// was given to, if any. The returned bool is false if the instance is not a
+// provider ID, but a runner name.
+func parseProviderID(instance string) (deviceID, runnerName string, ok bool) {
+	if _, err := uuid.Parse(instance); err == nil {
+		return instance, "", true
+	}
+	deviceID, runnerName, found := strings.Cut(instance, providerIDSeparator)
+	if !found || runnerName == "" {
+		return "", "", false
+	}
+	if _, err := uuid.Parse(deviceID); err != nil {
+		return "", "", false
+	}
+	return deviceID, runnerName, true
+}
+
+// servesRunner returns false if the device no longer serves the given runner,
+// because it was parked or claimed by another runner since. Any device serves an
+// empty runner name, as do devices the runner name could not be set on.
+func servesRunner(device metal.Device, runnerName string, names spec.TagNames) bool {
+	if runnerName == "" {
+		return true
+	}
+	if isStandbyDevice(device, names) {
+		return false
+	}
+	name := deviceMetadataOf(device, names).RunnerName
+	return name == "" || name == runnerName
+}
+
+// recycleOrDeleteInstance parks a device created with recycling enabled, and
+// deletes any other device. Devices which cannot be parked, or for which their
+// pool has no room left, are deleted. If a runner name is given, the device is
+// left alone once it no longer serves that runner.
+func (a *equinixProvider) recycleOrDeleteInstance(ctx context.Context, instanceID, runnerName string) error {
+	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, instanceID))
+	if err != nil {
+		if isNotFoundResponse(resp) {
+			return nil
+		}
+		return fmt.Errorf("failed to find device: %w", err)
+	}
+	names := a.cfg.GetTagNames()
+	if device != nil && !servesRunner(*device, runnerName, names) {
+		log.Printf("device %s no longer serves runner %s, leaving it alone", instanceID, runnerName)
+		return nil
+	}
+	if device == nil || !isRecyclable(*device, names) {
+		return a.deleteOneInstance(ctx, instanceID)
+	}
+	if isIdleDevice(*device, names) {
+		// Already parked by a previous call.
+		return nil
+	}
+	if device.GetState() == metal.DEVICESTATE_ACTIVE {
+		if parked, err := a.parkIfRoom(ctx, *device); err == nil && parked {
+			return nil
+		}
+	}
+	return a.deleteOneInstance(ctx, instanceID)
+}
+
+// isClaimable returns true if a standby device can be used to create a device with
+// the given parameters.
+func isClaimable(device metal.Device, input metal.DeviceCreateInMetroInput, names spec.TagNames) bool {
+	if !isStandbyDevice(device, names) || device.GetState() != metal.DEVICESTATE_ACTIVE {
+		return false
+	}
+	if device.Plan.GetSlug() != input.Plan {
+		return false
+	}
+	if !strings.EqualFold(device.Metro.GetCode(), input.Metro) {
+		return false
+	}
+	if input.HardwareReservationId != nil {
+		reservation := device.GetHardwareReservation()
+		if reservation.GetId() != *input.HardwareReservationId {
+			return false
+		}
+	}
+	return true
+}
+
+// findStandbyDevices returns the devices parked or pre-provisioned for the given
+// pool, which can be used to create a device with the given parameters. The
+// devices on standby for the longest time are returned first.
+func (a *equinixProvider) findStandbyDevices(ctx context.Context, poolID string, input metal.DeviceCreateInMetroInput) ([]metal.Device, error) {
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	names := a.cfg.GetTagNames()
+	ret := []metal.Device{}
+	for _, device := range devices {
+		if !a.isManagedDevice(device) || deviceMetadataOf(device, names).PoolID != poolID {
+			continue
+		}
+		if isClaimable(device, input, names) {
+			ret = append(ret, device)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].GetUpdatedAt().Before(ret[j].GetUpdatedAt())
+	})
+	return ret, nil
+}
+
+// claimStandbyDevice assigns a device parked or pre-provisioned for the pool to a
+// new runner. The device is retagged and reinstalled with the given userdata. A nil
+// device is returned if no standby device could be claimed.
+func (a *equinixProvider) claimStandbyDevice(ctx context.Context, name, poolID string, input metal.DeviceCreateInMetroInput) (*metal.Device, error) {
+	for {
+		claimed, err := a.reserveStandbyDevice(ctx, name, poolID, input)
+		if err != nil || claimed == nil {
+			return nil, err
+		}
+
+		deviceID := claimed.GetId()
+		_, err = DefaultExecutePerformAction(a.cli.PerformAction(ctx, deviceID).DeviceActionInput(metal.DeviceActionInput{
+			Type:            metal.DEVICEACTIONINPUTTYPE_REINSTALL,
+			OperatingSystem: &input.OperatingSystem,
+			DeprovisionFast: spec.Ptr(true),
+		}))
+		if err != nil {
+			// The device now carries the runner name, but is not provisioned for it.
+			// Get rid of it, so it is not mistaken for the runner.
+			if err := a.deleteOneInstance(ctx, deviceID); err != nil {
+				return nil, fmt.Errorf("failed to delete device %s after failed reinstall: %w", deviceID, err)
+			}
+			continue
+		}
+		return claimed, nil
+	}
+}
+
+// reserveStandbyDevice retags the first claimable standby device of the pool for a
+// new runner, and returns it. A nil device is returned if there is none.
+//
+// The Equinix Metal API offers no way to atomically update a device, so claims are
+// serialized across the provider processes sharing the state dir. A claimed device
+// no longer looks like a standby device to the claims that follow. The tags are
+// also read back after the update, to detect a claim made by another host.
+func (a *equinixProvider) reserveStandbyDevice(ctx context.Context, name, poolID string, input metal.DeviceCreateInMetroInput) (*metal.Device, error) {
+	unlock, err := a.lockState(ctx, "standby.lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	devices, err := a.findStandbyDevices(ctx, poolID, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find standby devices: %w", err)
+	}
+
+	for _, device := range devices {
+		deviceID := device.GetId()
+		_, _, err := DefaultExecuteUpdateDevice(a.cli.UpdateDevice(ctx, deviceID).DeviceUpdateInput(metal.DeviceUpdateInput{
+			Tags:        input.Tags,
+			Userdata:    input.Userdata,
+			Hostname:    input.Hostname,
+			Description: input.Description,
+			Customdata:  input.Customdata,
+		}))
+		if err != nil {
+			continue
+		}
+		a.invalidateDeviceCache()
+
+		claimed, _, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+		if err != nil || claimed == nil || deviceMetadataOf(*claimed, a.cfg.GetTagNames()).RunnerName != name {
+			// Claimed by another runner.
+			continue
+		}
+		return claimed, nil
+	}
+	return nil, nil
+}
+
+// waitReinstallStarted waits for a device to leave the active state after it was
+// asked to reinstall, so it is not reported as active before being reinstalled.
+func (a *equinixProvider) waitReinstallStarted(ctx context.Context, deviceID string) error {
+	err := retry.Call(retry.CallArgs{
+		IsFatalError: func(err error) bool {
+			return errors.Is(err, errStopRetry)
+		},
+		Func: func() error {
+			device, _, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+			if err != nil {
+				return fmt.Errorf("failed to find device: %w", errStopRetry)
+			}
+			if device == nil {
+				return fmt.Errorf("device not found: %w", errStopRetry)
+			}
+			if device.GetState() == metal.DEVICESTATE_ACTIVE {
+				return fmt.Errorf("reinstall not started yet")
+			}
+			return nil
+		},
+		// Roughly 5 minutes.
+		Attempts: 60,
+		Delay:    5 * time.Second,
+		Clock:    clock.WallClock,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for reinstall to start: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,655 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	gErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func idleTestDevice(id string, updatedAt time.Time) metal.Device {
+	return metal.Device{
+		Id: spec.Ptr(id),
+		Tags: []string{
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"garm-recycle=true",
+			"garm-idle=true",
+		},
+		Plan:      &metal.Plan{Slug: spec.Ptr("c3.small.x86")},
+		Metro:     &metal.DeviceMetro{Code: spec.Ptr("am")},
+		State:     spec.Ptr(metal.DEVICESTATE_ACTIVE),
+		UpdatedAt: spec.Ptr(updatedAt),
+	}
+}
+
+func TestIdleDeviceTags(t *testing.T) {
+	device := metal.Device{
+		Tags: []string{
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"OSType=linux",
+			"OSArch=amd64",
+			"Name=runner-1",
+			"garm-recycle=true",
+		},
+	}
+	assert.Equal(t, []string{
+		"garm-pool-id=test-pool",
+		"garm-controller-id=mock-controller-id",
+		"garm-recycle=true",
+		"garm-idle=true",
+	}, idleDeviceTags(device, spec.LegacyTagNames))
+}
+
+func TestIsClaimable(t *testing.T) {
+	input := metal.DeviceCreateInMetroInput{
+		Metro: "AM",
+		Plan:  "c3.small.x86",
+	}
+	tests := []struct {
+		name     string
+		device   func(metal.Device) metal.Device
+		input    func(metal.DeviceCreateInMetroInput) metal.DeviceCreateInMetroInput
+		expected bool
+	}{
+		{
+			name:     "idle device",
+			expected: true,
+		},
+		{
+			name: "warm device",
+			device: func(d metal.Device) metal.Device {
+				d.Tags = []string{"garm-pool-id=test-pool", "garm-controller-id=mock-controller-id", "garm-warm=true"}
+				return d
+			},
+			expected: true,
+		},
+		{
+			name: "device assigned to a runner",
+			device: func(d metal.Device) metal.Device {
+				d.Tags = d.Tags[:3]
+				return d
+			},
+			expected: false,
+		},
+		{
+			name: "device still reinstalling",
+			device: func(d metal.Device) metal.Device {
+				d.State = spec.Ptr(metal.DEVICESTATE_REINSTALLING)
+				return d
+			},
+			expected: false,
+		},
+		{
+			name: "different plan",
+			input: func(i metal.DeviceCreateInMetroInput) metal.DeviceCreateInMetroInput {
+				i.Plan = "m3.large.x86"
+				return i
+			},
+			expected: false,
+		},
+		{
+			name: "different metro",
+			input: func(i metal.DeviceCreateInMetroInput) metal.DeviceCreateInMetroInput {
+				i.Metro = "DA"
+				return i
+			},
+			expected: false,
+		},
+		{
+			name: "different hardware reservation",
+			device: func(d metal.Device) metal.Device {
+				d.HardwareReservation = &metal.HardwareReservation{Id: spec.Ptr("other-reservation")}
+				return d
+			},
+			input: func(i metal.DeviceCreateInMetroInput) metal.DeviceCreateInMetroInput {
+				i.HardwareReservationId = spec.Ptr("reservation")
+				return i
+			},
+			expected: false,
+		},
+		{
+			name: "same hardware reservation",
+			device: func(d metal.Device) metal.Device {
+				d.HardwareReservation = &metal.HardwareReservation{Id: spec.Ptr("reservation")}
+				return d
+			},
+			input: func(i metal.DeviceCreateInMetroInput) metal.DeviceCreateInMetroInput {
+				i.HardwareReservationId = spec.Ptr("reservation")
+				return i
+			},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := idleTestDevice("idle-id", time.Now())
+			if tt.device != nil {
+				device = tt.device(device)
+			}
+			in := input
+			if tt.input != nil {
+				in = tt.input(in)
+			}
+			assert.Equal(t, tt.expected, isClaimable(device, in, spec.LegacyTagNames))
+		})
+	}
+}
+
+func TestRecycleOrDeleteInstance(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	runnerDevice := metal.Device{
+		Id: spec.Ptr(instanceID),
+		Tags: []string{
+			"Name=runner-1",
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"garm-recycle=true",
+		},
+		State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+	}
+
+	tests := []struct {
+		name           string
+		device         metal.Device
+		runnerName     string
+		reinstallErr   error
+		expectedParked bool
+		expectedDelete bool
+	}{
+		{
+			name:           "recyclable device is parked",
+			device:         runnerDevice,
+			expectedParked: true,
+		},
+		{
+			name:           "device of the runner is parked",
+			device:         runnerDevice,
+			runnerName:     "runner-1",
+			expectedParked: true,
+		},
+		{
+			name:       "device claimed by another runner is left alone",
+			device:     runnerDevice,
+			runnerName: "runner-0",
+		},
+		{
+			name:       "device parked since is left alone",
+			device:     idleTestDevice(instanceID, time.Now()),
+			runnerName: "runner-1",
+		},
+		{
+			name: "device without recycling is deleted",
+			device: metal.Device{
+				Id:    spec.Ptr(instanceID),
+				Tags:  runnerDevice.Tags[:3],
+				State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			},
+			expectedDelete: true,
+		},
+		{
+			name:   "parked device is left alone",
+			device: idleTestDevice(instanceID, time.Now()),
+		},
+		{
+			name:           "device which fails to park is deleted",
+			device:         runnerDevice,
+			reinstallErr:   fmt.Errorf("reinstall failed"),
+			expectedParked: true,
+			expectedDelete: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:          cli,
+				cfg:          &config.Config{ProjectID: "project"},
+				controllerID: "mock-controller-id",
+			}
+			cli.On("FindDeviceById", ctx, instanceID).Return(metal.ApiFindDeviceByIdRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				return &tt.device, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("UpdateDevice", ctx, instanceID).Return(metal.ApiUpdateDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteUpdateDevice = func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error) {
+				return &tt.device, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("PerformAction", ctx, instanceID).Return(metal.ApiPerformActionRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecutePerformAction = func(r metal.ApiPerformActionRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK}, tt.reinstallErr
+			}
+			cli.On("DeleteDevice", ctx, instanceID).Return(metal.ApiDeleteDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNoContent}, nil
+			}
+
+			err := a.recycleOrDeleteInstance(ctx, instanceID, tt.runnerName)
+			require.NoError(t, err)
+			if tt.expectedParked {
+				cli.AssertCalled(t, "UpdateDevice", ctx, instanceID)
+				cli.AssertCalled(t, "PerformAction", ctx, instanceID)
+			} else {
+				cli.AssertNotCalled(t, "UpdateDevice", ctx, instanceID)
+			}
+			if tt.expectedDelete {
+				cli.AssertCalled(t, "DeleteDevice", ctx, instanceID)
+			} else {
+				cli.AssertNotCalled(t, "DeleteDevice", ctx, instanceID)
+			}
+		})
+	}
+}
+
+func TestParseProviderID(t *testing.T) {
+	deviceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	tests := []struct {
+		name               string
+		instance           string
+		expectedDeviceID   string
+		expectedRunnerName string
+		expectedOK         bool
+	}{
+		{
+			name:             "device ID",
+			instance:         deviceID,
+			expectedDeviceID: deviceID,
+			expectedOK:       true,
+		},
+		{
+			name:               "device ID with runner name",
+			instance:           deviceID + "/runner-1",
+			expectedDeviceID:   deviceID,
+			expectedRunnerName: "runner-1",
+			expectedOK:         true,
+		},
+		{
+			name:     "runner name",
+			instance: "runner-1",
+		},
+		{
+			name:     "runner name with separator",
+			instance: "runner/1",
+		},
+		{
+			name:     "device ID with empty runner name",
+			instance: deviceID + "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceID, runnerName, ok := parseProviderID(tt.instance)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedDeviceID, deviceID)
+			assert.Equal(t, tt.expectedRunnerName, runnerName)
+		})
+	}
+}
+
+func TestGetInstanceRecycledDevice(t *testing.T) {
+	ctx := context.Background()
+	deviceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:          cli,
+		cfg:          &config.Config{ProjectID: "project"},
+		controllerID: "mock-controller-id",
+	}
+	cli.On("FindDeviceById", ctx, deviceID).Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		return &metal.Device{
+			Id: spec.Ptr(deviceID),
+			Tags: []string{
+				"Name=runner-2",
+				"garm-pool-id=test-pool",
+				"garm-controller-id=mock-controller-id",
+				"garm-recycle=true",
+			},
+			State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	output, err := a.GetInstance(ctx, deviceID+"/runner-2")
+	require.NoError(t, err)
+	assert.Equal(t, deviceID+"/runner-2", output.ProviderID)
+	assert.Equal(t, "runner-2", output.Name)
+
+	// The device served runner-1 before being recycled for runner-2.
+	_, err = a.GetInstance(ctx, deviceID+"/runner-1")
+	assert.ErrorIs(t, err, gErrors.ErrNotFound)
+}
+
+func TestClaimStandbyDevice(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	input := metal.DeviceCreateInMetroInput{
+		Metro:           "AM",
+		Plan:            "c3.small.x86",
+		OperatingSystem: "ubuntu_22_04",
+		Tags: []string{
+			"garm-pool-id=test-pool",
+			"garm-controller-id=mock-controller-id",
+			"Name=runner-1",
+			"garm-recycle=true",
+		},
+		Userdata: spec.Ptr("userdata"),
+	}
+
+	tests := []struct {
+		name       string
+		claimedBy  map[string]string
+		expectedID string
+	}{
+		{
+			name: "claims the longest parked device",
+			claimedBy: map[string]string{
+				"idle-1": "runner-1",
+				"idle-2": "runner-1",
+			},
+			expectedID: "idle-2",
+		},
+		{
+			name: "skips devices claimed by other runners",
+			claimedBy: map[string]string{
+				"idle-1": "runner-1",
+				"idle-2": "runner-2",
+			},
+			expectedID: "idle-1",
+		},
+		{
+			name: "no device claimed",
+			claimedBy: map[string]string{
+				"idle-1": "runner-3",
+				"idle-2": "runner-2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:          cli,
+				cfg:          &config.Config{ProjectID: "project"},
+				controllerID: "mock-controller-id",
+			}
+			devices := []metal.Device{
+				idleTestDevice("idle-1", now.Add(-time.Minute)),
+				idleTestDevice("idle-2", now.Add(-time.Hour)),
+				idleTestDevice("other-pool", now.Add(-2*time.Hour)),
+			}
+			devices[2].Tags[0] = "garm-pool-id=other-pool"
+			cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			var lastID string
+			for id := range tt.claimedBy {
+				id := id
+				cli.On("UpdateDevice", ctx, id).Return(metal.ApiUpdateDeviceRequest{
+					ApiService: &metal.DevicesApiService{},
+				}, nil).Run(func(_ mock.Arguments) {
+					lastID = id
+				})
+				cli.On("FindDeviceById", ctx, id).Return(metal.ApiFindDeviceByIdRequest{
+					ApiService: &metal.DevicesApiService{},
+				}, nil).Run(func(_ mock.Arguments) {
+					lastID = id
+				})
+				cli.On("PerformAction", ctx, id).Return(metal.ApiPerformActionRequest{
+					ApiService: &metal.DevicesApiService{},
+				}, nil)
+			}
+			DefaultExecuteUpdateDevice = func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error) {
+				return nil, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				return &metal.Device{
+					Id:   spec.Ptr(lastID),
+					Tags: []string{fmt.Sprintf("Name=%s", tt.claimedBy[lastID])},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			DefaultExecutePerformAction = func(r metal.ApiPerformActionRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			device, err := a.claimStandbyDevice(ctx, "runner-1", "test-pool", input)
+			require.NoError(t, err)
+			if tt.expectedID == "" {
+				assert.Nil(t, device)
+				cli.AssertNotCalled(t, "PerformAction", ctx, "idle-1")
+				cli.AssertNotCalled(t, "PerformAction", ctx, "idle-2")
+				return
+			}
+			require.NotNil(t, device)
+			assert.Equal(t, tt.expectedID, device.GetId())
+			cli.AssertCalled(t, "PerformAction", ctx, tt.expectedID)
+			cli.AssertNotCalled(t, "UpdateDevice", ctx, "other-pool")
+		})
+	}
+}
+
+type claimTestRunnerKey struct{}
+
+func TestClaimStandbyDeviceConcurrently(t *testing.T) {
+	now := time.Now()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:          cli,
+		cfg:          &config.Config{ProjectID: "project", StateDir: t.TempDir()},
+		controllerID: "mock-controller-id",
+	}
+	input := metal.DeviceCreateInMetroInput{
+		Metro:           "AM",
+		Plan:            "c3.small.x86",
+		OperatingSystem: "ubuntu_22_04",
+	}
+
+	// The device is no longer listed as idle once it is retagged, and is read back
+	// with the tags of the last runner which retagged it.
+	var mu sync.Mutex
+	owner := ""
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if owner != "" {
+			return &metal.DeviceList{}, &http.Response{StatusCode: http.StatusOK}, nil
+		}
+		return &metal.DeviceList{
+			Devices: []metal.Device{idleTestDevice("idle-1", now.Add(-time.Hour))},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	DefaultExecuteUpdateDevice = func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error) {
+		return nil, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &metal.Device{
+			Id:   spec.Ptr("idle-1"),
+			Tags: []string{fmt.Sprintf("Name=%s", owner)},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	DefaultExecutePerformAction = func(r metal.ApiPerformActionRequest) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	runners := []string{"runner-1", "runner-2", "runner-3"}
+	for idx, runner := range runners {
+		runner := runner
+		// Without serialization, every runner lists the idle device, and retags and
+		// reads it back in turn.
+		delay := time.Duration(idx) * 20 * time.Millisecond
+		ctx := context.WithValue(context.Background(), claimTestRunnerKey{}, runner)
+		cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+		cli.On("UpdateDevice", ctx, "idle-1").Return(metal.ApiUpdateDeviceRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil).Run(func(_ mock.Arguments) {
+			time.Sleep(delay)
+			mu.Lock()
+			owner = runner
+			mu.Unlock()
+		})
+		cli.On("FindDeviceById", ctx, "idle-1").Return(metal.ApiFindDeviceByIdRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+		cli.On("PerformAction", ctx, "idle-1").Return(metal.ApiPerformActionRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+	}
+
+	var wg sync.WaitGroup
+	claimed := make([]*metal.Device, len(runners))
+	for idx, runner := range runners {
+		wg.Add(1)
+		go func(idx int, runner string) {
+			defer wg.Done()
+			ctx := context.WithValue(context.Background(), claimTestRunnerKey{}, runner)
+			device, err := a.claimStandbyDevice(ctx, runner, "test-pool", input)
+			assert.NoError(t, err)
+			claimed[idx] = device
+		}(idx, runner)
+	}
+	wg.Wait()
+
+	count := 0
+	for _, device := range claimed {
+		if device != nil {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestCreateInstanceClaimsIdleDevice(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:   cli,
+		plans: cli,
+		cfg: &config.Config{
+			AuthToken:      "token",
+			MetroCode:      "AM",
+			ProjectID:      "project",
+			RecycleDevices: true,
+			StateDir:       t.TempDir(),
+		},
+		controllerID: "mock-controller-id",
+	}
+	bootstrapParams := params.BootstrapInstance{
+		Name:          "runner-1",
+		InstanceToken: "test-token",
+		OSArch:        params.Amd64,
+		OSType:        params.Linux,
+		Image:         "ubuntu_22_04",
+		Flavor:        "c3.small.x86",
+		Tools: []params.RunnerApplicationDownload{
+			{
+				OS:           spec.Ptr("linux"),
+				Architecture: spec.Ptr("x64"),
+				DownloadURL:  spec.Ptr("http://test.com"),
+				Filename:     spec.Ptr("runner.tar.gz"),
+			},
+		},
+		PoolID: "test-pool",
+	}
+	spec.DefaultToolFetch = func(osType params.OSType, osArch params.OSArch, tools []params.RunnerApplicationDownload) (params.RunnerApplicationDownload, error) {
+		return tools[0], nil
+	}
+	spec.DefaultGetCloudconfig = func(bootstrapParams params.BootstrapInstance, tools params.RunnerApplicationDownload, runnerName string) (string, error) {
+		return "cloudconfig", nil
+	}
+
+	idle := idleTestDevice("idle-1", time.Now())
+	cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		return &metal.DeviceList{Devices: []metal.Device{idle}}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("UpdateDevice", ctx, "idle-1").Return(metal.ApiUpdateDeviceRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecuteUpdateDevice = func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error) {
+		return nil, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("PerformAction", ctx, "idle-1").Return(metal.ApiPerformActionRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	DefaultExecutePerformAction = func(r metal.ApiPerformActionRequest) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	cli.On("FindDeviceById", ctx, "idle-1").Return(metal.ApiFindDeviceByIdRequest{
+		ApiService: &metal.DevicesApiService{},
+	}, nil)
+	// The device is read back after being claimed, while reinstalling, and once
+	// it is active again.
+	states := []metal.DeviceState{metal.DEVICESTATE_ACTIVE, metal.DEVICESTATE_REINSTALLING, metal.DEVICESTATE_ACTIVE}
+	calls := 0
+	DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+		state := states[min(calls, len(states)-1)]
+		calls++
+		return &metal.Device{
+			Id: spec.Ptr("idle-1"),
+			Tags: []string{
+				"garm-pool-id=test-pool",
+				"garm-controller-id=mock-controller-id",
+				"Name=runner-1",
+				"garm-recycle=true",
+			},
+			State: spec.Ptr(state),
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	output, err := a.CreateInstance(ctx, bootstrapParams)
+	require.NoError(t, err)
+	assert.Equal(t, "idle-1/runner-1", output.ProviderID)
+	assert.Equal(t, "runner-1", output.Name)
+	assert.Equal(t, 3, calls)
+	cli.AssertNotCalled(t, "CreateDevice", ctx, "project")
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// provisionDevice waits for the device of a runner to become active. Devices
+// which fail to provision are deleted and created again with the given plans, as
+// allowed by the reprovision policy, until the provisioning timeout of the runner
+// is over. The input is updated with the plan and placement of the last device.
+func (a *equinixProvider) provisionDevice(ctx context.Context, op *Operation, deviceID string, input *metal.DeviceCreateInMetroInput, plans []string) (params.ProviderInstance, error) {
+	policy := a.cfg.Reprovision
+	deadline := op.StartedAt.Add(a.cfg.GetProvisioningTimeout())
+	var failedMetros []string
+	for attempt := 1; ; attempt++ {
+		instance, err := a.waitDeviceActive(ctx, deviceID)
+		if err == nil || !errors.Is(err, errDeviceFailed) || attempt > policy.MaxAttempts {
+			return instance, err
+		}
+		if time.Now().After(deadline) {
+			log.Printf("runner %s: not replacing failed device %s, the provisioning timeout is over", op.RunnerName, deviceID)
+			return instance, err
+		}
+		log.Printf("runner %s: replacing failed device %s (attempt %d of %d): %s", op.RunnerName, deviceID, attempt, policy.MaxAttempts, err)
+
+		failed, _, findErr := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+		if err := a.deleteOneInstance(ctx, deviceID); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to delete failed device %s: %w", deviceID, err)
+		}
+		if policy.ExcludeFailedPlacement {
+			metro := input.Metro
+			if findErr == nil && failed != nil {
+				deviceMetro := failed.GetMetro()
+				if code := deviceMetro.GetCode(); code != "" {
+					metro = code
+				}
+			}
+			failedMetros = append(failedMetros, metro)
+			*input = a.excludePlacement(op.RunnerName, *input, failedMetros)
+		}
+
+		device, plan, err := a.createDeviceWithFallbacks(ctx, op.RunnerName, *input, plans)
+		input.Plan = plan
+		if err != nil {
+			return params.ProviderInstance{}, err
+		}
+		if device == nil || device.GetId() == "" {
+			return params.ProviderInstance{}, fmt.Errorf("device ID is empty")
+		}
+		deviceID = device.GetId()
+		a.setOperationDevice(op, deviceID)
+	}
+}
+
+// excludePlacement returns the input of a device replacing devices which failed to
+// provision in the given metros. The hardware reservation is dropped, so that the
+// device is created on demand, and the device is moved to the first fallback metro
+// in which no device failed. The device stays in its metro if there is none, or if
+// it is a spot device: its metro and bid were chosen from the spot prices of that
+// metro.
+func (a *equinixProvider) excludePlacement(runnerName string, input metal.DeviceCreateInMetroInput, failedMetros []string) metal.DeviceCreateInMetroInput {
+	if input.HardwareReservationId != nil {
+		log.Printf("runner %s: creating the replacement device without hardware reservation %s", runnerName, *input.HardwareReservationId)
+		input.HardwareReservationId = nil
+	}
+	if input.GetSpotInstance() {
+		log.Printf("runner %s: creating the replacement spot device in metro %s", runnerName, input.Metro)
+		return input
+	}
+	for _, metro := range a.cfg.Reprovision.FallbackMetros {
+		if !containsFold(failedMetros, metro) {
+			log.Printf("runner %s: creating the replacement device in metro %s", runnerName, metro)
+			input.Metro = metro
+			return input
+		}
+	}
+	return input
+}
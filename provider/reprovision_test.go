@@ -0,0 +1,195 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisionDevice(t *testing.T) {
+	failedID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	replacementID := "a1b2c3d4-6155-472e-ae76-37b5401f888f"
+	tags := []string{
+		"Name=runner-1",
+		"garm-pool-id=pool-1",
+		"garm-controller-id=controller-1",
+		"OSType=linux",
+		"OSArch=amd64",
+	}
+
+	tests := []struct {
+		name              string
+		policy            config.ReprovisionConfig
+		startedAt         time.Time
+		expectedID        string
+		expectedMetro     string
+		spot              bool
+		expectReservation bool
+		errString         string
+	}{
+		{
+			name:              "replaced in the same metro",
+			policy:            config.ReprovisionConfig{MaxAttempts: 1},
+			expectedID:        replacementID,
+			expectedMetro:     "am",
+			expectReservation: true,
+		},
+		{
+			name: "replaced away from the failed placement",
+			policy: config.ReprovisionConfig{
+				MaxAttempts:            1,
+				ExcludeFailedPlacement: true,
+				FallbackMetros:         []string{"AM", "da"},
+			},
+			expectedID:    replacementID,
+			expectedMetro: "da",
+		},
+		{
+			name: "spot device replaced in the same metro",
+			policy: config.ReprovisionConfig{
+				MaxAttempts:            1,
+				ExcludeFailedPlacement: true,
+				FallbackMetros:         []string{"da"},
+			},
+			spot:          true,
+			expectedID:    replacementID,
+			expectedMetro: "am",
+		},
+		{
+			name:              "reprovisioning disabled",
+			expectedMetro:     "am",
+			expectReservation: true,
+			errString:         "device failed",
+		},
+		{
+			name:              "provisioning timeout over",
+			policy:            config.ReprovisionConfig{MaxAttempts: 1},
+			startedAt:         time.Now().Add(-2 * time.Hour),
+			expectedMetro:     "am",
+			expectReservation: true,
+			errString:         "device failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:    cli,
+				events: cli,
+				plans:  cli,
+				spot:   cli,
+				cfg: &config.Config{
+					ProjectID:   "project",
+					StateDir:    t.TempDir(),
+					Reprovision: tt.policy,
+				},
+				controllerID: "controller-1",
+			}
+			failed := metal.Device{
+				Id:    spec.Ptr(failedID),
+				Tags:  tags,
+				State: spec.Ptr(metal.DEVICESTATE_FAILED),
+				Metro: &metal.DeviceMetro{Code: spec.Ptr("am")},
+			}
+			replacement := metal.Device{
+				Id:    spec.Ptr(replacementID),
+				Tags:  tags,
+				State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			}
+
+			created := false
+			for _, id := range []string{failedID, replacementID} {
+				cli.On("FindDeviceById", ctx, id).Return(metal.ApiFindDeviceByIdRequest{
+					ApiService: &metal.DevicesApiService{},
+				}, nil)
+				cli.On("FindDeviceEvents", ctx, id).Return(metal.ApiFindDeviceEventsRequest{
+					ApiService: &metal.EventsApiService{},
+				}, nil)
+			}
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				if created {
+					return &replacement, &http.Response{StatusCode: http.StatusOK}, nil
+				}
+				return &failed, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			DefaultExecuteFindDeviceEvents = func(r metal.ApiFindDeviceEventsRequest) (*metal.EventList, *http.Response, error) {
+				return &metal.EventList{}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			deleted := 0
+			cli.On("DeleteDevice", ctx, failedID).Return(metal.ApiDeleteDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+				deleted++
+				return &http.Response{StatusCode: http.StatusNoContent}, nil
+			}
+			cli.On("FindMetroSpotMarketPrices", ctx).Return(metal.ApiFindMetroSpotMarketPricesRequest{
+				ApiService: &metal.SpotMarketApiService{},
+			}, nil)
+			DefaultExecuteFindMetroSpotMarketPrices = func(r metal.ApiFindMetroSpotMarketPricesRequest) (*metal.SpotMarketPricesPerMetroList, *http.Response, error) {
+				return &metal.SpotMarketPricesPerMetroList{}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli.On("CreateDevice", ctx, "project").Return(metal.ApiCreateDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteCreateDevice = func(r metal.ApiCreateDeviceRequest) (*metal.Device, *http.Response, error) {
+				created = true
+				return &replacement, &http.Response{StatusCode: http.StatusCreated}, nil
+			}
+
+			startedAt := tt.startedAt
+			if startedAt.IsZero() {
+				startedAt = time.Now()
+			}
+			op := &Operation{RunnerName: "runner-1", ControllerID: "controller-1", PoolID: "pool-1", StartedAt: startedAt}
+			input := metal.DeviceCreateInMetroInput{
+				Metro:                 "am",
+				Plan:                  "c3.small.x86",
+				Tags:                  tags,
+				HardwareReservationId: spec.Ptr("reservation-1"),
+			}
+			if tt.spot {
+				input.SpotInstance = spec.Ptr(true)
+			}
+			instance, err := a.provisionDevice(ctx, op, failedID, &input, []string{"c3.small.x86"})
+			assert.Equal(t, tt.expectedMetro, input.Metro)
+			assert.Equal(t, tt.expectReservation, input.HardwareReservationId != nil)
+			if tt.errString != "" {
+				require.Error(t, err)
+				assert.ErrorContains(t, err, tt.errString)
+				assert.False(t, created)
+				assert.Equal(t, 0, deleted)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedID, instance.ProviderID)
+			assert.Equal(t, params.InstanceRunning, instance.Status)
+			assert.Equal(t, 1, deleted)
+			assert.Equal(t, tt.expectedID, op.DeviceID)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+)
+
+// spotBidHistoryWindow is the period of the spot price history spot bids are
+// computed from.
+const spotBidHistoryWindow = 7 * 24 * time.Hour
+
+// spotBid returns the bid of a spot device of the plan in the metro, computed from
+// the spot price history of the last week and capped by the ceiling. If the price
+// history is not available, the bid is computed from the current spot price of the
+// plan in the metro, and the ceiling is bid if that is not available either.
+func (a *equinixProvider) spotBid(ctx context.Context, runnerName, metro, plan string, bid spec.SpotBid, ceiling float64) float64 {
+	now := time.Now()
+	history, err := a.spotPriceHistory(ctx, metro, plan, now.Add(-spotBidHistoryWindow), now)
+	if err == nil && len(history) == 0 {
+		err = fmt.Errorf("no spot price history")
+	}
+	if err != nil {
+		current, currentErr := a.hourlyPrice(ctx, metro, plan, true)
+		if currentErr != nil {
+			log.Printf("runner %s: bidding the ceiling %.4f for plan %s in metro %s: %s, and %s", runnerName, ceiling, plan, metro, err, currentErr)
+			return ceiling
+		}
+		log.Printf("runner %s: computing the spot bid for plan %s in metro %s from the current price %.4f: %s", runnerName, plan, metro, current, err)
+		history = []float64{current}
+	}
+	price, _ := bid.Price(history)
+	if price > ceiling {
+		log.Printf("runner %s: spot bid %s of %.4f for plan %s in metro %s is capped to %.4f", runnerName, bid, price, plan, metro, ceiling)
+		return ceiling
+	}
+	log.Printf("runner %s: spot bid %s for plan %s in metro %s is %.4f", runnerName, bid, plan, metro, price)
+	return price
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpotBid(t *testing.T) {
+	tests := []struct {
+		name         string
+		datapoints   [][]float32
+		err          error
+		currentPrice *float64
+		bid          spec.SpotBid
+		expected     float64
+	}{
+		{
+			name:       "percentile with markup",
+			datapoints: [][]float32{{0.25, 1700000000}, {0.5, 1700003600}, {0.125, 1700007200}, {0.375, 1700010800}},
+			bid:        spec.SpotBid{Percentile: 75, Markup: 10},
+			expected:   0.4125,
+		},
+		{
+			name:       "capped by the ceiling",
+			datapoints: [][]float32{{0.25, 1700000000}, {1.5, 1700003600}},
+			bid:        spec.SpotBid{Percentile: 90},
+			expected:   1,
+		},
+		{
+			name:         "no price history",
+			currentPrice: spec.Ptr(0.25),
+			bid:          spec.SpotBid{Percentile: 90, Markup: 20},
+			expected:     0.3,
+		},
+		{
+			name:         "price history not available",
+			err:          errors.New("boom"),
+			currentPrice: spec.Ptr(0.25),
+			bid:          spec.SpotBid{Percentile: 90},
+			expected:     0.25,
+		},
+		{
+			name:     "no current price either",
+			bid:      spec.SpotBid{Percentile: 90},
+			expected: 1,
+		},
+		{
+			name:     "price history and current price not available",
+			err:      errors.New("boom"),
+			bid:      spec.SpotBid{Percentile: 90},
+			expected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := new(MockClient)
+			a := &equinixProvider{
+				spot: cli,
+				cfg:  &config.Config{ProjectID: "project"},
+			}
+			cli.On("FindSpotMarketPricesHistory", ctx).Return(metal.ApiFindSpotMarketPricesHistoryRequest{
+				ApiService: &metal.SpotMarketApiService{},
+			}, nil)
+			DefaultExecuteFindSpotMarketPricesHistory = func(r metal.ApiFindSpotMarketPricesHistoryRequest) (*metal.SpotPricesHistoryReport, *http.Response, error) {
+				if tt.err != nil {
+					return nil, &http.Response{StatusCode: http.StatusInternalServerError}, tt.err
+				}
+				return &metal.SpotPricesHistoryReport{
+					PricesHistory: &metal.SpotPricesDatapoints{Datapoints: tt.datapoints},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			cli.On("FindMetroSpotMarketPrices", ctx).Return(metal.ApiFindMetroSpotMarketPricesRequest{
+				ApiService: &metal.SpotMarketApiService{},
+			}, nil)
+			DefaultExecuteFindMetroSpotMarketPrices = func(r metal.ApiFindMetroSpotMarketPricesRequest) (*metal.SpotMarketPricesPerMetroList, *http.Response, error) {
+				if tt.currentPrice == nil {
+					return &metal.SpotMarketPricesPerMetroList{}, &http.Response{StatusCode: http.StatusOK}, nil
+				}
+				return &metal.SpotMarketPricesPerMetroList{
+					SpotMarketPrices: &metal.SpotMarketPricesPerMetroReport{
+						Am: &metal.SpotPricesPerFacility{
+							AdditionalProperties: map[string]interface{}{
+								"c3.small.x86": map[string]interface{}{"price": *tt.currentPrice},
+							},
+						},
+					},
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+
+			bid := a.spotBid(ctx, "runner-1", "am", "c3.small.x86", tt.bid, 1)
+			assert.InDelta(t, tt.expected, bid, 1e-6)
+		})
+	}
+}
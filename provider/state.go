@@ -0,0 +1,59 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudbase/garm-provider-equinix/internal/filelock"
+)
+
+// stateDir returns the state directory, which is created and checked on first
+// use. It holds the lock files and records trusted by all the provider processes,
+// so it must be private to the current user. It is only used by the features which
+// share state between provider processes: the provider keeps working without it
+// otherwise.
+func (a *equinixProvider) stateDir() (string, error) {
+	a.stateDirOnce.Do(func() {
+		dir := a.cfg.GetStateDir()
+		if err := filelock.PrivateDir(dir); err != nil {
+			a.stateDirErr = fmt.Errorf("invalid state directory: %w", err)
+			return
+		}
+		a.stateDirPath = dir
+	})
+	return a.stateDirPath, a.stateDirErr
+}
+
+// statePath returns the path of a file or directory in the state directory.
+func (a *equinixProvider) statePath(elem ...string) (string, error) {
+	dir, err := a.stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
+
+// lockState takes the lock file of the given name in the state directory, and
+// returns the function releasing it. It gives up when ctx is done.
+func (a *equinixProvider) lockState(ctx context.Context, name string) (func() error, error) {
+	path, err := a.statePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return filelock.LockContext(ctx, path)
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateDir(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "state")
+	a := &equinixProvider{cfg: &config.Config{StateDir: dir}}
+	// The state directory is only created on first use.
+	_, err := os.Stat(dir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	path, err := a.statePath("journal", "runner.json")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "journal", "runner.json"), path)
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	unlock, err := a.lockState(ctx, "quotas.lock")
+	require.NoError(t, err)
+	require.NoError(t, unlock())
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	a = &equinixProvider{cfg: &config.Config{StateDir: file}}
+	_, err = a.lockState(ctx, "quotas.lock")
+	assert.ErrorContains(t, err, "invalid state directory")
+}
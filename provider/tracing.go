@@ -0,0 +1,47 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// serviceName is the OpenTelemetry service name of the provider.
+const serviceName = "garm-provider-equinix"
+
+// newTracer returns the tracer configured by cfg, or nil if tracing is disabled.
+func newTracer(cfg config.TracingConfig) (*tracing.Tracer, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch {
+	case cfg.OTLPEndpoint != "":
+		exporter, err = tracing.NewHTTPExporter(context.Background(), cfg.OTLPEndpoint, cfg.OTLPHeaders)
+	case cfg.File != "":
+		exporter, err = tracing.NewFileExporter(cfg.File)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tracing.NewTracer(exporter,
+		tracing.String("service.name", serviceName),
+		tracing.String("service.version", Version),
+	), nil
+}
@@ -18,11 +18,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	"github.com/cloudbase/garm-provider-equinix/internal/tracing"
 	"github.com/google/uuid"
 	"github.com/juju/clock"
 	"github.com/juju/retry"
@@ -34,18 +37,32 @@ import (
 
 var (
 	errStopRetry = errors.New("stop retry")
+
+	// The errors below classify the reasons a device failed to provision.
+	errCreateDevice        = errors.New("failed to create device")
+	errNoCapacity          = errors.New("no capacity available")
+	errDeviceFailed        = errors.New("device failed")
+	errDeviceDeleted       = errors.New("device deleted")
+	errProvisioningTimeout = errors.New("timed out waiting for the device to become active")
 )
 
+// maxFaultEvents is the maximum number of events included in a fault summary.
+const maxFaultEvents = 5
+
+// statusMap maps every Equinix Metal device state to a GARM instance status.
+// Devices in a state that is not in this map are reported with an unknown status.
 var statusMap = map[metal.DeviceState]params.InstanceStatus{
-	metal.DEVICESTATE_QUEUED:       params.InstanceRunning,
-	metal.DEVICESTATE_PROVISIONING: params.InstanceRunning,
-	metal.DEVICESTATE_ACTIVE:       params.InstanceRunning,
-	metal.DEVICESTATE_INACTIVE:     params.InstanceStopped,
-	metal.DEVICESTATE_FAILED:       params.InstanceError,
-	metal.DEVICESTATE_DELETED:      params.InstanceStopped,
-	metal.DEVICESTATE_POWERING_ON:  params.InstanceRunning,
-	metal.DEVICESTATE_POWERING_OFF: params.InstanceStopped,
-	metal.DeviceState(""):          params.InstanceStatusUnknown,
+	metal.DEVICESTATE_QUEUED:         params.InstanceRunning,
+	metal.DEVICESTATE_PROVISIONING:   params.InstanceRunning,
+	metal.DEVICESTATE_DEPROVISIONING: params.InstanceDeleting,
+	metal.DEVICESTATE_REINSTALLING:   params.InstanceRunning,
+	metal.DEVICESTATE_ACTIVE:         params.InstanceRunning,
+	metal.DEVICESTATE_INACTIVE:       params.InstanceStopped,
+	metal.DEVICESTATE_FAILED:         params.InstanceError,
+	metal.DEVICESTATE_DELETED:        params.InstanceStopped,
+	metal.DEVICESTATE_POWERING_ON:    params.InstanceRunning,
+	metal.DEVICESTATE_POWERING_OFF:   params.InstanceStopped,
+	metal.DeviceState(""):            params.InstanceStatusUnknown,
 }
 
 type ExecuteFindDeviceByID func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error)
@@ -53,40 +70,69 @@ type ExecuteFindProjectDevices func(r metal.ApiFindProjectDevicesRequest) (*meta
 type ExecuteDeleteDevice func(r metal.ApiDeleteDeviceRequest) (*http.Response, error)
 type ExecuteCreateDevice func(r metal.ApiCreateDeviceRequest) (*metal.Device, *http.Response, error)
 type ExecutePerformAction func(r metal.ApiPerformActionRequest) (*http.Response, error)
+type ExecuteUpdateDevice func(r metal.ApiUpdateDeviceRequest) (*metal.Device, *http.Response, error)
+type ExecuteFindDeviceEvents func(r metal.ApiFindDeviceEventsRequest) (*metal.EventList, *http.Response, error)
+type ExecuteFindOperatingSystems func(r metal.ApiFindOperatingSystemsRequest) (*metal.OperatingSystemList, *http.Response, error)
+type ExecuteCaptureScreenshot func(r metal.ApiCaptureScreenshotRequest) (*os.File, *http.Response, error)
+type ExecuteFindPlansByProject func(r metal.ApiFindPlansByProjectRequest) (*metal.PlanList, *http.Response, error)
+type ExecuteFindMetroSpotMarketPrices func(r metal.ApiFindMetroSpotMarketPricesRequest) (*metal.SpotMarketPricesPerMetroList, *http.Response, error)
+type ExecuteFindSpotMarketPricesHistory func(r metal.ApiFindSpotMarketPricesHistoryRequest) (*metal.SpotPricesHistoryReport, *http.Response, error)
+type ExecuteCheckCapacityForMetro func(r metal.ApiCheckCapacityForMetroRequest) (*metal.CapacityCheckPerMetroList, *http.Response, error)
+type ExecuteFindProjectUsage func(r metal.ApiFindProjectUsageRequest) (*metal.ProjectUsageList, *http.Response, error)
 
 var (
-	DefaultExecuteFindDeviceByID     ExecuteFindDeviceByID     = metal.ApiFindDeviceByIdRequest.Execute
-	DefaultExecuteFindProjectDevices ExecuteFindProjectDevices = metal.ApiFindProjectDevicesRequest.Execute
-	DefaultExecuteDeleteDevice       ExecuteDeleteDevice       = metal.ApiDeleteDeviceRequest.Execute
-	DefaultExecuteCreateDevice       ExecuteCreateDevice       = metal.ApiCreateDeviceRequest.Execute
-	DefaultExecutePerformAction      ExecutePerformAction      = metal.ApiPerformActionRequest.Execute
+	DefaultExecuteFindDeviceByID              ExecuteFindDeviceByID              = metal.ApiFindDeviceByIdRequest.Execute
+	DefaultExecuteFindProjectDevices          ExecuteFindProjectDevices          = metal.ApiFindProjectDevicesRequest.Execute
+	DefaultExecuteDeleteDevice                ExecuteDeleteDevice                = metal.ApiDeleteDeviceRequest.Execute
+	DefaultExecuteCreateDevice                ExecuteCreateDevice                = metal.ApiCreateDeviceRequest.Execute
+	DefaultExecutePerformAction               ExecutePerformAction               = metal.ApiPerformActionRequest.Execute
+	DefaultExecuteUpdateDevice                ExecuteUpdateDevice                = metal.ApiUpdateDeviceRequest.Execute
+	DefaultExecuteFindDeviceEvents            ExecuteFindDeviceEvents            = metal.ApiFindDeviceEventsRequest.Execute
+	DefaultExecuteFindOperatingSystems        ExecuteFindOperatingSystems        = metal.ApiFindOperatingSystemsRequest.Execute
+	DefaultExecuteCaptureScreenshot           ExecuteCaptureScreenshot           = metal.ApiCaptureScreenshotRequest.Execute
+	DefaultExecuteFindPlansByProject          ExecuteFindPlansByProject          = metal.ApiFindPlansByProjectRequest.Execute
+	DefaultExecuteFindMetroSpotMarketPrices   ExecuteFindMetroSpotMarketPrices   = metal.ApiFindMetroSpotMarketPricesRequest.Execute
+	DefaultExecuteFindSpotMarketPricesHistory ExecuteFindSpotMarketPricesHistory = metal.ApiFindSpotMarketPricesHistoryRequest.Execute
+	DefaultExecuteCheckCapacityForMetro       ExecuteCheckCapacityForMetro       = metal.ApiCheckCapacityForMetroRequest.Execute
+	DefaultExecuteFindProjectUsage            ExecuteFindProjectUsage            = metal.ApiFindProjectUsageRequest.Execute
 )
 
-func equinixToGarmInstance(device metal.Device) (params.ProviderInstance, error) {
-	tags := extractTagsAsMap(device)
-	name, ok := tags["Name"]
-	if !ok {
-		return params.ProviderInstance{}, fmt.Errorf("missing Name property")
+// nonLinuxDistros holds the Equinix Metal distros that are neither Linux nor Windows.
+var nonLinuxDistros = map[string]bool{
+	"freebsd":     true,
+	"vmware":      true,
+	"custom_ipxe": true,
+}
+
+func equinixToGarmInstance(device metal.Device, names spec.TagNames) (params.ProviderInstance, error) {
+	meta := deviceMetadataOf(device, names)
+	if meta.RunnerName == "" {
+		return params.ProviderInstance{}, fmt.Errorf("missing runner name")
 	}
 
 	instance := params.ProviderInstance{
-		ProviderID: device.GetId(),
-		Name:       name,
-		Status:     statusMap[device.GetState()],
+		ProviderID: providerIDOf(device, meta.RunnerName, names),
+		Name:       meta.RunnerName,
+		OSType:     meta.OSType,
+		OSArch:     meta.OSArch,
+		Status:     deviceStatus(device.GetState()),
 	}
 
-	if instance.ProviderID == "" {
+	if device.GetId() == "" {
 		return params.ProviderInstance{}, fmt.Errorf("device ID is empty")
 	}
 
-	for key, val := range tags {
-		switch key {
-		case "OSType":
-			instance.OSType = params.OSType(val)
-		case "OSArch":
-			instance.OSArch = params.OSArch(val)
-		case "Name":
-			instance.Name = val
+	if device.GetState() == metal.DEVICESTATE_FAILED {
+		if fault := summarizeEvents(device.GetProvisioningEvents()); fault != "" {
+			instance.ProviderFault = []byte(fault)
+		}
+	}
+
+	if operatingSystem, ok := device.GetOperatingSystemOk(); ok {
+		instance.OSName = operatingSystem.GetDistro()
+		instance.OSVersion = operatingSystem.GetVersion()
+		if instance.OSType == "" {
+			instance.OSType = osTypeFromDistro(operatingSystem.GetDistro())
 		}
 	}
 
@@ -104,87 +150,315 @@ func equinixToGarmInstance(device metal.Device) (params.ProviderInstance, error)
 	return instance, nil
 }
 
+// deviceStatus returns the GARM instance status for an Equinix Metal device state.
+func deviceStatus(state metal.DeviceState) params.InstanceStatus {
+	status, ok := statusMap[state]
+	if !ok {
+		return params.InstanceStatusUnknown
+	}
+	return status
+}
+
+// isStuckProvisioning returns true if the device has been queued or provisioning
+// for longer than the given timeout.
+func isStuckProvisioning(device metal.Device, timeout time.Duration, now time.Time) bool {
+	switch device.GetState() {
+	case metal.DEVICESTATE_QUEUED, metal.DEVICESTATE_PROVISIONING:
+	default:
+		return false
+	}
+	createdAt, ok := device.GetCreatedAtOk()
+	if !ok {
+		return false
+	}
+	return now.Sub(*createdAt) > timeout
+}
+
+// osTypeFromDistro returns the GARM OS type of an Equinix Metal distro. An empty
+// OS type is returned if the distro is unknown or is neither Linux nor Windows.
+func osTypeFromDistro(distro string) params.OSType {
+	distro = strings.ToLower(distro)
+	switch {
+	case distro == "":
+		return ""
+	case distro == "windows":
+		return params.Windows
+	case nonLinuxDistros[distro]:
+		return ""
+	default:
+		return params.Linux
+	}
+}
+
+// toGarmInstance converts a device to a GARM instance. Devices stuck provisioning
+// are reported as errored, and operating system details missing from older devices
+// are looked up in the Equinix Metal OS catalogue.
+func (a *equinixProvider) toGarmInstance(ctx context.Context, device metal.Device) (params.ProviderInstance, error) {
+	instance, err := equinixToGarmInstance(device, a.cfg.GetTagNames())
+	if err != nil {
+		return params.ProviderInstance{}, err
+	}
+
+	timeout := a.cfg.GetProvisioningTimeout()
+	if isStuckProvisioning(device, timeout, time.Now()) {
+		instance.Status = params.InstanceError
+		instance.ProviderFault = []byte(fmt.Sprintf("device has been %s for more than %s", device.GetState(), timeout))
+	}
+
+	slug := device.OperatingSystem.GetSlug()
+	if slug == "" || (instance.OSName != "" && instance.OSVersion != "" && instance.OSType != "") {
+		return instance, nil
+	}
+
+	operatingSystem, ok := a.lookupOperatingSystem(ctx, slug)
+	if !ok {
+		return instance, nil
+	}
+	if instance.OSName == "" {
+		instance.OSName = operatingSystem.GetDistro()
+	}
+	if instance.OSVersion == "" {
+		instance.OSVersion = operatingSystem.GetVersion()
+	}
+	if instance.OSType == "" {
+		instance.OSType = osTypeFromDistro(operatingSystem.GetDistro())
+	}
+	return instance, nil
+}
+
+// lookupOperatingSystem returns the operating system with the given slug from the
+// Equinix Metal OS catalogue. The catalogue is fetched once and cached. Errors are
+// ignored.
+func (a *equinixProvider) lookupOperatingSystem(ctx context.Context, slug string) (metal.OperatingSystem, bool) {
+	a.osCatalogMu.Lock()
+	defer a.osCatalogMu.Unlock()
+
+	if a.osCatalog == nil {
+		a.osCatalog = map[string]metal.OperatingSystem{}
+		osList, _, err := DefaultExecuteFindOperatingSystems(a.os.FindOperatingSystems(ctx))
+		if err == nil && osList != nil {
+			for _, operatingSystem := range osList.GetOperatingSystems() {
+				a.osCatalog[operatingSystem.GetSlug()] = operatingSystem
+			}
+		}
+	}
+	operatingSystem, ok := a.osCatalog[slug]
+	return operatingSystem, ok
+}
+
 func (a *equinixProvider) waitDeviceActive(ctx context.Context, deviceID string) (params.ProviderInstance, error) {
 	var p params.ProviderInstance
-	err := retry.Call(retry.CallArgs{
-		IsFatalError: func(err error) bool {
-			return errors.Is(err, errStopRetry)
-		},
-		Func: func() error {
-			var err error
-			device, _, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
-			if err != nil {
-				return fmt.Errorf("failed to find device: %w", errStopRetry)
+	poll := func(ctx context.Context, span *tracing.Span) error {
+		var err error
+		device, _, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+		if err != nil {
+			return fmt.Errorf("failed to find device: %w", errStopRetry)
+		}
+		if device == nil {
+			return fmt.Errorf("device not found: %w", errStopRetry)
+		}
+
+		state := device.GetState()
+		span.SetAttributes(
+			tracing.String("equinix.device_state", string(state)),
+			tracing.Int("equinix.provisioning_percentage", int(device.GetProvisioningPercentage())),
+		)
+		switch state {
+		case metal.DEVICESTATE_FAILED:
+			if fault := a.withConsoleLog(ctx, *device, a.deviceFault(ctx, *device)); fault != "" {
+				return fmt.Errorf("%w (%s): %w", errDeviceFailed, fault, errStopRetry)
 			}
-			if device == nil {
-				return fmt.Errorf("device not found: %w", errStopRetry)
+			return fmt.Errorf("%w: %w", errDeviceFailed, errStopRetry)
+		case metal.DEVICESTATE_DELETED:
+			return fmt.Errorf("%w: %w", errDeviceDeleted, errStopRetry)
+		case metal.DEVICESTATE_POWERING_OFF, metal.DEVICESTATE_INACTIVE:
+			return fmt.Errorf("invalid state change: %w", errStopRetry)
+		case metal.DEVICESTATE_ACTIVE:
+			p, err = a.toGarmInstance(ctx, *device)
+			if err != nil {
+				return fmt.Errorf("failed to convert device: %w", errStopRetry)
 			}
+			return nil
+		}
 
-			state := device.GetState()
-			switch state {
-			case metal.DEVICESTATE_FAILED:
-				return fmt.Errorf("device failed: %w", errStopRetry)
-			case metal.DEVICESTATE_DELETED:
-				return fmt.Errorf("device deleted: %w", errStopRetry)
-			case metal.DEVICESTATE_POWERING_OFF, metal.DEVICESTATE_INACTIVE:
-				return fmt.Errorf("invalid state change: %w", errStopRetry)
-			case metal.DEVICESTATE_ACTIVE:
-				p, err = equinixToGarmInstance(*device)
-				if err != nil {
-					return fmt.Errorf("failed to convert device: %w", errStopRetry)
-				}
-				return nil
+		// Return potentially a lot earlier than the instance is active. Equinix seems to wait for
+		// user-data scripts to finish running before it phones home. This might cause status updates
+		// to arive while the instance is still in "creating" state.
+		// This is currently allowed, although it might change in the future.
+		if device.GetProvisioningPercentage() >= 90 {
+			p, err = a.toGarmInstance(ctx, *device)
+			if err != nil {
+				return fmt.Errorf("failed to convert device: %w", errStopRetry)
 			}
+			return nil
+		}
+		return fmt.Errorf("instance not active yet")
+	}
 
-			// Return potentially a lot earlier than the instance is active. Equinix seems to wait for
-			// user-data scripts to finish running before it phones home. This might cause status updates
-			// to arive while the instance is still in "creating" state.
-			// This is currently allowed, although it might change in the future.
-			if device.GetProvisioningPercentage() >= 90 {
-				p, err = equinixToGarmInstance(*device)
-				if err != nil {
-					return fmt.Errorf("failed to convert device: %w", errStopRetry)
-				}
-				return nil
+	err := retry.Call(retry.CallArgs{
+		IsFatalError: func(err error) bool {
+			return errors.Is(err, errStopRetry)
+		},
+		Func: func() error {
+			ctx, span := a.tracer.Start(ctx, "waitDeviceActive.poll", tracing.SpanKindInternal, tracing.String("equinix.device_id", deviceID))
+			err := poll(ctx, span)
+			// Polling again is expected while the device is provisioning, so only
+			// fatal errors fail the span.
+			if errors.Is(err, errStopRetry) {
+				span.End(err)
+			} else {
+				span.End(nil)
 			}
-			return fmt.Errorf("instance not active yet")
+			return err
 		},
-		// Roughly 20 minutes. Might be longer if API calls take longer.
+		// Roughly 20 minutes. Might be longer if API calls take longer. Devices
+		// taking longer are reported by GetInstance once provisioning_timeout is over.
 		Attempts: 240,
 		Delay:    5 * time.Second,
 		Clock:    clock.WallClock,
 	})
 
 	if err != nil {
+		if retry.IsAttemptsExceeded(err) {
+			device, _, findErr := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+			if findErr == nil && device != nil {
+				if path, _ := a.captureConsoleLog(ctx, *device, errProvisioningTimeout.Error()); path != "" {
+					err = fmt.Errorf("%w (console log: %s)", err, path)
+				}
+			}
+			err = fmt.Errorf("%w: %w", errProvisioningTimeout, err)
+		}
 		return params.ProviderInstance{}, fmt.Errorf("failed to wait for instance to become active: %w", err)
 	}
 	return p, nil
 }
 
+// devicesPerPage is the number of devices requested per page when listing the
+// devices of the project. The API returns 10 devices per page by default.
+const devicesPerPage = 1000
+
+// fetchProjectDevices lists all the devices of the project from the API, one page
+// at a time.
+func (a *equinixProvider) fetchProjectDevices(ctx context.Context) ([]metal.Device, error) {
+	ret := []metal.Device{}
+	req := a.cli.FindProjectDevices(ctx, a.cfg.ProjectID).PerPage(devicesPerPage)
+	for page := int32(1); ; page++ {
+		devices, _, err := DefaultExecuteFindProjectDevices(req.Page(page))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, devices.GetDevices()...)
+		if devices.Meta == nil || devices.Meta.GetLastPage() <= page {
+			return ret, nil
+		}
+	}
+}
+
 func (a *equinixProvider) findInstancesByName(ctx context.Context, instance string) ([]metal.Device, error) {
 	ret := []metal.Device{}
 
-	devices, _, err := DefaultExecuteFindProjectDevices(a.cli.FindProjectDevices(ctx, a.cfg.ProjectID))
+	devices, err := a.fetchProjectDevices(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list devices: %w", err)
 	}
 
-	for _, dev := range devices.Devices {
-		tags := extractTagsAsMap(dev)
-		name, ok := tags["Name"]
-		if !ok {
+	for _, dev := range devices {
+		if a.isManagedDevice(dev) && deviceMetadataOf(dev, a.cfg.GetTagNames()).RunnerName == instance {
+			ret = append(ret, dev)
+		}
+	}
+
+	return ret, nil
+}
+
+// deviceFault returns a summary of the provisioning events of a device, merged
+// with the device events recorded by the Equinix Metal API. Errors while fetching
+// the device events are ignored, as the fault is informative only.
+func (a *equinixProvider) deviceFault(ctx context.Context, device metal.Device) string {
+	events := device.GetProvisioningEvents()
+	eventList, _, err := DefaultExecuteFindDeviceEvents(a.events.FindDeviceEvents(ctx, device.GetId()))
+	if err == nil && eventList != nil {
+		events = append(events, eventList.GetEvents()...)
+	}
+	return summarizeEvents(events)
+}
+
+// summarizeEvents returns a short, human readable summary of the most recent
+// events that carry a message. Duplicate events are skipped.
+func summarizeEvents(events []metal.Event) string {
+	seen := map[string]bool{}
+	filtered := []metal.Event{}
+	for _, event := range events {
+		msg := event.GetInterpolated()
+		if msg == "" {
+			msg = event.GetBody()
+		}
+		if msg == "" {
 			continue
 		}
-		controllerID, ok := tags[spec.ControllerIDTagName]
-		if !ok {
+		key := event.GetId()
+		if key == "" {
+			key = msg
+		}
+		if seen[key] {
 			continue
 		}
-		if controllerID == a.controllerID && name == instance {
-			ret = append(ret, dev)
+		seen[key] = true
+		filtered = append(filtered, event)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].GetCreatedAt().Before(filtered[j].GetCreatedAt())
+	})
+	if len(filtered) > maxFaultEvents {
+		filtered = filtered[len(filtered)-maxFaultEvents:]
+	}
+
+	messages := make([]string, 0, len(filtered))
+	for _, event := range filtered {
+		msg := event.GetInterpolated()
+		if msg == "" {
+			msg = event.GetBody()
 		}
+		if event.GetType() != "" {
+			msg = fmt.Sprintf("%s: %s", event.GetType(), msg)
+		}
+		messages = append(messages, msg)
 	}
+	return strings.Join(messages, "; ")
+}
 
-	return ret, nil
+// findExistingDevice returns the oldest device created by this controller for the
+// given runner name that is not failed or being removed. A nil device is returned
+// if no such device exists.
+func (a *equinixProvider) findExistingDevice(ctx context.Context, name string) (*metal.Device, error) {
+	devices, err := a.findInstancesByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return oldestLiveDevice(devices), nil
+}
+
+// oldestLiveDevice returns the oldest device that is not failed or being removed,
+// or nil if there is no such device.
+func oldestLiveDevice(devices []metal.Device) *metal.Device {
+	var ret *metal.Device
+	for idx, device := range devices {
+		switch device.GetState() {
+		case metal.DEVICESTATE_FAILED, metal.DEVICESTATE_DELETED, metal.DEVICESTATE_DEPROVISIONING:
+			continue
+		}
+		if ret == nil || device.GetCreatedAt().Before(ret.GetCreatedAt()) {
+			ret = &devices[idx]
+		}
+	}
+	return ret
+}
+
+// isNotFoundResponse returns true if the Equinix Metal API reported the requested
+// resource as missing. The API returns 403 for devices that were deleted.
+func isNotFoundResponse(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden)
 }
 
 func extractTagsAsMap(device metal.Device) map[string]string {
@@ -225,20 +499,18 @@ func (a *equinixProvider) deleteOneInstance(ctx context.Context, instanceID stri
 	}
 	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, instanceID))
 	if err != nil {
-		if resp != nil && (resp.StatusCode == 404 || resp.StatusCode == 403) {
+		if isNotFoundResponse(resp) {
 			return nil
 		}
 		return fmt.Errorf("failed to find device: %w", err)
 	}
+	// Devices still queued or provisioning are deleted right away. If the API
+	// refuses, the error is returned and the deletion retried later.
 	state := device.GetState()
-	if state == metal.DEVICESTATE_PROVISIONING || state == metal.DEVICESTATE_QUEUED {
-		if _, err := a.waitDeviceActive(ctx, instanceID); err != nil {
-			return fmt.Errorf("failed to wait for device: %w", err)
-		}
-	}
 	resp, err = DefaultExecuteDeleteDevice(a.cli.DeleteDevice(ctx, instanceID))
+	a.invalidateDeviceCache()
 	if err != nil {
-		if resp != nil && (resp.StatusCode == 404 || resp.StatusCode == 403) {
+		if isNotFoundResponse(resp) {
 			return nil
 		}
 		if state == metal.DEVICESTATE_DELETED || state == metal.DEVICESTATE_FAILED {
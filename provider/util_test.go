@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-equinix/config"
@@ -78,12 +79,61 @@ func TestEquinixToGarmInstance(t *testing.T) {
 			errString: "",
 		},
 		{
-			name: "missing Name tag",
+			name: "device with operating system",
+			device: metal.Device{
+				Id: spec.Ptr("mock-id"),
+				Tags: []string{
+					"Name=mock-name",
+					"OSArch=amd64",
+				},
+				OperatingSystem: &metal.OperatingSystem{
+					Distro:  spec.Ptr("ubuntu"),
+					Version: spec.Ptr("22.04"),
+					Slug:    spec.Ptr("ubuntu_22_04"),
+				},
+				State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+			},
+			expectedOutput: params.ProviderInstance{
+				ProviderID: deviceID,
+				Name:       "mock-name",
+				Status:     params.InstanceRunning,
+				OSType:     params.Linux,
+				OSName:     "ubuntu",
+				OSVersion:  "22.04",
+				OSArch:     params.Amd64,
+			},
+			errString: "",
+		},
+		{
+			name: "failed device with provisioning events",
+			device: metal.Device{
+				Id: spec.Ptr("mock-id"),
+				Tags: []string{
+					"Name=mock-name",
+				},
+				State: spec.Ptr(metal.DEVICESTATE_FAILED),
+				ProvisioningEvents: []metal.Event{
+					{
+						Type:         spec.Ptr("provisioning.failed"),
+						Interpolated: spec.Ptr("hardware failure during provisioning"),
+					},
+				},
+			},
+			expectedOutput: params.ProviderInstance{
+				ProviderID:    deviceID,
+				Name:          "mock-name",
+				Status:        params.InstanceError,
+				ProviderFault: []byte("provisioning.failed: hardware failure during provisioning"),
+			},
+			errString: "",
+		},
+		{
+			name: "missing runner name",
 			device: metal.Device{
 				Id: spec.Ptr("mock-id"),
 			},
 			expectedOutput: params.ProviderInstance{},
-			errString:      "missing Name property",
+			errString:      "missing runner name",
 		},
 		{
 			name: "empty device ID",
@@ -97,7 +147,7 @@ func TestEquinixToGarmInstance(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			instance, err := equinixToGarmInstance(tt.device)
+			instance, err := equinixToGarmInstance(tt.device, spec.LegacyTagNames)
 			if tt.errString != "" {
 				require.Error(t, err, tt.errString)
 			} else {
@@ -152,15 +202,240 @@ func TestWaitDeviceActive(t *testing.T) {
 	assert.Equal(t, expectedOutput, output)
 }
 
+func TestDeviceStatus(t *testing.T) {
+	for _, state := range metal.AllowedDeviceStateEnumValues {
+		t.Run(string(state), func(t *testing.T) {
+			_, ok := statusMap[state]
+			assert.True(t, ok, "device state %q is not mapped", state)
+			assert.NotEqual(t, params.InstanceStatusUnknown, deviceStatus(state))
+		})
+	}
+
+	assert.Equal(t, params.InstanceStatusUnknown, deviceStatus(metal.DeviceState("")))
+	assert.Equal(t, params.InstanceStatusUnknown, deviceStatus(metal.DeviceState("migrating")))
+	assert.Equal(t, params.InstanceDeleting, deviceStatus(metal.DEVICESTATE_DEPROVISIONING))
+	assert.Equal(t, params.InstanceError, deviceStatus(metal.DEVICESTATE_FAILED))
+}
+
+func TestIsStuckProvisioning(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		device         metal.Device
+		expectedOutput bool
+	}{
+		{
+			name: "recently queued",
+			device: metal.Device{
+				State:     spec.Ptr(metal.DEVICESTATE_QUEUED),
+				CreatedAt: spec.Ptr(now.Add(-time.Minute)),
+			},
+			expectedOutput: false,
+		},
+		{
+			name: "provisioning for too long",
+			device: metal.Device{
+				State:     spec.Ptr(metal.DEVICESTATE_PROVISIONING),
+				CreatedAt: spec.Ptr(now.Add(-2 * time.Hour)),
+			},
+			expectedOutput: true,
+		},
+		{
+			name: "active for a long time",
+			device: metal.Device{
+				State:     spec.Ptr(metal.DEVICESTATE_ACTIVE),
+				CreatedAt: spec.Ptr(now.Add(-2 * time.Hour)),
+			},
+			expectedOutput: false,
+		},
+		{
+			name: "missing creation time",
+			device: metal.Device{
+				State: spec.Ptr(metal.DEVICESTATE_PROVISIONING),
+			},
+			expectedOutput: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedOutput, isStuckProvisioning(tt.device, time.Hour, now))
+		})
+	}
+}
+
+func TestToGarmInstanceStuckProvisioning(t *testing.T) {
+	ctx := context.Background()
+	a := &equinixProvider{
+		cfg: &config.Config{
+			ProvisioningTimeout: 30 * time.Minute,
+		},
+		controllerID: "mock-controller-id",
+	}
+	device := metal.Device{
+		Id: spec.Ptr("mock-id"),
+		Tags: []string{
+			"Name=mock-name",
+		},
+		State:     spec.Ptr(metal.DEVICESTATE_PROVISIONING),
+		CreatedAt: spec.Ptr(time.Now().Add(-time.Hour)),
+	}
+
+	output, err := a.toGarmInstance(ctx, device)
+	require.NoError(t, err)
+	assert.Equal(t, params.InstanceError, output.Status)
+	assert.Equal(t, "device has been provisioning for more than 30m0s", string(output.ProviderFault))
+}
+
+func TestOSTypeFromDistro(t *testing.T) {
+	tests := []struct {
+		distro         string
+		expectedOutput params.OSType
+	}{
+		{distro: "", expectedOutput: ""},
+		{distro: "ubuntu", expectedOutput: params.Linux},
+		{distro: "rocky", expectedOutput: params.Linux},
+		{distro: "Windows", expectedOutput: params.Windows},
+		{distro: "freebsd", expectedOutput: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.distro, func(t *testing.T) {
+			assert.Equal(t, tt.expectedOutput, osTypeFromDistro(tt.distro))
+		})
+	}
+}
+
+func TestToGarmInstanceOSCatalogFallback(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli:          cli,
+		os:           cli,
+		cfg:          &config.Config{},
+		controllerID: "mock-controller-id",
+	}
+	device := metal.Device{
+		Id: spec.Ptr("mock-id"),
+		Tags: []string{
+			"Name=mock-name",
+		},
+		OperatingSystem: &metal.OperatingSystem{
+			Slug: spec.Ptr("windows_2022"),
+		},
+		State: spec.Ptr(metal.DEVICESTATE_ACTIVE),
+	}
+	cli.On("FindOperatingSystems", ctx).Return(metal.ApiFindOperatingSystemsRequest{
+		ApiService: &metal.OperatingSystemsApiService{},
+	}, nil).Once()
+	DefaultExecuteFindOperatingSystems = func(r metal.ApiFindOperatingSystemsRequest) (*metal.OperatingSystemList, *http.Response, error) {
+		return &metal.OperatingSystemList{
+			OperatingSystems: []metal.OperatingSystem{
+				{
+					Slug:    spec.Ptr("windows_2022"),
+					Distro:  spec.Ptr("windows"),
+					Version: spec.Ptr("2022"),
+				},
+			},
+		}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	expectedOutput := params.ProviderInstance{
+		ProviderID: "mock-id",
+		Name:       "mock-name",
+		Status:     params.InstanceRunning,
+		OSType:     params.Windows,
+		OSName:     "windows",
+		OSVersion:  "2022",
+	}
+
+	output, err := a.toGarmInstance(ctx, device)
+	require.NoError(t, err)
+	assert.Equal(t, expectedOutput, output)
+
+	// The catalogue is cached after the first lookup.
+	output, err = a.toGarmInstance(ctx, device)
+	require.NoError(t, err)
+	assert.Equal(t, expectedOutput, output)
+	cli.AssertExpectations(t)
+}
+
+func TestSummarizeEvents(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		events         []metal.Event
+		expectedOutput string
+	}{
+		{
+			name:           "no events",
+			events:         nil,
+			expectedOutput: "",
+		},
+		{
+			name: "events are sorted and deduplicated",
+			events: []metal.Event{
+				{
+					Id:           spec.Ptr("event-2"),
+					Type:         spec.Ptr("provisioning.failed"),
+					Interpolated: spec.Ptr("hardware failure during provisioning"),
+					CreatedAt:    spec.Ptr(now),
+				},
+				{
+					Id:        spec.Ptr("event-1"),
+					Type:      spec.Ptr("provisioning.started"),
+					Body:      spec.Ptr("Provisioning started"),
+					CreatedAt: spec.Ptr(now.Add(-time.Minute)),
+				},
+				{
+					Id:           spec.Ptr("event-2"),
+					Type:         spec.Ptr("provisioning.failed"),
+					Interpolated: spec.Ptr("hardware failure during provisioning"),
+					CreatedAt:    spec.Ptr(now),
+				},
+				{
+					Id:   spec.Ptr("event-3"),
+					Type: spec.Ptr("empty.event"),
+				},
+			},
+			expectedOutput: "provisioning.started: Provisioning started; provisioning.failed: hardware failure during provisioning",
+		},
+		{
+			name: "only the most recent events are kept",
+			events: []metal.Event{
+				{Body: spec.Ptr("event 1"), CreatedAt: spec.Ptr(now.Add(1 * time.Second))},
+				{Body: spec.Ptr("event 2"), CreatedAt: spec.Ptr(now.Add(2 * time.Second))},
+				{Body: spec.Ptr("event 3"), CreatedAt: spec.Ptr(now.Add(3 * time.Second))},
+				{Body: spec.Ptr("event 4"), CreatedAt: spec.Ptr(now.Add(4 * time.Second))},
+				{Body: spec.Ptr("event 5"), CreatedAt: spec.Ptr(now.Add(5 * time.Second))},
+				{Body: spec.Ptr("event 6"), CreatedAt: spec.Ptr(now.Add(6 * time.Second))},
+			},
+			expectedOutput: "event 2; event 3; event 4; event 5; event 6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedOutput, summarizeEvents(tt.events))
+		})
+	}
+}
+
 func TestWaitDeviceActiveFails(t *testing.T) {
 	ctx := context.Background()
 	deviceID := "mock-id"
 	cli := new(MockClient)
 	a := &equinixProvider{
 		cli:          cli,
+		events:       cli,
 		cfg:          &config.Config{},
 		controllerID: "mock-controller-id",
 	}
+	cli.On("FindDeviceEvents", ctx, deviceID).Return(metal.ApiFindDeviceEventsRequest{
+		ApiService: &metal.EventsApiService{},
+	}, nil)
+	DefaultExecuteFindDeviceEvents = func(r metal.ApiFindDeviceEventsRequest) (*metal.EventList, *http.Response, error) {
+		return &metal.EventList{}, &http.Response{StatusCode: http.StatusOK}, nil
+	}
 	tests := []struct {
 		name      string
 		device    *metal.Device
@@ -174,10 +449,25 @@ func TestWaitDeviceActiveFails(t *testing.T) {
 		{
 			name: "failed device",
 			device: &metal.Device{
+				Id:    spec.Ptr(deviceID),
 				State: spec.Ptr(metal.DEVICESTATE_FAILED),
 			},
 			errString: "device failed",
 		},
+		{
+			name: "failed device with provisioning events",
+			device: &metal.Device{
+				Id:    spec.Ptr(deviceID),
+				State: spec.Ptr(metal.DEVICESTATE_FAILED),
+				ProvisioningEvents: []metal.Event{
+					{
+						Type:         spec.Ptr("provisioning.failed"),
+						Interpolated: spec.Ptr("hardware failure during provisioning"),
+					},
+				},
+			},
+			errString: "device failed (provisioning.failed: hardware failure during provisioning)",
+		},
 		{
 			name: "device deleted",
 			device: &metal.Device{
@@ -257,6 +547,107 @@ func TestFindInstancesByName(t *testing.T) {
 	assert.Equal(t, devicesList.Devices, output)
 }
 
+func TestFetchProjectDevices(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "mock-project-id",
+		},
+	}
+	pages := []metal.DeviceList{
+		{
+			Devices: []metal.Device{{Id: spec.Ptr("device-1")}, {Id: spec.Ptr("device-2")}},
+			Meta:    &metal.Meta{CurrentPage: spec.Ptr(int32(1)), LastPage: spec.Ptr(int32(2))},
+		},
+		{
+			Devices: []metal.Device{{Id: spec.Ptr("device-3")}},
+			Meta:    &metal.Meta{CurrentPage: spec.Ptr(int32(2)), LastPage: spec.Ptr(int32(2))},
+		},
+	}
+	cli.On("FindProjectDevices", ctx, "mock-project-id").Return(
+		metal.ApiFindProjectDevicesRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+	requests := 0
+	DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+		page := pages[requests]
+		requests++
+		return &page, &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	devices, err := a.fetchProjectDevices(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, []metal.Device{{Id: spec.Ptr("device-1")}, {Id: spec.Ptr("device-2")}, {Id: spec.Ptr("device-3")}}, devices)
+}
+
+func TestFindExistingDevice(t *testing.T) {
+	ctx := context.Background()
+	cli := new(MockClient)
+	a := &equinixProvider{
+		cli: cli,
+		cfg: &config.Config{
+			ProjectID: "mock-project-id",
+		},
+		controllerID: "mock-controller-id",
+	}
+	now := time.Now()
+	tags := []string{
+		"Name=test-instance",
+		"garm-controller-id=mock-controller-id",
+	}
+	tests := []struct {
+		name       string
+		devices    []metal.Device
+		expectedID string
+	}{
+		{
+			name:       "no devices",
+			devices:    nil,
+			expectedID: "",
+		},
+		{
+			name: "failed and deleted devices are ignored",
+			devices: []metal.Device{
+				{Id: spec.Ptr("failed"), Tags: tags, State: spec.Ptr(metal.DEVICESTATE_FAILED)},
+				{Id: spec.Ptr("deleted"), Tags: tags, State: spec.Ptr(metal.DEVICESTATE_DELETED)},
+			},
+			expectedID: "",
+		},
+		{
+			name: "oldest provisioning device is returned",
+			devices: []metal.Device{
+				{Id: spec.Ptr("newer"), Tags: tags, State: spec.Ptr(metal.DEVICESTATE_ACTIVE), CreatedAt: spec.Ptr(now)},
+				{Id: spec.Ptr("older"), Tags: tags, State: spec.Ptr(metal.DEVICESTATE_PROVISIONING), CreatedAt: spec.Ptr(now.Add(-time.Minute))},
+				{Id: spec.Ptr("other"), Tags: []string{"Name=other", "garm-controller-id=mock-controller-id"}, State: spec.Ptr(metal.DEVICESTATE_ACTIVE)},
+			},
+			expectedID: "older",
+		},
+	}
+
+	cli.On("FindProjectDevices", ctx, "mock-project-id").Return(
+		metal.ApiFindProjectDevicesRequest{
+			ApiService: &metal.DevicesApiService{},
+		}, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				return &metal.DeviceList{Devices: tt.devices}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			device, err := a.findExistingDevice(ctx, "test-instance")
+			require.NoError(t, err)
+			if tt.expectedID == "" {
+				assert.Nil(t, device)
+			} else {
+				require.NotNil(t, device)
+				assert.Equal(t, tt.expectedID, device.GetId())
+			}
+		})
+	}
+}
+
 func TestDeleteOneInstance(t *testing.T) {
 	ctx := context.Background()
 	instanceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
@@ -295,6 +686,43 @@ func TestDeleteOneInstance(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestDeleteOneInstanceProvisioning(t *testing.T) {
+	ctx := context.Background()
+	instanceID := "76e33e9e-6155-472e-ae76-37b5401f888f"
+	for _, state := range []metal.DeviceState{metal.DEVICESTATE_QUEUED, metal.DEVICESTATE_PROVISIONING} {
+		t.Run(string(state), func(t *testing.T) {
+			finds := 0
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				finds++
+				return &metal.Device{
+					Id:    spec.Ptr(instanceID),
+					State: spec.Ptr(state),
+				}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:          cli,
+				cfg:          &config.Config{},
+				controllerID: "mock-controller-id",
+			}
+			cli.On("FindDeviceById", ctx, instanceID).Return(metal.ApiFindDeviceByIdRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			cli.On("DeleteDevice", ctx, instanceID).Return(metal.ApiDeleteDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNoContent}, nil
+			}
+
+			// The device is deleted without waiting for it to become active.
+			require.NoError(t, a.deleteOneInstance(ctx, instanceID))
+			assert.Equal(t, 1, finds)
+			cli.AssertCalled(t, "DeleteDevice", ctx, instanceID)
+		})
+	}
+}
+
 func TestDeleteOneInstanceErrors(t *testing.T) {
 	ctx := context.Background()
 	cli := new(MockClient)
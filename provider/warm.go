@@ -0,0 +1,201 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+)
+
+// WarmPoolStatus describes the devices kept on standby for a pool, and the
+// changes made by RefillWarmPools.
+type WarmPoolStatus struct {
+	PoolID string `json:"pool_id"`
+	Size   int    `json:"size"`
+	// Ready is the number of devices which can be handed out to runners.
+	Ready int `json:"ready"`
+	// Provisioning is the number of devices still being provisioned.
+	Provisioning int `json:"provisioning"`
+	// Created is the number of devices created to refill the pool.
+	Created int `json:"created"`
+	// Deleted is the number of failed or excess devices deleted.
+	Deleted int `json:"deleted"`
+}
+
+// warmDeviceTags returns the tags of a device pre-provisioned for a pool.
+func (a *equinixProvider) warmDeviceTags(poolID string) []string {
+	names := a.cfg.GetTagNames()
+	return []string{
+		fmt.Sprintf("%s=%s", names.PoolID, poolID),
+		fmt.Sprintf("%s=%s", names.ControllerID, a.controllerID),
+		fmt.Sprintf("%s=true", names.Warm),
+	}
+}
+
+// deleteWarmDevice deletes a warm device, unless it was handed to a runner since
+// it was listed. It returns false if the device was not deleted.
+func (a *equinixProvider) deleteWarmDevice(ctx context.Context, deviceID string) (bool, error) {
+	device, resp, err := DefaultExecuteFindDeviceByID(a.cli.FindDeviceById(ctx, deviceID))
+	if err != nil {
+		if isNotFoundResponse(resp) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to find device %s: %w", deviceID, err)
+	}
+	if device == nil || !isWarmDevice(*device, a.cfg.GetTagNames()) {
+		return false, nil
+	}
+	if err := a.deleteOneInstance(ctx, deviceID); err != nil {
+		return false, fmt.Errorf("failed to delete device %s: %w", deviceID, err)
+	}
+	return true, nil
+}
+
+// refillWarmPool creates and deletes the devices of one warm pool, so that the
+// configured number of devices is on standby. Failed devices are replaced, and
+// devices in excess are deleted, most recent first.
+func (a *equinixProvider) refillWarmPool(ctx context.Context, warmPool config.WarmPoolConfig, devices []metal.Device, dryRun bool) (WarmPoolStatus, error) {
+	status := WarmPoolStatus{
+		PoolID: warmPool.PoolID,
+		Size:   warmPool.Size,
+	}
+
+	var errs []error
+	live := []metal.Device{}
+	for _, device := range devices {
+		switch device.GetState() {
+		case metal.DEVICESTATE_DELETED, metal.DEVICESTATE_DEPROVISIONING:
+			continue
+		case metal.DEVICESTATE_FAILED:
+			if dryRun {
+				status.Deleted++
+				continue
+			}
+			deleted, err := a.deleteWarmDevice(ctx, device.GetId())
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if deleted {
+				status.Deleted++
+			}
+			continue
+		}
+		live = append(live, device)
+	}
+
+	sort.SliceStable(live, func(i, j int) bool {
+		return live[i].GetCreatedAt().Before(live[j].GetCreatedAt())
+	})
+	for len(live) > warmPool.Size {
+		device := live[len(live)-1]
+		live = live[:len(live)-1]
+		if dryRun {
+			status.Deleted++
+			continue
+		}
+		deleted, err := a.deleteWarmDevice(ctx, device.GetId())
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if deleted {
+			status.Deleted++
+		}
+	}
+
+	for _, device := range live {
+		if device.GetState() == metal.DEVICESTATE_ACTIVE {
+			status.Ready++
+		} else {
+			status.Provisioning++
+		}
+	}
+
+	for i := len(live); i < warmPool.Size; i++ {
+		if dryRun {
+			status.Created++
+			continue
+		}
+		input := metal.DeviceCreateInMetroInput{
+			Metro:                 warmPool.MetroCode,
+			Plan:                  warmPool.Plan,
+			OperatingSystem:       warmPool.OperatingSystem,
+			Tags:                  a.warmDeviceTags(warmPool.PoolID),
+			Customdata:            a.newDeviceMetadata(warmPool.PoolID, nil).customdata(),
+			HardwareReservationId: warmPool.HardwareReservationID,
+		}
+		// Warm devices count towards the quotas of the controller.
+		device, err := a.createDevice(ctx, input)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		a.recordHostname(device.GetHostname(), warmPool.PoolID)
+		status.Created++
+		status.Provisioning++
+	}
+	return status, errors.Join(errs...)
+}
+
+// RefillWarmPools creates the devices missing from the configured warm pools, and
+// deletes the failed and excess ones. Devices are created without waiting for them
+// to become active. If dryRun is true, no device is created or deleted.
+//
+// The refill is serialized with the claims of standby devices, so that a device
+// handed to a runner after being listed is not deleted. Devices are also read back
+// before being deleted, in case they were claimed by another host.
+func (a *equinixProvider) RefillWarmPools(ctx context.Context, dryRun bool) ([]WarmPoolStatus, error) {
+	if a.controllerID == "" {
+		return nil, fmt.Errorf("a controller ID is required to refill warm pools")
+	}
+	if !dryRun {
+		unlock, err := a.lockState(ctx, "standby.lock")
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	devices, err := a.fetchProjectDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	names := a.cfg.GetTagNames()
+	byPool := map[string][]metal.Device{}
+	for _, device := range devices {
+		if !a.isManagedDevice(device) || !isWarmDevice(device, names) {
+			continue
+		}
+		poolID := deviceMetadataOf(device, names).PoolID
+		byPool[poolID] = append(byPool[poolID], device)
+	}
+
+	var errs []error
+	ret := []WarmPoolStatus{}
+	for _, warmPool := range a.cfg.WarmPools {
+		warmPool, _ = a.cfg.GetWarmPool(warmPool.PoolID)
+		status, err := a.refillWarmPool(ctx, warmPool, byPool[warmPool.PoolID], dryRun)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to refill warm pool %s: %w", warmPool.PoolID, err))
+		}
+		ret = append(ret, status)
+	}
+	return ret, errors.Join(errs...)
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cloudbase/garm-provider-equinix/config"
+	"github.com/cloudbase/garm-provider-equinix/internal/spec"
+	metal "github.com/equinix/equinix-sdk-go/services/metalv1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func warmTestDevice(id, poolID string, state metal.DeviceState, createdAt time.Time) metal.Device {
+	return metal.Device{
+		Id: spec.Ptr(id),
+		Tags: []string{
+			"garm-pool-id=" + poolID,
+			"garm-controller-id=mock-controller-id",
+			"garm-warm=true",
+		},
+		State:     spec.Ptr(state),
+		CreatedAt: spec.Ptr(createdAt),
+	}
+}
+
+func TestRefillWarmPools(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	devices := []metal.Device{
+		warmTestDevice("76e33e9e-6155-472e-ae76-37b5401f888f", "pool-1", metal.DEVICESTATE_ACTIVE, now.Add(-time.Hour)),
+		warmTestDevice("a1b2c3d4-6155-472e-ae76-37b5401f888f", "pool-1", metal.DEVICESTATE_FAILED, now.Add(-time.Hour)),
+		warmTestDevice("e5f6a7b8-6155-472e-ae76-37b5401f888f", "pool-2", metal.DEVICESTATE_ACTIVE, now.Add(-time.Hour)),
+		warmTestDevice("c9d0e1f2-6155-472e-ae76-37b5401f888f", "pool-2", metal.DEVICESTATE_PROVISIONING, now.Add(-time.Minute)),
+		warmTestDevice("d3e2d284-6155-472e-ae76-37b5401f888f", "unknown-pool", metal.DEVICESTATE_ACTIVE, now.Add(-time.Hour)),
+	}
+	cfg := &config.Config{
+		ProjectID: "project",
+		MetroCode: "AM",
+		StateDir:  t.TempDir(),
+		WarmPools: []config.WarmPoolConfig{
+			{PoolID: "pool-1", Size: 3, Plan: "c3.small.x86", OperatingSystem: "ubuntu_22_04"},
+			{PoolID: "pool-2", Size: 1, Plan: "c3.small.x86", OperatingSystem: "ubuntu_22_04"},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		dryRun           bool
+		claimed          bool
+		expectedStatuses []WarmPoolStatus
+		expectedCreated  int
+		expectedDeleted  []string
+	}{
+		{
+			name: "refill",
+			expectedStatuses: []WarmPoolStatus{
+				{PoolID: "pool-1", Size: 3, Ready: 1, Provisioning: 2, Created: 2, Deleted: 1},
+				{PoolID: "pool-2", Size: 1, Ready: 1, Deleted: 1},
+			},
+			expectedCreated: 2,
+			expectedDeleted: []string{devices[1].GetId(), devices[3].GetId()},
+		},
+		{
+			name:    "devices claimed since listed",
+			claimed: true,
+			expectedStatuses: []WarmPoolStatus{
+				{PoolID: "pool-1", Size: 3, Ready: 1, Provisioning: 2, Created: 2},
+				{PoolID: "pool-2", Size: 1, Ready: 1},
+			},
+			expectedCreated: 2,
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			expectedStatuses: []WarmPoolStatus{
+				{PoolID: "pool-1", Size: 3, Ready: 1, Created: 2, Deleted: 1},
+				{PoolID: "pool-2", Size: 1, Ready: 1, Deleted: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := new(MockClient)
+			a := &equinixProvider{
+				cli:          cli,
+				cfg:          cfg,
+				controllerID: "mock-controller-id",
+			}
+			cli.On("FindProjectDevices", ctx, "project").Return(metal.ApiFindProjectDevicesRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil)
+			DefaultExecuteFindProjectDevices = func(r metal.ApiFindProjectDevicesRequest) (*metal.DeviceList, *http.Response, error) {
+				return &metal.DeviceList{Devices: devices}, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			created := 0
+			cli.On("CreateDevice", ctx, "project").Return(metal.ApiCreateDeviceRequest{
+				ApiService: &metal.DevicesApiService{},
+			}, nil).Run(func(_ mock.Arguments) {
+				created++
+			})
+			DefaultExecuteCreateDevice = func(r metal.ApiCreateDeviceRequest) (*metal.Device, *http.Response, error) {
+				return &metal.Device{Id: spec.Ptr("new-id")}, &http.Response{StatusCode: http.StatusCreated}, nil
+			}
+			for _, device := range devices {
+				device := device
+				cli.On("FindDeviceById", ctx, device.GetId()).Return(metal.ApiFindDeviceByIdRequest{
+					ApiService: &metal.DevicesApiService{},
+				}, nil)
+				cli.On("DeleteDevice", ctx, device.GetId()).Return(metal.ApiDeleteDeviceRequest{
+					ApiService: &metal.DevicesApiService{},
+				}, nil)
+			}
+			DefaultExecuteFindDeviceByID = func(r metal.ApiFindDeviceByIdRequest) (*metal.Device, *http.Response, error) {
+				device := &metal.Device{State: spec.Ptr(metal.DEVICESTATE_ACTIVE), Tags: []string{"garm-warm=true"}}
+				if tt.claimed {
+					// The devices were handed to runners, which removed the warm tag.
+					device.Tags = []string{"Name=runner-1"}
+				}
+				return device, &http.Response{StatusCode: http.StatusOK}, nil
+			}
+			DefaultExecuteDeleteDevice = func(r metal.ApiDeleteDeviceRequest) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNoContent}, nil
+			}
+
+			statuses, err := a.RefillWarmPools(ctx, tt.dryRun)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatuses, statuses)
+			assert.Equal(t, tt.expectedCreated, created)
+			for _, device := range devices {
+				if slices.Contains(tt.expectedDeleted, device.GetId()) {
+					cli.AssertCalled(t, "DeleteDevice", ctx, device.GetId())
+				} else {
+					cli.AssertNotCalled(t, "DeleteDevice", ctx, device.GetId())
+				}
+			}
+		})
+	}
+}
+
+func TestRefillWarmPoolsRequiresControllerID(t *testing.T) {
+	a := &equinixProvider{
+		cli: new(MockClient),
+		cfg: &config.Config{ProjectID: "project"},
+	}
+	_, err := a.RefillWarmPools(context.Background(), false)
+	assert.ErrorContains(t, err, "a controller ID is required")
+}